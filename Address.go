@@ -0,0 +1,160 @@
+package menubotlib
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The label of an address made from a shared location pin without a name.
+const sharedLocationLabel = "pin"
+
+var ErrNoAddressRepository = errors.New("address book is not set up")
+
+// Address is an entry of a customer's address book, one of the entries is their default.
+type Address struct {
+	AddressID  int
+	CellNumber string
+	// Label names the address for the customer, e.g. home or work, it is unique per customer.
+	Label      string
+	Street     string
+	Suburb     string
+	PostalCode string
+	// Point is known for addresses shared as a location pin.
+	Point     *GeoPoint
+	IsDefault bool
+}
+
+// AddressRepository keeps the customers' address books.
+type AddressRepository interface {
+	// ListAddresses returns the customer's addresses in the order they were added.
+	ListAddresses(cellNumber string) ([]Address, error)
+	// SaveAddress inserts the address, or replaces the customer's address with the same label,
+	// and returns its ID. The customer's first address becomes their default.
+	SaveAddress(a Address) (int, error)
+	// SetDefaultAddress returns ErrNoRows when the customer has no such address.
+	SetDefaultAddress(cellNumber string, addressID int) error
+	// DeleteAddress removes the address, when it was the default the oldest remaining address takes over.
+	DeleteAddress(cellNumber string, addressID int) error
+}
+
+func NormaliseAddressLabel(label string) string {
+	return strings.ToLower(strings.TrimSpace(label))
+}
+
+// Numbers pick addresses by their place in the address book list, so a label can't be one.
+func isAddressNumber(label string) bool {
+	_, err := strconv.Atoi(label)
+	return err == nil
+}
+
+// DeliveryLocation is where an order to the address goes.
+func (a Address) DeliveryLocation() DeliveryLocation {
+	return DeliveryLocation{AddressID: a.AddressID, Address: a.Street, Area: a.Suburb, PostalCode: a.PostalCode, Point: a.Point}
+}
+
+func (a Address) String() string {
+	return a.DeliveryLocation().String()
+}
+
+// ParseAddress reads "label, street, suburb, postal code". The postal code is the last part when it is
+// all digits and the suburb the last part before it, everything between the label and the suburb is the street.
+// A label with just a suburb or postal code is enough, a label that is a number isn't.
+func ParseAddress(cellNumber, text string) (Address, error) {
+	var parts []string
+	for _, part := range strings.Split(text, ",") {
+		part = strings.TrimSpace(part)
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) < 2 {
+		return Address{}, fmt.Errorf("an address needs a label and at least a suburb or postal code, e.g. %s", addAddressCommand)
+	}
+
+	a := Address{CellNumber: cellNumber, Label: NormaliseAddressLabel(parts[0])}
+	if isAddressNumber(a.Label) {
+		return Address{}, fmt.Errorf("please name the address with a word instead of %s, e.g. %s", a.Label, addAddressCommand)
+	}
+	rest := parts[1:]
+	if _, err := strconv.Atoi(rest[len(rest)-1]); err == nil {
+		a.PostalCode = rest[len(rest)-1]
+		rest = rest[:len(rest)-1]
+	}
+	if len(rest) > 0 {
+		a.Suburb = rest[len(rest)-1]
+		a.Street = strings.Join(rest[:len(rest)-1], ", ")
+	}
+	return a, nil
+}
+
+// AddressFromLocation makes an address of a shared location pin, labelled with the pin's name
+// unless it has none or it is a number.
+func AddressFromLocation(cellNumber string, location SharedLocation) Address {
+	label := NormaliseAddressLabel(location.Name)
+	if label == "" || isAddressNumber(label) {
+		label = sharedLocationLabel
+	}
+	return Address{
+		CellNumber: cellNumber,
+		Label:      label,
+		Street:     strings.TrimSpace(location.Address),
+		Point:      &GeoPoint{Latitude: location.Latitude, Longitude: location.Longitude},
+	}
+}
+
+// FindAddress finds an address by its number in the address book list or by its label.
+func FindAddress(addresses []Address, labelOrNumber string) (Address, bool) {
+	labelOrNumber = NormaliseAddressLabel(labelOrNumber)
+	if number, err := strconv.Atoi(labelOrNumber); err == nil && number >= 1 && number <= len(addresses) {
+		return addresses[number-1], true
+	}
+	for _, a := range addresses {
+		if a.Label == labelOrNumber {
+			return a, true
+		}
+	}
+	return Address{}, false
+}
+
+func DefaultAddress(addresses []Address) (Address, bool) {
+	for _, a := range addresses {
+		if a.IsDefault {
+			return a, true
+		}
+	}
+	return Address{}, false
+}
+
+// FormatAddressBook numbers the addresses the way FindAddress reads them.
+func FormatAddressBook(addresses []Address) string {
+	if len(addresses) == 0 {
+		return "You have no saved addresses.\nTo add one type & send-: " + addAddressCommand + "\nor share a location pin."
+	}
+
+	book := "Your addresses:"
+	for i, a := range addresses {
+		book += fmt.Sprintf("\n%d. *%s*", i+1, a.Label)
+		if a.IsDefault {
+			book += " (default)"
+		}
+		book += "\n  " + a.String()
+	}
+	return book + "\n\nTo deliver to one type & send-: use address: " + addresses[0].Label
+}
+
+// Delivers the order to the customer's default address when it is to be delivered and has nowhere to go yet.
+func (c *CustomerOrder) useDefaultAddress(addresses AddressRepository, delivery *DeliveryConfig) error {
+	if addresses == nil || delivery.FulfilmentOf(*c) != FulfilmentDelivery || !c.DeliveryLocation.IsZero() {
+		return nil
+	}
+	book, err := addresses.ListAddresses(c.CellNumber)
+	if err != nil {
+		return err
+	}
+	if a, ok := DefaultAddress(book); ok {
+		c.DeliveryLocation = a.DeliveryLocation()
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package menubotlib
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    Address
+		wantErr string
+	}{
+		{"every part", "Home, 12 Main Rd, Gardens, 8001", Address{Label: "home", Street: "12 Main Rd", Suburb: "Gardens", PostalCode: "8001"}, ""},
+		{"street with a comma", "work, Unit 4, 1 Long St, Gardens, 8001", Address{Label: "work", Street: "Unit 4, 1 Long St", Suburb: "Gardens", PostalCode: "8001"}, ""},
+		{"no postal code", "home, 12 Main Rd, Gardens", Address{Label: "home", Street: "12 Main Rd", Suburb: "Gardens"}, ""},
+		{"just a suburb", "home, Gardens", Address{Label: "home", Suburb: "Gardens"}, ""},
+		{"just a postal code", "home, 8001", Address{Label: "home", PostalCode: "8001"}, ""},
+		{"empty parts are skipped", " home ,, Gardens , ", Address{Label: "home", Suburb: "Gardens"}, ""},
+		{"label only", "home", Address{}, "needs a label and at least a suburb or postal code"},
+		{"nothing", "", Address{}, "needs a label and at least a suburb or postal code"},
+		{"number as the label", "2, Gardens, 8001", Address{}, "instead of 2"},
+		{"signed number as the label", "+2, Gardens", Address{}, "instead of +2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseAddress(testCellNumber, tt.text)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.want.CellNumber = testCellNumber
+			if got != tt.want {
+				t.Errorf("ParseAddress(%q) = %+v, want %+v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindAddress(t *testing.T) {
+	book := []Address{
+		{AddressID: 7, Label: "home"},
+		{AddressID: 8, Label: "work"},
+		{AddressID: 9, Label: "mom's place"},
+	}
+	tests := []struct {
+		name          string
+		labelOrNumber string
+		wantID        int
+	}{
+		{"label", "work", 8},
+		{"label in another case", " Mom's Place ", 9},
+		{"number", "1", 7},
+		{"last number", "3", 9},
+		{"number past the end", "4", 0},
+		{"zero", "0", 0},
+		{"unknown label", "gym", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := FindAddress(book, tt.labelOrNumber)
+			if ok != (tt.wantID != 0) || got.AddressID != tt.wantID {
+				t.Errorf("FindAddress(%q) = %d, %t, want %d", tt.labelOrNumber, got.AddressID, ok, tt.wantID)
+			}
+		})
+	}
+
+	// Every label ParseAddress accepts is found by its label, not taken for a number
+	for _, text := range []string{"2nd home, Gardens", "1, Gardens", "10, Gardens"} {
+		parsed, err := ParseAddress(testCellNumber, text)
+		if err != nil {
+			continue
+		}
+		parsed.AddressID = 10
+		got, ok := FindAddress(append(book, parsed), parsed.Label)
+		if !ok || got.AddressID != 10 {
+			t.Errorf("FindAddress(%q) = %d, %t, want the address labelled %q", parsed.Label, got.AddressID, ok, parsed.Label)
+		}
+	}
+}
+
+func TestAddressFromLocation(t *testing.T) {
+	tests := []struct {
+		name       string
+		location   SharedLocation
+		wantLabel  string
+		wantStreet string
+	}{
+		{"named pin", SharedLocation{Latitude: -33.92, Longitude: 18.42, Name: " The Office ", Address: " 1 Long St "}, "the office", "1 Long St"},
+		{"unnamed pin", SharedLocation{Latitude: -33.92, Longitude: 18.42}, sharedLocationLabel, ""},
+		{"pin named with a number", SharedLocation{Latitude: -33.92, Longitude: 18.42, Name: "2"}, sharedLocationLabel, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := AddressFromLocation(testCellNumber, tt.location)
+			if a.CellNumber != testCellNumber || a.Label != tt.wantLabel || a.Street != tt.wantStreet {
+				t.Errorf("address = %+v, want %q at %q", a, tt.wantLabel, tt.wantStreet)
+			}
+			if a.Point == nil || a.Point.Latitude != tt.location.Latitude || a.Point.Longitude != tt.location.Longitude {
+				t.Errorf("point = %v, want the pin's coordinates", a.Point)
+			}
+			if location := a.DeliveryLocation(); location.Point != a.Point || location.Address != a.Street {
+				t.Errorf("delivery location = %+v, want the pin", location)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package menubotlib
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The rule between the items and the total, WhatsApp has no tables so the layout is kept to one item per block.
+const cartSummaryRule = "──────────"
+
+// CartLinePart is one priced quantity of an order line, a single item ordered in two options has two parts.
+// Weight items have a Unit and are priced per unit, single items are described by their option.
+type CartLinePart struct {
+	// Option is the option number of a single item, weight items leave it at 0.
+	Option      int
+	Description string
+	Unit        string
+	Quantity    int
+	UnitPrice   Money
+	Total       Money
+}
+
+// FormatCartSummary lists each item of the order by name with its options, quantities, unit prices
+// and line totals, followed by any discounts, the delivery fee, tax and the grand total. Tax included in the prices
+// is shown under the total. Bold and italics use WhatsApp's *bold* and _italic_ markers.
+// The pricing is passed in so a total frozen at checkout is shown rather than one at today's prices.
+func FormatCartSummary(items OrderItems, ctlgselections []CatalogueSelection, pricing OrderPricing) string {
+	if len(items.MenuIndications) == 0 {
+		return "Your cart is empty."
+	}
+
+	var blocks []string
+	for _, line := range items.PriceLines(ctlgselections) {
+		blocks = append(blocks, formatCartLine(line))
+	}
+	summary := strings.Join(blocks, "\n") + "\n" + cartSummaryRule
+	if len(pricing.Discounts) > 0 || pricing.Fulfilment != "" || len(pricing.Taxes) > 0 {
+		summary += "\nSubtotal: " + pricing.Subtotal.String()
+	}
+	for _, discount := range pricing.Discounts {
+		summary += fmt.Sprintf("\n_%s (%s)_: -%s", discount.Description, discount.Code, discount.Amount)
+	}
+	switch pricing.Fulfilment {
+	case FulfilmentPickup:
+		summary += "\nPickup: free"
+	case FulfilmentDelivery:
+		summary += "\n" + formatDeliveryLine(pricing)
+	}
+	if !pricing.TaxIncluded {
+		for _, tax := range pricing.Taxes {
+			summary += fmt.Sprintf("\n%s: %s", tax.Rate(), tax.Amount)
+		}
+	}
+	summary += "\n*Total: " + pricing.Total.String() + "*"
+	if pricing.TaxIncluded {
+		for _, tax := range pricing.Taxes {
+			summary += fmt.Sprintf("\n_Includes %s: %s_", tax.Rate(), tax.Amount)
+		}
+	}
+	if pricing.TaxNumber != "" && len(pricing.Taxes) > 0 {
+		summary += "\n_" + pricing.TaxNumber + "_"
+	}
+	return summary
+}
+
+// A frozen order's pricing doesn't name the zone, an order not in a zone yet has no fee.
+func formatDeliveryLine(pricing OrderPricing) string {
+	label := "Delivery"
+	if pricing.DeliveryZone != "" {
+		label += " (" + pricing.DeliveryZone + ")"
+	}
+	if pricing.DeliveryFee.IsZero() {
+		if pricing.DeliveryZone == "" {
+			return label + ": _to be confirmed_"
+		}
+		return label + ": free"
+	}
+	return label + ": " + pricing.DeliveryFee.String()
+}
+
+func formatCartLine(line OrderLine) string {
+	if line.Err != nil {
+		if line.Item.Item == "" {
+			return fmt.Sprintf("*Item %d* _%s, not on the price list_", line.ItemMenuNum, line.ItemAmount)
+		}
+		return fmt.Sprintf("*%s* _%s, could not be priced_", line.Item.Item, line.ItemAmount)
+	}
+
+	parts := CartLineParts(line)
+	block := fmt.Sprintf("*%s* %s", line.Item.Item, line.Subtotal)
+	for _, part := range parts {
+		if part.Unit != "" {
+			block += fmt.Sprintf("\n  %d%s @ %s/%s = %s", part.Quantity, part.Unit, part.UnitPrice, part.Unit, part.Total)
+			continue
+		}
+		block += fmt.Sprintf("\n  %d x %s @ %s = %s", part.Quantity, part.Description, part.UnitPrice, part.Total)
+	}
+	return block
+}
+
+// CartLineParts breaks a priced line into its quantities, nil for a line that couldn't be priced.
+func CartLineParts(line OrderLine) []CartLinePart {
+	if line.Err != nil {
+		return nil
+	}
+
+	switch line.Item.PricingType {
+	case WeightItem:
+		weight, err := strconv.Atoi(line.ItemAmount)
+		if err != nil {
+			return nil
+		}
+		price, err := findBestPrice(weight, line.Item.Options)
+		if err != nil {
+			return nil
+		}
+		return []CartLinePart{{Description: line.Item.Options[0].Label, Unit: line.Item.Options[0].Unit, Quantity: weight, UnitPrice: price, Total: price.Mul(int64(weight))}}
+	case SingleItem:
+		var parts []CartLinePart
+		for _, userItem := range strings.Split(line.ItemAmount, ",") {
+			var optionNumber, quantity int
+			_, err := fmt.Sscanf(strings.TrimSpace(userItem), "%dx%d", &optionNumber, &quantity)
+			if err != nil || optionNumber <= 0 || optionNumber > len(line.Item.Options) {
+				return nil
+			}
+			option := line.Item.Options[optionNumber-1]
+			parts = append(parts, CartLinePart{Option: optionNumber, Description: option.Label, Quantity: quantity, UnitPrice: option.Price, Total: option.Price.Mul(int64(quantity))})
+		}
+		return parts
+	}
+	return nil
+}
@@ -1,13 +1,20 @@
 package menubotlib
 
 import (
-	"database/sql"
+	"log"
+	"strings"
 	"time"
 )
 
 type Pricelist struct {
 	PrlstPreamble string
 	Catalogue     []CatalogueSelection
+	// Promotions are the running promotions orders are priced with, NewConversationContext fills them in.
+	Promotions []Promotion
+	// Delivery is how orders reach customers, nil charges no delivery fees.
+	Delivery *DeliveryConfig
+	// Tax is how orders are taxed, nil charges no tax.
+	Tax *TaxConfig
 }
 
 type ConversationContext struct {
@@ -16,11 +23,20 @@ type ConversationContext struct {
 	Pricelist    Pricelist
 	CurrentOrder CustomerOrder
 	MessageBody  string
-	DBReadTime   time.Time
+	// Location is the pin the message shared, if it shared one.
+	Location   *SharedLocation
+	DBReadTime time.Time
 }
 
-func NewConversationContext(db *sql.DB, senderNumber, messagebody string, prlst Pricelist, isAutoInc bool) *ConversationContext {
-	userInfo, curOrder, userExisted := NewUserInfo(db, senderNumber, isAutoInc)
+func NewConversationContext(store Store, senderNumber, messagebody string, prlst Pricelist, isAutoInc bool) *ConversationContext {
+	userInfo, curOrder, userExisted := NewUserInfo(store, senderNumber, isAutoInc)
+	// The price list is shared between conversations, stock markers go on a copy
+	prlst.Catalogue = WithStockLevels(store.Stock, prlst.Catalogue)
+	if prlst.Promotions == nil {
+		prlst.Promotions = ActivePromotions(store.Promotions)
+	}
+	prlst.Promotions = customerPromotions(store.Orders, userInfo.CellNumber, prlst.Promotions)
+	prlst = prlst.oneCurrency()
 	context := &ConversationContext{
 		UserInfo:     userInfo,
 		UserExisted:  userExisted,
@@ -32,3 +48,74 @@ func NewConversationContext(db *sql.DB, senderNumber, messagebody string, prlst
 
 	return context
 }
+
+// Leaves out whatever is priced in another currency than the catalogue, so pricing never meets
+// two currencies. The catalogue's currency is that of its first option, items with an option in
+// another currency are left out too. What is left out is logged.
+func (p Pricelist) oneCurrency() Pricelist {
+	currency := ""
+	for _, selection := range p.Catalogue {
+		for _, item := range selection.Items {
+			if currency == "" && len(item.Options) > 0 {
+				currency = item.Options[0].Price.Currency
+			}
+		}
+	}
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+	foreign := func(m Money) bool {
+		return !m.IsZero() && m.Currency != "" && !strings.EqualFold(m.Currency, currency)
+	}
+
+	catalogue := make([]CatalogueSelection, 0, len(p.Catalogue))
+	for _, selection := range p.Catalogue {
+		items := make([]CatalogueItem, 0, len(selection.Items))
+		for _, item := range selection.Items {
+			priced := true
+			for _, option := range item.Options {
+				priced = priced && !foreign(option.Price)
+			}
+			if !priced {
+				log.Printf("leaving item %d %s out of the price list, it isn't priced in %s", item.CatalogueItemID, item.Item, currency)
+				continue
+			}
+			items = append(items, item)
+		}
+		selection.Items = items
+		catalogue = append(catalogue, selection)
+	}
+	p.Catalogue = catalogue
+
+	var promotions []Promotion
+	for _, promotion := range p.Promotions {
+		if foreign(promotion.Amount) {
+			log.Printf("leaving promotion %s out, %s isn't in %s", promotion.Code, promotion.Amount, currency)
+			continue
+		}
+		promotions = append(promotions, promotion)
+	}
+	p.Promotions = promotions
+
+	if p.Delivery != nil {
+		delivery := *p.Delivery
+		if foreign(delivery.MinimumOrder) {
+			log.Printf("ignoring the minimum order of %s, it isn't in %s", delivery.MinimumOrder, currency)
+			delivery.MinimumOrder = NewMoney(0, currency)
+		}
+		delivery.Zones = nil
+		for _, zone := range p.Delivery.Zones {
+			priced := !foreign(zone.Fee) && !foreign(zone.FreeFrom) && !foreign(zone.MinimumOrder)
+			for _, tier := range zone.FeeTiers {
+				priced = priced && !foreign(tier.From) && !foreign(tier.Fee)
+			}
+			if !priced {
+				log.Printf("leaving delivery zone %s out, its amounts aren't in %s", zone.Name, currency)
+				continue
+			}
+			delivery.Zones = append(delivery.Zones, zone)
+		}
+		p.Delivery = &delivery
+	}
+	return p
+}
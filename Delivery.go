@@ -0,0 +1,313 @@
+package menubotlib
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Fulfilment is how the customer gets their order.
+type Fulfilment string
+
+const (
+	FulfilmentDelivery Fulfilment = "delivery"
+	FulfilmentPickup   Fulfilment = "pickup"
+)
+
+// A location given as "latitude, longitude", e.g. -33.9249, 18.4241.
+var regexGeoPoint = regexp.MustCompile(`^(-?\d{1,2}(?:\.\d+)?)\s*,\s*(-?\d{1,3}(?:\.\d+)?)$`)
+
+const earthRadiusKm = 6371.0
+
+type GeoPoint struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// DistanceKm is the great circle distance between the points.
+func (p GeoPoint) DistanceKm(q GeoPoint) float64 {
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := toRad(q.Latitude - p.Latitude)
+	dLng := toRad(q.Longitude - p.Longitude)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(toRad(p.Latitude))*math.Cos(toRad(q.Latitude))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+// DeliveryLocation is where an order goes, a suburb or postal code as the customer gave it
+// or the parts of an address, and the coordinates when they are known.
+type DeliveryLocation struct {
+	// AddressID is the address book entry the location was taken from, 0 for one typed in.
+	AddressID int
+	// Address is the street part of an address book entry, it is shown but not matched.
+	Address    string
+	Area       string
+	PostalCode string
+	Point      *GeoPoint
+}
+
+// ParseDeliveryLocation reads "latitude, longitude" as coordinates and anything else as a suburb or postal code.
+func ParseDeliveryLocation(text string) DeliveryLocation {
+	text = strings.TrimSpace(text)
+	match := regexGeoPoint.FindStringSubmatch(text)
+	if match != nil {
+		lat, latErr := strconv.ParseFloat(match[1], 64)
+		lng, lngErr := strconv.ParseFloat(match[2], 64)
+		if latErr == nil && lngErr == nil && math.Abs(lat) <= 90 && math.Abs(lng) <= 180 {
+			return DeliveryLocation{Area: text, Point: &GeoPoint{Latitude: lat, Longitude: lng}}
+		}
+	}
+	return DeliveryLocation{Area: text}
+}
+
+func (l DeliveryLocation) IsZero() bool {
+	return l.Address == "" && l.Area == "" && l.PostalCode == "" && l.Point == nil
+}
+
+// String is the location as the customer would write it, coordinates when there is nothing else.
+func (l DeliveryLocation) String() string {
+	var parts []string
+	for _, part := range []string{l.Address, l.Area, l.PostalCode} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) == 0 && l.Point != nil {
+		return fmt.Sprintf("%g, %g", l.Point.Latitude, l.Point.Longitude)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// DeliveryZone is an area delivered to at one fee. An order is in the zone when its area is one of
+// the Suburbs or PostalCodes, or its coordinates are within RadiusKm of the Centre.
+type DeliveryZone struct {
+	Name        string
+	Suburbs     []string
+	PostalCodes []string
+	Centre      *GeoPoint
+	RadiusKm    float64
+	Fee         Money
+	// FeeTiers charge other fees for larger orders, the tier with the highest From the order reaches
+	// applies in place of Fee.
+	FeeTiers []FeeTier
+	// Deliveries of orders worth FreeFrom or more are free, zero never is.
+	FreeFrom Money
+	// MinimumOrder is what an order has to be worth to be delivered to the zone, on top of the config's minimum.
+	MinimumOrder Money
+}
+
+// FeeTier is the delivery fee of orders worth From or more.
+type FeeTier struct {
+	From Money
+	Fee  Money
+}
+
+func (z DeliveryZone) Contains(location DeliveryLocation) bool {
+	area := strings.TrimSpace(location.Area)
+	postalCode := strings.TrimSpace(location.PostalCode)
+	for _, suburb := range z.Suburbs {
+		if area != "" && strings.EqualFold(strings.TrimSpace(suburb), area) {
+			return true
+		}
+	}
+	for _, code := range z.PostalCodes {
+		code = strings.TrimSpace(code)
+		if (area != "" && code == area) || (postalCode != "" && code == postalCode) {
+			return true
+		}
+	}
+	return z.Centre != nil && location.Point != nil && z.Centre.DistanceKm(*location.Point) <= z.RadiusKm
+}
+
+// FeeFor is what delivering an order worth orderValue to the zone costs.
+func (z DeliveryZone) FeeFor(orderValue Money) Money {
+	if !z.FreeFrom.IsZero() && orderValue.Cmp(z.FreeFrom) >= 0 {
+		return NewMoney(0, DefaultCurrency)
+	}
+	fee := z.Fee
+	var reached *FeeTier
+	for i, tier := range z.FeeTiers {
+		if orderValue.Cmp(tier.From) >= 0 && (reached == nil || tier.From.Cmp(reached.From) > 0) {
+			reached = &z.FeeTiers[i]
+		}
+	}
+	if reached != nil {
+		fee = reached.Fee
+	}
+	return fee
+}
+
+// DeliveryConfig is how the shop gets orders to its customers, a Pricelist without one charges no fees
+// and doesn't ask customers to choose.
+type DeliveryConfig struct {
+	// Zones are matched in order, the first that contains the location is used.
+	Zones         []DeliveryZone
+	Pickup        bool
+	PickupAddress string
+	// MinimumOrder applies to every order, delivered or picked up.
+	MinimumOrder Money
+}
+
+// String lists the zones with their fees and minimums and the pickup address, for the customer.
+func (d *DeliveryConfig) String() string {
+	if d == nil || (len(d.Zones) == 0 && !d.Pickup) {
+		return "Please ask us how to get your order."
+	}
+
+	var lines []string
+	if !d.MinimumOrder.IsZero() {
+		lines = append(lines, "The minimum order is "+d.MinimumOrder.String()+".")
+	}
+	for _, zone := range d.Zones {
+		line := fmt.Sprintf("*%s* %s", zone.Name, zone.Fee)
+		for _, tier := range zone.FeeTiers {
+			line += fmt.Sprintf(", %s from %s", tier.Fee, tier.From)
+		}
+		if !zone.FreeFrom.IsZero() {
+			line += ", free from " + zone.FreeFrom.String()
+		}
+		if !zone.MinimumOrder.IsZero() {
+			line += ", minimum order " + zone.MinimumOrder.String()
+		}
+		var areas []string
+		areas = append(areas, zone.Suburbs...)
+		areas = append(areas, zone.PostalCodes...)
+		if zone.Centre != nil && zone.RadiusKm > 0 {
+			areas = append(areas, fmt.Sprintf("within %gkm", zone.RadiusKm))
+		}
+		if len(areas) > 0 {
+			line += "\n  " + strings.Join(areas, ", ")
+		}
+		lines = append(lines, line)
+	}
+	if len(d.Zones) > 0 {
+		lines = append(lines, "To have your order delivered type & send-: "+deliverToCommand)
+	}
+	if d.Pickup {
+		pickup := "To collect your order free of charge type & send-: " + pickupCommand
+		if d.PickupAddress != "" {
+			pickup = "Collect your order free of charge from " + d.PickupAddress + ", type & send-: " + pickupCommand
+		}
+		lines = append(lines, pickup)
+	}
+	return strings.Join(lines, "\n\n")
+}
+
+// ZoneFor finds the zone a location is in.
+func (d *DeliveryConfig) ZoneFor(location DeliveryLocation) (DeliveryZone, bool) {
+	if d == nil || location.IsZero() {
+		return DeliveryZone{}, false
+	}
+	for _, zone := range d.Zones {
+		if zone.Contains(location) {
+			return zone, true
+		}
+	}
+	return DeliveryZone{}, false
+}
+
+// The fulfilment of an order that hasn't chosen, when the shop offers only one there is nothing to choose.
+func (d *DeliveryConfig) defaultFulfilment() Fulfilment {
+	switch {
+	case d == nil:
+		return ""
+	case len(d.Zones) > 0 && !d.Pickup:
+		return FulfilmentDelivery
+	case len(d.Zones) == 0 && d.Pickup:
+		return FulfilmentPickup
+	}
+	return ""
+}
+
+// FulfilmentOf is the order's chosen fulfilment or the only one the shop offers.
+func (d *DeliveryConfig) FulfilmentOf(c CustomerOrder) Fulfilment {
+	if c.Fulfilment != "" {
+		return c.Fulfilment
+	}
+	return d.defaultFulfilment()
+}
+
+// Adds the delivery fee of the order's zone to the pricing, orders picked up or not in a zone pay none.
+// Free delivery is judged on what the items cost after discounts.
+func (d *DeliveryConfig) priceDelivery(c CustomerOrder, pricing OrderPricing) OrderPricing {
+	pricing.Fulfilment = d.FulfilmentOf(c)
+	if pricing.Fulfilment != FulfilmentDelivery {
+		return pricing
+	}
+	zone, ok := d.ZoneFor(c.DeliveryLocation)
+	if !ok {
+		return pricing
+	}
+	pricing.DeliveryZone = zone.Name
+	pricing.DeliveryFee = zone.FeeFor(pricing.Total)
+	pricing.Total = pricing.Total.Add(pricing.DeliveryFee)
+	return pricing
+}
+
+// FulfilmentError is why an order can't be checked out the way it is to be fulfilled, Reason is written for the customer.
+type FulfilmentError struct {
+	Reason string
+}
+
+func (e *FulfilmentError) Error() string {
+	return "fulfilment: " + e.Reason
+}
+
+// CheckFulfilment checks the order can be checked out: pickup or delivery is chosen and offered,
+// a delivery goes to a zone and the order reaches the minimums. It returns a *FulfilmentError
+// or nil, a nil config has nothing to check.
+func (d *DeliveryConfig) CheckFulfilment(c CustomerOrder, pricing OrderPricing) error {
+	if d == nil {
+		return nil
+	}
+	itemsValue := pricing.Total.Sub(pricing.DeliveryFee).Sub(pricing.addedTax())
+	if !d.MinimumOrder.IsZero() && itemsValue.Cmp(d.MinimumOrder) < 0 {
+		return &FulfilmentError{Reason: fmt.Sprintf("The minimum order is %s, your order comes to %s.", d.MinimumOrder, itemsValue)}
+	}
+
+	switch d.FulfilmentOf(c) {
+	case FulfilmentPickup:
+		if !d.Pickup {
+			return &FulfilmentError{Reason: "Sorry, we don't do pickups.\nTo have it delivered type & send-: " + deliverToCommand}
+		}
+		return nil
+	case FulfilmentDelivery:
+		if len(d.Zones) == 0 {
+			return &FulfilmentError{Reason: "Sorry, we don't deliver.\nTo collect it type & send-: " + pickupCommand}
+		}
+		if c.DeliveryLocation.IsZero() {
+			return &FulfilmentError{Reason: "Where should we deliver to?\nPlease type & send-: " + deliverToCommand}
+		}
+		zone, ok := d.ZoneFor(c.DeliveryLocation)
+		if !ok {
+			reason := fmt.Sprintf("Sorry, we don't deliver to %s.", c.DeliveryLocation)
+			if d.Pickup {
+				reason += "\nTo collect it instead type & send-: " + pickupCommand
+			}
+			return &FulfilmentError{Reason: reason}
+		}
+		if !zone.MinimumOrder.IsZero() && itemsValue.Cmp(zone.MinimumOrder) < 0 {
+			return &FulfilmentError{Reason: fmt.Sprintf("The minimum order for delivery to %s is %s, your order comes to %s.", zone.Name, zone.MinimumOrder, itemsValue)}
+		}
+		return nil
+	}
+	return &FulfilmentError{Reason: "Would you like your order delivered or will you collect it?\nPlease type & send-: " + deliverToCommand + "\nor-: " + pickupCommand}
+}
+
+// SetFulfilment chooses pickup or delivery to the location for the order, the total is recomputed
+// so an order awaiting payment goes back to draft and has to be checked out again.
+func (c *CustomerOrder) SetFulfilment(orders OrderRepository, fulfilment Fulfilment, location DeliveryLocation, prlst Pricelist) error {
+	if c.Status == OrderAwaitingPayment {
+		err := c.TransitionTo(orders, OrderDraft)
+		if err != nil {
+			return err
+		}
+	}
+	c.Fulfilment = fulfilment
+	if fulfilment == FulfilmentDelivery {
+		c.DeliveryLocation = location
+	}
+	c.refreshOrderTotal(prlst)
+	return c.updateCurrentOrder(orders)
+}
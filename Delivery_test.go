@@ -0,0 +1,206 @@
+package menubotlib
+
+import (
+	"strings"
+	"testing"
+)
+
+func zar(minor int64) Money {
+	return NewMoney(minor, DefaultCurrency)
+}
+
+// The test price list delivering to the City by suburb, at less from R300 and free from R500, and to
+// the Southern suburbs within 10km of a point from R400. Every order has to come to R100.
+func deliveryPricelist() Pricelist {
+	prlst := testPricelist()
+	prlst.Delivery = &DeliveryConfig{
+		MinimumOrder: zar(10000),
+		Pickup:       true,
+		Zones: []DeliveryZone{
+			{
+				Name:        "City",
+				Suburbs:     []string{"Gardens", "Tamboerskloof"},
+				PostalCodes: []string{"8001"},
+				Fee:         zar(3000),
+				FeeTiers:    []FeeTier{{From: zar(30000), Fee: zar(2000)}},
+				FreeFrom:    zar(50000),
+			},
+			{
+				Name:         "Southern",
+				Centre:       &GeoPoint{Latitude: -34.0, Longitude: 18.46},
+				RadiusKm:     10,
+				Fee:          zar(6000),
+				MinimumOrder: zar(40000),
+			},
+		},
+	}
+	return prlst
+}
+
+func TestDeliveryZoneContains(t *testing.T) {
+	tests := []struct {
+		name     string
+		location DeliveryLocation
+		wantZone string
+	}{
+		{"suburb in another case", DeliveryLocation{Area: " gardens "}, "City"},
+		{"postal code typed as the area", DeliveryLocation{Area: "8001"}, "City"},
+		{"postal code of an address", DeliveryLocation{Address: "1 Long St", Area: "Somewhere", PostalCode: "8001"}, "City"},
+		{"point within the radius", DeliveryLocation{Point: &GeoPoint{Latitude: -34.05, Longitude: 18.46}}, "Southern"},
+		{"point outside the radius", DeliveryLocation{Point: &GeoPoint{Latitude: -34.2, Longitude: 18.46}}, ""},
+		{"part of a suburb's name", DeliveryLocation{Area: "Garden"}, ""},
+		{"nothing given", DeliveryLocation{}, ""},
+	}
+	delivery := deliveryPricelist().Delivery
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zone, ok := delivery.ZoneFor(tt.location)
+			if zone.Name != tt.wantZone || ok != (tt.wantZone != "") {
+				t.Errorf("zone = %q, %t, want %q", zone.Name, ok, tt.wantZone)
+			}
+			for _, z := range delivery.Zones {
+				if z.Contains(tt.location) != (z.Name == tt.wantZone) {
+					t.Errorf("%s contains %v = %t", z.Name, tt.location, !(z.Name == tt.wantZone))
+				}
+			}
+		})
+	}
+}
+
+func TestDeliveryZoneFeeFor(t *testing.T) {
+	delivery := deliveryPricelist().Delivery
+	city, southern := delivery.Zones[0], delivery.Zones[1]
+	tiered := DeliveryZone{Name: "Tiered", Fee: zar(4000), FeeTiers: []FeeTier{{From: zar(50000), Fee: zar(1000)}, {From: zar(20000), Fee: zar(2500)}}}
+
+	tests := []struct {
+		name       string
+		zone       DeliveryZone
+		orderValue int64
+		wantFee    int64
+	}{
+		{"below the tiers", city, 10000, 3000},
+		{"at the tier", city, 30000, 2000},
+		{"just below free", city, 49999, 2000},
+		{"at free from", city, 50000, 0},
+		{"no free delivery", southern, 1000000, 6000},
+		{"tiers out of order, lower tier", tiered, 30000, 2500},
+		{"tiers out of order, higher tier", tiered, 60000, 1000},
+		{"tiers out of order, below both", tiered, 10000, 4000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if fee := tt.zone.FeeFor(zar(tt.orderValue)); fee.Minor != tt.wantFee {
+				t.Errorf("fee for %s = %s, want %s", zar(tt.orderValue), fee, zar(tt.wantFee))
+			}
+		})
+	}
+
+	if listed := delivery.String(); !strings.Contains(listed, "*City* R30.00, R20.00 from R300.00, free from R500.00") {
+		t.Errorf("delivery info = %q, want the City's fees listed", listed)
+	}
+}
+
+func TestPriceDelivery(t *testing.T) {
+	gardens := DeliveryLocation{Area: "Gardens"}
+	tests := []struct {
+		name       string
+		cart       OrderItems
+		fulfilment Fulfilment
+		location   DeliveryLocation
+		promotion  *Promotion
+		wantZone   string
+		wantFee    int64
+		wantTotal  int64
+	}{
+		{"delivery at the tier", items("1", "5"), FulfilmentDelivery, gardens, nil, "City", 2000, 47000},
+		{"delivery below the tiers", items("1", "2"), FulfilmentDelivery, gardens, nil, "City", 3000, 23000},
+		{"free delivery", items("1", "5", "3", "1x1"), FulfilmentDelivery, gardens, nil, "City", 0, 50000},
+		{"discount takes it below free", items("1", "5", "3", "1x1"), FulfilmentDelivery, gardens,
+			&Promotion{Code: "TENOFF", Kind: PromotionPercentOff, Automatic: true, Percent: 10}, "City", 2000, 47000},
+		{"pickup", items("1", "5"), FulfilmentPickup, gardens, nil, "", 0, 45000},
+		{"not in a zone", items("1", "5"), FulfilmentDelivery, DeliveryLocation{Area: "Durban"}, nil, "", 0, 45000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prlst := deliveryPricelist()
+			if tt.promotion != nil {
+				prlst.Promotions = []Promotion{*tt.promotion}
+			}
+			c := CustomerOrder{OrderItems: tt.cart, Fulfilment: tt.fulfilment, DeliveryLocation: tt.location}
+			pricing := prlst.Delivery.priceDelivery(c, PriceOrder(c.OrderItems, c.PromoCode, prlst))
+			if pricing.Fulfilment != tt.fulfilment || pricing.DeliveryZone != tt.wantZone {
+				t.Errorf("fulfilment = %s to %q, want %s to %q", pricing.Fulfilment, pricing.DeliveryZone, tt.fulfilment, tt.wantZone)
+			}
+			if pricing.DeliveryFee.Minor != tt.wantFee || pricing.Total.Minor != tt.wantTotal {
+				t.Errorf("fee %s total %s, want %s and %s", pricing.DeliveryFee, pricing.Total, zar(tt.wantFee), zar(tt.wantTotal))
+			}
+		})
+	}
+
+	// Without a config there is no fee and nothing to choose
+	var none *DeliveryConfig
+	pricing := none.priceDelivery(CustomerOrder{OrderItems: items("1", "5")}, PriceOrder(items("1", "5"), "", testPricelist()))
+	if pricing.Fulfilment != "" || !pricing.DeliveryFee.IsZero() || pricing.Total.Minor != 45000 {
+		t.Errorf("pricing without delivery = %+v", pricing)
+	}
+}
+
+func TestCheckFulfilment(t *testing.T) {
+	southern := DeliveryLocation{Point: &GeoPoint{Latitude: -34.05, Longitude: 18.46}}
+	addedVAT := &TaxConfig{Rates: map[string]TaxRate{"standard": {Name: "VAT", BasisPoints: 1500}}, DefaultClass: "standard"}
+	tests := []struct {
+		name       string
+		cart       OrderItems
+		fulfilment Fulfilment
+		location   DeliveryLocation
+		noPickup   bool
+		// minimum replaces the config's minimum order when it isn't zero.
+		minimum    int64
+		tax        *TaxConfig
+		wantReason string
+	}{
+		{"below the minimum order", items("3", "1x1"), FulfilmentPickup, DeliveryLocation{}, false, 0, nil, "The minimum order is R100.00, your order comes to R50.00."},
+		{"at the minimum order", items("1", "1"), FulfilmentPickup, DeliveryLocation{}, false, 0, nil, ""},
+		// R100 of flower comes to R115 with VAT, the items are still short of R110
+		{"minimum order leaves out added tax", items("1", "1"), FulfilmentPickup, DeliveryLocation{}, false, 11000, addedVAT, "The minimum order is R110.00, your order comes to R100.00."},
+		{"nothing chosen", items("1", "5"), "", DeliveryLocation{}, false, 0, nil, "delivered or will you collect it?"},
+		{"pickup not offered", items("1", "5"), FulfilmentPickup, DeliveryLocation{}, true, 0, nil, "Sorry, we don't do pickups."},
+		{"delivery only needs no choice", items("1", "5"), "", DeliveryLocation{Area: "Gardens"}, true, 0, nil, ""},
+		{"no location", items("1", "5"), FulfilmentDelivery, DeliveryLocation{}, false, 0, nil, "Where should we deliver to?"},
+		{"not in a zone", items("1", "5"), FulfilmentDelivery, DeliveryLocation{Area: "Durban"}, false, 0, nil, "Sorry, we don't deliver to Durban.\nTo collect it instead"},
+		{"delivery to a zone", items("1", "2"), FulfilmentDelivery, DeliveryLocation{Area: "Gardens"}, false, 0, nil, ""},
+		{"below the zone's minimum", items("1", "3"), FulfilmentDelivery, southern, false, 0, nil,
+			"The minimum order for delivery to Southern is R400.00, your order comes to R300.00."},
+		{"at the zone's minimum", items("1", "4"), FulfilmentDelivery, southern, false, 0, nil, ""},
+		// R300 of flower comes to R414 with the fee and VAT, the items are still short of R400
+		{"zone's minimum leaves out the fee and added tax", items("1", "3"), FulfilmentDelivery, southern, false, 0, addedVAT, "your order comes to R300.00."},
+		{"zone's minimum with added tax", items("1", "4"), FulfilmentDelivery, southern, false, 0, addedVAT, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prlst := deliveryPricelist()
+			prlst.Delivery.Pickup = !tt.noPickup
+			prlst.Tax = tt.tax
+			if tt.minimum != 0 {
+				prlst.Delivery.MinimumOrder = zar(tt.minimum)
+			}
+			c := CustomerOrder{OrderItems: tt.cart, Fulfilment: tt.fulfilment, DeliveryLocation: tt.location}
+			err := prlst.Delivery.CheckFulfilment(c, c.priceOrder(prlst))
+			if tt.wantReason == "" {
+				if err != nil {
+					t.Errorf("CheckFulfilment = %v, want nil", err)
+				}
+				return
+			}
+			fulfilment, ok := err.(*FulfilmentError)
+			if !ok || !strings.Contains(fulfilment.Reason, tt.wantReason) {
+				t.Errorf("CheckFulfilment = %v, want a FulfilmentError saying %q", err, tt.wantReason)
+			}
+		})
+	}
+
+	var none *DeliveryConfig
+	if err := none.CheckFulfilment(CustomerOrder{}, OrderPricing{}); err != nil {
+		t.Errorf("CheckFulfilment without a config = %v, want nil", err)
+	}
+}
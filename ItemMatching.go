@@ -0,0 +1,212 @@
+package menubotlib
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// UnknownItemError is an item name that matches nothing on the price list.
+type UnknownItemError struct {
+	Query string
+}
+
+func (e *UnknownItemError) Error() string {
+	return fmt.Sprintf("no item on the price list matches %q, please use the item's name or price list number", e.Query)
+}
+
+// AmbiguousItemError is an item name that matches several items equally well.
+type AmbiguousItemError struct {
+	Query      string
+	Candidates []CatalogueItem
+}
+
+func (e *AmbiguousItemError) Error() string {
+	return fmt.Sprintf("%q matches %d items on the price list", e.Query, len(e.Candidates))
+}
+
+// Prompt asks the customer which of the candidates they meant.
+func (e *AmbiguousItemError) Prompt() string {
+	prompt := fmt.Sprintf("Which item did you mean by %q?", e.Query)
+	for _, item := range e.Candidates {
+		prompt += fmt.Sprintf("\n%d: %s", item.CatalogueItemID, item.Item)
+	}
+	return prompt + "\nPlease use the item's number."
+}
+
+// OptionNeededError is a count for an item with several options that doesn't say which option.
+type OptionNeededError struct {
+	Item     CatalogueItem
+	Quantity int
+}
+
+func (e *OptionNeededError) Error() string {
+	return fmt.Sprintf("%s has %d options, please say which one", e.Item.Item, len(e.Item.Options))
+}
+
+// Prompt asks the customer which option they meant, with the update to send for each.
+func (e *OptionNeededError) Prompt() string {
+	prompt := fmt.Sprintf("Which %s did you mean?", e.Item.Item)
+	for i, option := range e.Item.Options {
+		prompt += fmt.Sprintf("\n%s - update order %d:%dx%d", option, e.Item.CatalogueItemID, i+1, e.Quantity)
+	}
+	return prompt
+}
+
+// How closely a name matches, lower is better.
+const (
+	matchExact = iota
+	matchPartial
+	matchTypo
+	noMatch
+)
+
+// MatchCatalogueItem finds the item the customer means by name. Case, spaces and punctuation
+// are ignored, part of a name or a small typo is enough as long as only one item matches that well.
+func MatchCatalogueItem(query string, ctlgselections []CatalogueSelection) (CatalogueItem, error) {
+	return matchCatalogueItem(query, ctlgselections, matchItemName)
+}
+
+func matchCatalogueItem(query string, ctlgselections []CatalogueSelection, match func(query, name string) int) (CatalogueItem, error) {
+	best := noMatch
+	var candidates []CatalogueItem
+	for _, selection := range ctlgselections {
+		for _, item := range selection.Items {
+			quality := match(query, item.Item)
+			if quality < best {
+				best = quality
+				candidates = nil
+			}
+			if quality == best && quality != noMatch {
+				candidates = append(candidates, item)
+			}
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		return CatalogueItem{}, &UnknownItemError{Query: query}
+	case 1:
+		return candidates[0], nil
+	default:
+		return CatalogueItem{}, &AmbiguousItemError{Query: query, Candidates: candidates}
+	}
+}
+
+func matchItemName(query, name string) int {
+	queryWords := nameWords(query)
+	nameWordList := nameWords(name)
+	q := strings.Join(queryWords, "")
+	n := strings.Join(nameWordList, "")
+	if q == "" || n == "" {
+		return noMatch
+	}
+
+	if q == n {
+		return matchExact
+	}
+	// A single letter is too little to go on
+	if len([]rune(q)) < 2 {
+		return noMatch
+	}
+	if strings.Contains(n, q) || everyWordPrefixes(queryWords, nameWordList) {
+		return matchPartial
+	}
+
+	// Allow roughly one typo per four letters, against the whole name or any single word of it
+	allowed := len([]rune(q)) / 4
+	if allowed == 0 {
+		return noMatch
+	}
+	if levenshtein(q, n) <= allowed {
+		return matchTypo
+	}
+	for _, word := range nameWordList {
+		if levenshtein(q, word) <= allowed {
+			return matchTypo
+		}
+	}
+	return noMatch
+}
+
+// The shortest word that is matched as the start of a word in free text, so "no" and "ok"
+// in a chat message don't pick out "Nougat" or "Cookie".
+const minFreeTextPrefix = 3
+
+// matchFreeTextName is matchItemName for words picked out of a chat message, where most words
+// aren't meant as item names. Only the whole name or the starts of its words, each at least
+// minFreeTextPrefix letters long, match. Typos and letters in the middle of a word don't.
+func matchFreeTextName(query, name string) int {
+	queryWords := nameWords(query)
+	nameWordList := nameWords(name)
+	if len(queryWords) == 0 || len(nameWordList) == 0 {
+		return noMatch
+	}
+	if strings.Join(queryWords, "") == strings.Join(nameWordList, "") {
+		return matchExact
+	}
+	for _, word := range queryWords {
+		if len([]rune(word)) < minFreeTextPrefix {
+			return noMatch
+		}
+	}
+	if everyWordPrefixes(queryWords, nameWordList) {
+		return matchPartial
+	}
+	return noMatch
+}
+
+// Lower-cased words with punctuation dropped, "T-Shirt (Large)" gives "tshirt" and "large".
+func nameWords(s string) []string {
+	var words []string
+	for _, field := range strings.Fields(strings.ToLower(s)) {
+		word := strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				return r
+			}
+			return -1
+		}, field)
+		if word != "" {
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+// Whether every query word starts some word of the name, "pur ku" for "Purple Kush".
+func everyWordPrefixes(queryWords, nameWordList []string) bool {
+	for _, qw := range queryWords {
+		found := false
+		for _, nw := range nameWordList {
+			if strings.HasPrefix(nw, qw) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func levenshtein(a, b string) int {
+	ar, br := []rune(a), []rune(b)
+	prev := make([]int, len(br)+1)
+	curr := make([]int, len(br)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		curr[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(br)]
+}
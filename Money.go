@@ -0,0 +1,253 @@
+package menubotlib
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const DefaultCurrency = "ZAR"
+
+var ErrCurrencyMismatch = errors.New("amounts are in different currencies")
+
+// Symbols used when printing amounts, other currencies print their ISO code instead.
+var currencySymbols = map[string]string{
+	"ZAR": "R",
+	"USD": "$",
+	"EUR": "€",
+	"GBP": "£",
+}
+
+// Money is an exact amount in the currency's minor unit, cents for ZAR.
+// Adding, subtracting or comparing amounts in different currencies panics, a zero amount
+// takes on the currency of the amount it is combined with. Amounts from outside are compared
+// with CheckedCmp, a conversation's Pricelist leaves out anything in another currency.
+// Anything that divides (percentages, ratios) rounds half away from zero to the nearest minor unit.
+type Money struct {
+	Minor    int64  `json:"Minor"`
+	Currency string `json:"Currency"`
+}
+
+func NewMoney(minor int64, currency string) Money {
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+	return Money{Minor: minor, Currency: strings.ToUpper(currency)}
+}
+
+// ParseMoney reads amounts like "12", "12.5", "R12.50", "1 200.00", "1,200.00" or "12,50".
+// A comma followed by exactly two digits at the end is the decimal separator, other commas have to
+// separate thousands. More than two decimal places is an error rather than a silent rounding.
+func ParseMoney(s string, currency string) (Money, error) {
+	m := NewMoney(0, currency)
+	clean := strings.TrimSpace(s)
+	if symbol, ok := currencySymbols[m.Currency]; ok {
+		clean = strings.TrimPrefix(clean, symbol)
+	}
+	clean = strings.TrimPrefix(clean, m.Currency)
+	clean = strings.ReplaceAll(clean, " ", "")
+	if i := strings.LastIndex(clean, ","); i >= 0 && !strings.Contains(clean, ".") && len(clean)-i == 3 {
+		clean = clean[:i] + "." + clean[i+1:]
+	}
+	if !validThousands(clean) {
+		return Money{}, fmt.Errorf("error parsing amount: %q has an ambiguous comma", s)
+	}
+	clean = strings.ReplaceAll(clean, ",", "")
+
+	negative := strings.HasPrefix(clean, "-")
+	clean = strings.TrimPrefix(clean, "-")
+
+	whole, frac, hasFrac := strings.Cut(clean, ".")
+	if whole == "" && (!hasFrac || frac == "") {
+		return Money{}, fmt.Errorf("error parsing amount: %q", s)
+	}
+	if len(frac) > 2 {
+		return Money{}, fmt.Errorf("error parsing amount: %q has more than two decimal places", s)
+	}
+	frac += strings.Repeat("0", 2-len(frac))
+	if whole == "" {
+		whole = "0"
+	}
+
+	major, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("error parsing amount: %q, %v", s, err)
+	}
+	minor, err := strconv.ParseInt(frac, 10, 64)
+	if err != nil || strings.HasPrefix(frac, "-") {
+		return Money{}, fmt.Errorf("error parsing amount: %q", s)
+	}
+
+	m.Minor = major*100 + minor
+	if negative {
+		m.Minor = -m.Minor
+	}
+	return m, nil
+}
+
+// Whether every comma in the amount is followed by a group of three digits.
+func validThousands(amount string) bool {
+	whole, _, _ := strings.Cut(amount, ".")
+	groups := strings.Split(strings.TrimPrefix(whole, "-"), ",")
+	if len(groups) > 1 && groups[0] == "" {
+		return false
+	}
+	for _, group := range groups[1:] {
+		if len(group) != 3 {
+			return false
+		}
+	}
+	return true
+}
+
+// The currency m and o are combined in, an ErrCurrencyMismatch when they can't be.
+func (m Money) currencyWith(o Money) (string, error) {
+	switch {
+	case m.Currency == o.Currency || o.Currency == "" || (o.Minor == 0 && m.Currency != ""):
+		return m.Currency, nil
+	case m.Currency == "" || m.Minor == 0:
+		return o.Currency, nil
+	}
+	return "", fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m, o)
+}
+
+// The currency m and o are combined in, panics when they can't be.
+func (m Money) combinedCurrency(o Money) string {
+	currency, err := m.currencyWith(o)
+	if err != nil {
+		panic("money: " + err.Error())
+	}
+	return currency
+}
+
+// SameCurrency is nil when m and o can be added or compared, ErrCurrencyMismatch otherwise.
+func (m Money) SameCurrency(o Money) error {
+	_, err := m.currencyWith(o)
+	return err
+}
+
+func (m Money) Add(o Money) Money {
+	m.Currency = m.combinedCurrency(o)
+	m.Minor += o.Minor
+	return m
+}
+
+func (m Money) Sub(o Money) Money {
+	return m.Add(o.Mul(-1))
+}
+
+func (m Money) Mul(n int64) Money {
+	m.Minor *= n
+	return m
+}
+
+// MulFrac multiplies by num/den, e.g. MulFrac(15, 100) for 15%.
+func (m Money) MulFrac(num, den int64) Money {
+	m.Minor = roundDiv(m.Minor*num, den)
+	return m
+}
+
+// Integer division rounding half away from zero.
+func roundDiv(n, d int64) int64 {
+	if d < 0 {
+		n, d = -n, -d
+	}
+	if n < 0 {
+		return -((-n + d/2) / d)
+	}
+	return (n + d/2) / d
+}
+
+func (m Money) IsZero() bool {
+	return m.Minor == 0
+}
+
+func (m Money) IsNegative() bool {
+	return m.Minor < 0
+}
+
+// Cmp returns -1, 0 or 1 as m is less than, equal to or greater than o.
+func (m Money) Cmp(o Money) int {
+	m.combinedCurrency(o)
+	return m.cmpMinor(o)
+}
+
+// CheckedCmp is Cmp for amounts that come from outside, e.g. a payment, it returns an
+// ErrCurrencyMismatch rather than panic.
+func (m Money) CheckedCmp(o Money) (int, error) {
+	err := m.SameCurrency(o)
+	if err != nil {
+		return 0, err
+	}
+	return m.cmpMinor(o), nil
+}
+
+func (m Money) cmpMinor(o Money) int {
+	switch {
+	case m.Minor < o.Minor:
+		return -1
+	case m.Minor > o.Minor:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Decimal formats the amount in major units with two decimals, the way gateways expect it.
+func (m Money) Decimal() string {
+	sign := ""
+	minor := m.Minor
+	if minor < 0 {
+		sign = "-"
+		minor = -minor
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, minor/100, minor%100)
+}
+
+func (m Money) String() string {
+	currency := m.Currency
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+	if symbol, ok := currencySymbols[currency]; ok {
+		if m.Minor < 0 {
+			return "-" + symbol + Money{Minor: -m.Minor}.Decimal()
+		}
+		return symbol + m.Decimal()
+	}
+	return m.Decimal() + " " + currency
+}
+
+// Scan reads a numeric column, the currency is not part of the column and defaults to ZAR.
+func (m *Money) Scan(src interface{}) error {
+	var text string
+	switch v := src.(type) {
+	case nil:
+		*m = NewMoney(0, m.Currency)
+		return nil
+	case []byte:
+		text = string(v)
+	case string:
+		text = v
+	case int64:
+		*m = NewMoney(v*100, m.Currency)
+		return nil
+	case float64:
+		text = strconv.FormatFloat(v, 'f', 2, 64)
+	default:
+		return fmt.Errorf("cannot scan %T into Money", src)
+	}
+
+	parsed, err := ParseMoney(text, m.Currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+func (m Money) Value() (driver.Value, error) {
+	return m.Decimal(), nil
+}
@@ -0,0 +1,119 @@
+package menubotlib
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"12", 1200, false},
+		{"12.5", 1250, false},
+		{"R12.50", 1250, false},
+		{"1 200.00", 120000, false},
+		{"1,200.00", 120000, false},
+		{"1,200", 120000, false},
+		{"12,50", 1250, false},
+		{"1,200,50", 120050, false},
+		{"-12,50", -1250, false},
+		{"12,5", 0, true},
+		{"1,20.00", 0, true},
+		{",50.00", 0, true},
+		{"12.505", 0, true},
+		{"abc", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.in, DefaultCurrency)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseMoney(%q) = %s, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got.Minor != tt.want {
+			t.Errorf("ParseMoney(%q) = %d, %v, want %d", tt.in, got.Minor, err, tt.want)
+		}
+	}
+}
+
+func TestMoneyCurrencyMismatch(t *testing.T) {
+	zar := NewMoney(1000, "ZAR")
+	usd := NewMoney(500, "USD")
+
+	if got := NewMoney(0, "ZAR").Add(usd); got.Currency != "USD" || got.Minor != 500 {
+		t.Errorf("zero ZAR + $5.00 = %s, want $5.00", got)
+	}
+	if got := zar.Sub(NewMoney(0, "USD")); got.Currency != "ZAR" || got.Minor != 1000 {
+		t.Errorf("R10.00 - zero USD = %s, want R10.00", got)
+	}
+
+	for name, combine := range map[string]func(){
+		"Add": func() { zar.Add(usd) },
+		"Sub": func() { zar.Sub(usd) },
+		"Cmp": func() { zar.Cmp(usd) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s of R10.00 and $5.00 did not panic", name)
+				}
+			}()
+			combine()
+		}()
+	}
+
+	if _, err := zar.CheckedCmp(usd); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("CheckedCmp of R10.00 and $5.00 = %v, want %v", err, ErrCurrencyMismatch)
+	}
+	if cmp, err := zar.CheckedCmp(NewMoney(1000, "zar")); err != nil || cmp != 0 {
+		t.Errorf("CheckedCmp of R10.00 and R10.00 = %d, %v, want 0", cmp, err)
+	}
+}
+
+func TestPricelistOneCurrency(t *testing.T) {
+	prlst := testPricelist()
+	prlst.Catalogue = append(prlst.Catalogue, CmpsCtlgSlctnsFromCtlgItms([]CatalogueItem{
+		{CatalogueID: "c1", CatalogueItemID: 4, Selection: "Imports", Item: "Gummies", PricingType: SingleItem, Options: []CatalogueOption{
+			{Label: "bag", Threshold: 1, Price: NewMoney(900, "USD")},
+		}},
+	})...)
+	prlst.Promotions = []Promotion{
+		{Code: "FIVE", Kind: PromotionFixedOff, Automatic: true, Amount: NewMoney(500, "USD")},
+		{Code: "TEN", Kind: PromotionFixedOff, Automatic: true, Amount: NewMoney(1000, "ZAR")},
+	}
+	prlst.Delivery = &DeliveryConfig{
+		MinimumOrder: NewMoney(2000, "USD"),
+		Zones: []DeliveryZone{
+			{Name: "Abroad", Suburbs: []string{"Soho"}, Fee: NewMoney(1500, "GBP")},
+			{Name: "Tiered", Suburbs: []string{"Claremont"}, Fee: NewMoney(3000, "ZAR"), FeeTiers: []FeeTier{{From: NewMoney(5000, "USD"), Fee: NewMoney(1000, "ZAR")}}},
+			{Name: "City", Suburbs: []string{"Gardens"}, Fee: NewMoney(3000, "ZAR")},
+		},
+	}
+
+	checked := prlst.oneCurrency()
+	if _, err := findItemInSelections(4, checked.Catalogue); err == nil {
+		t.Error("the item priced in USD was kept")
+	}
+	if _, err := findItemInSelections(1, checked.Catalogue); err != nil {
+		t.Error("an item priced in ZAR was left out")
+	}
+	if len(checked.Promotions) != 1 || checked.Promotions[0].Code != "TEN" {
+		t.Errorf("promotions = %+v, want TEN only", checked.Promotions)
+	}
+	if !checked.Delivery.MinimumOrder.IsZero() || len(checked.Delivery.Zones) != 1 || checked.Delivery.Zones[0].Name != "City" {
+		t.Errorf("delivery = %+v, want the City zone and no minimum", checked.Delivery)
+	}
+	if len(prlst.Delivery.Zones) != 3 {
+		t.Error("the shared delivery config was changed")
+	}
+
+	// Pricing the checked list never meets two currencies
+	pricing := PriceOrder(items("1", "5", "4", "1x1"), "", checked)
+	if pricing.Total.Minor != 44000 || pricing.Total.Currency != "ZAR" {
+		t.Errorf("total = %s, want R440.00", pricing.Total)
+	}
+}
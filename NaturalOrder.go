@@ -0,0 +1,174 @@
+package menubotlib
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// NaturalOrderLine is one item picked out of a free text message.
+type NaturalOrderLine struct {
+	Item     CatalogueItem
+	Quantity int
+}
+
+func (l NaturalOrderLine) String() string {
+	if l.Item.PricingType == WeightItem {
+		return fmt.Sprintf("%d%s %s", l.Quantity, l.Item.Options[0].Unit, l.Item.Item)
+	}
+	return fmt.Sprintf("%d x %s (%s)", l.Quantity, l.Item.Item, l.Item.Options[0].Label)
+}
+
+var numberWords = map[string]int{
+	"a": 1, "an": 1, "one": 1, "two": 2, "three": 3, "four": 4, "five": 5, "six": 6, "seven": 7,
+	"eight": 8, "nine": 9, "ten": 10, "eleven": 11, "twelve": 12, "thirteen": 13, "fourteen": 14,
+	"fifteen": 15, "sixteen": 16, "seventeen": 17, "eighteen": 18, "nineteen": 19, "dozen": 12,
+	"couple": 2, "pair": 2, "another": 1,
+}
+
+var tensWords = map[string]int{
+	"twenty": 20, "thirty": 30, "forty": 40, "fifty": 50, "sixty": 60, "seventy": 70, "eighty": 80, "ninety": 90,
+}
+
+// Words that never name an item, so "can I get" doesn't match an item called "Canned Peaches".
+var orderFillerWords = map[string]bool{
+	"can": true, "could": true, "i": true, "id": true, "we": true, "get": true, "have": true, "like": true,
+	"want": true, "would": true, "please": true, "and": true, "of": true, "some": true, "the": true,
+	"me": true, "also": true, "plus": true, "with": true, "x": true, "g": true, "gram": true, "grams": true,
+	"hi": true, "hello": true, "hey": true, "thanks": true, "order": true, "to": true, "for": true,
+}
+
+// "5g" and "2x" carry their number with them.
+var regexQuantityWord = regexp.MustCompile(`^(\d+)(?:x|g|grams?)?$`)
+
+// The longest item name, in words, tried against the message.
+const maxItemNameWords = 4
+
+// ParseNaturalOrder picks quantities and items out of a message like "can I get two hats and a mug please".
+// A quantity applies to the item named after it, items without one get 1. Names have to be whole
+// item names or the starts of their words, names that match several items are skipped.
+func ParseNaturalOrder(message string, ctlgselections []CatalogueSelection) []NaturalOrderLine {
+	words := nameWords(strings.NewReplacer(",", " ", ".", " ", "!", " ", "?", " ", "&", " and ").Replace(message))
+
+	var lines []NaturalOrderLine
+	quantity := 0
+	for i := 0; i < len(words); {
+		if n, used := parseQuantity(words[i:]); used > 0 {
+			quantity = n
+			i += used
+			continue
+		}
+		if orderFillerWords[words[i]] {
+			i++
+			continue
+		}
+
+		item, used := matchItemAt(words[i:], ctlgselections)
+		if used == 0 {
+			i++
+			continue
+		}
+		if quantity == 0 {
+			quantity = 1
+		}
+		lines = addNaturalOrderLine(lines, NaturalOrderLine{Item: item, Quantity: quantity})
+		quantity = 0
+		i += used
+	}
+	return lines
+}
+
+// Reads a quantity at the start of words, "twenty five" and "2" alike, and how many words it took.
+func parseQuantity(words []string) (int, int) {
+	if match := regexQuantityWord.FindStringSubmatch(words[0]); match != nil {
+		n, err := strconv.Atoi(match[1])
+		if err == nil {
+			return n, 1
+		}
+	}
+	if tens, ok := tensWords[words[0]]; ok {
+		if len(words) > 1 {
+			if unit, ok := numberWords[words[1]]; ok && unit < 10 && words[1] != "a" && words[1] != "an" {
+				return tens + unit, 2
+			}
+		}
+		return tens, 1
+	}
+	if n, ok := numberWords[words[0]]; ok {
+		return n, 1
+	}
+	return 0, 0
+}
+
+// Tries the longest run of words first, so "purple kush" isn't read as "purple" and "kush".
+func matchItemAt(words []string, ctlgselections []CatalogueSelection) (CatalogueItem, int) {
+	for n := min(maxItemNameWords, len(words)); n > 0; n-- {
+		phrase := words[:n]
+		if orderFillerWords[phrase[n-1]] {
+			continue
+		}
+		// "hats" for an item called "Hat"
+		for _, name := range append([][]string{phrase}, singulars(phrase)...) {
+			item, err := matchCatalogueItem(strings.Join(name, " "), ctlgselections, matchFreeTextName)
+			if err == nil && len(item.Options) > 0 {
+				return item, n
+			}
+		}
+	}
+	return CatalogueItem{}, 0
+}
+
+// The ways the last word may be a plural, "berries" could be "berry" and "brownies" "brownie".
+func singulars(words []string) [][]string {
+	last := words[len(words)-1]
+	var stems []string
+	if strings.HasSuffix(last, "ies") && len(last) > 4 {
+		stems = append(stems, strings.TrimSuffix(last, "ies")+"y")
+	}
+	if strings.HasSuffix(last, "es") && len(last) > 3 {
+		stems = append(stems, strings.TrimSuffix(last, "es"))
+	}
+	if strings.HasSuffix(last, "s") && len(last) > 2 {
+		stems = append(stems, strings.TrimSuffix(last, "s"))
+	}
+
+	var out [][]string
+	for _, stem := range stems {
+		name := append([]string(nil), words...)
+		name[len(name)-1] = stem
+		out = append(out, name)
+	}
+	return out
+}
+
+// The same item named twice adds up.
+func addNaturalOrderLine(lines []NaturalOrderLine, line NaturalOrderLine) []NaturalOrderLine {
+	for i := range lines {
+		if lines[i].Item.CatalogueItemID == line.Item.CatalogueItemID {
+			lines[i].Quantity += line.Quantity
+			return lines
+		}
+	}
+	return append(lines, line)
+}
+
+// NaturalOrderUpdate turns the lines into an update for the order. Quantities are added to what the
+// order already has, "another hat" is one more hat. Single items go on their first option and keep
+// whatever other options the order already has of them.
+func NaturalOrderUpdate(lines []NaturalOrderLine, current CustomerOrder) OrderItems {
+	var update OrderItems
+	for _, line := range lines {
+		held := currentItemAmount(current, line.Item.CatalogueItemID)
+		var amount string
+		if line.Item.PricingType == SingleItem {
+			amount = setOptionAmount(held, 1, optionQuantity(held, 1)+line.Quantity)
+		} else {
+			// An amount that isn't a whole weight is replaced rather than added to
+			weight, _ := strconv.Atoi(strings.TrimSpace(held))
+			amount = strconv.Itoa(weight + line.Quantity)
+		}
+		update.MenuIndications = append(update.MenuIndications, MenuIndication{ItemMenuNum: line.Item.CatalogueItemID, ItemAmount: amount})
+	}
+	return update
+}
@@ -0,0 +1,62 @@
+package menubotlib
+
+import "testing"
+
+func TestNaturalOrderUpdate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cart    OrderItems
+		message string
+		want    OrderItems
+	}{
+		{"empty cart", OrderItems{}, "two brownies please", items("3", "1x2")},
+		{"adds to the option held", items("3", "1x2"), "a brownie", items("3", "1x3")},
+		{"another", items("3", "1x1"), "another brownie", items("3", "1x2")},
+		{"keeps other options", items("3", "2x1"), "a brownie", items("3", "2x1, 1x1")},
+		{"adds to the weight held", items("1", "5"), "2g lemon haze", items("1", "7")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := ParseNaturalOrder(tt.message, testPricelist().Catalogue)
+			got := NaturalOrderUpdate(lines, CustomerOrder{OrderItems: tt.cart})
+			if !sameItems(got, tt.want) {
+				t.Errorf("update = %v, want %v", got.MenuIndications, tt.want.MenuIndications)
+			}
+		})
+	}
+}
+
+func TestParseNaturalOrder(t *testing.T) {
+	ctlg := append(testPricelist().Catalogue, CmpsCtlgSlctnsFromCtlgItms([]CatalogueItem{
+		{CatalogueID: "c1", CatalogueItemID: 4, Selection: "Sweets", Item: "Nougat", PricingType: SingleItem, Options: []CatalogueOption{{Label: "bar", Threshold: 1, Price: NewMoney(2000, DefaultCurrency)}}},
+		{CatalogueID: "c1", CatalogueItemID: 5, Selection: "Sweets", Item: "Cookie", PricingType: SingleItem, Options: []CatalogueOption{{Label: "single", Threshold: 1, Price: NewMoney(1500, DefaultCurrency)}}},
+	})...)
+
+	tests := []struct {
+		name    string
+		message string
+		want    map[int]int
+	}{
+		{"whole names", "two brownies and a nougat", map[int]int{3: 2, 4: 1}},
+		{"start of a word", "3 cook and 5g lemon", map[int]int{5: 3, 1: 5}},
+		{"two letters", "no", map[int]int{}},
+		{"middle of a word", "ok thanks", map[int]int{}},
+		{"typo", "a cookei", map[int]int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := map[int]int{}
+			for _, line := range ParseNaturalOrder(tt.message, ctlg) {
+				got[line.Item.CatalogueItemID] = line.Quantity
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("parsed %v, want %v", got, tt.want)
+			}
+			for id, quantity := range tt.want {
+				if got[id] != quantity {
+					t.Errorf("parsed %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package menubotlib
+
+import (
+	"fmt"
+)
+
+// How many orders orders? lists, newest first.
+const recentOrdersLimit = 10
+
+// FormatOrderHistory lists the customer's orders with their status and total.
+func FormatOrderHistory(orders []CustomerOrder) string {
+	if len(orders) == 0 {
+		return "You have no orders yet.\nTo see what we have type & send-: shop?"
+	}
+
+	history := "Your orders:"
+	for _, order := range orders {
+		total := "no total yet"
+		if order.OrderTotal.Currency != "" {
+			total = order.OrderTotal.String()
+		}
+		history += fmt.Sprintf("\n#%d - %s - %s", order.OrderID, order.Status.Label(), total)
+	}
+	latest := orders[0].OrderID
+	return history + fmt.Sprintf("\n\nFor the detail of an order type & send-: order? %d\nTo order the same again type & send-: reorder %d", latest, latest)
+}
+
+// Describes the order's status and what is in it.
+func (c *CustomerOrder) describe(prlst Pricelist) string {
+	// If DateTimeDelivered is null then set it to "Not yet delivered"
+	var dateTimeDelivered string
+	if c.DateTimeDelivered.Valid {
+		dateTimeDelivered = c.DateTimeDelivered.Time.Format("2006-01-02 15:04:05")
+	} else {
+		dateTimeDelivered = "Not yet delivered"
+	}
+	return fmt.Sprintf("Status: %s\nIs Paid: %t\nDelivered on: %v\n\n%s",
+		c.Status.Label(), c.IsPaid, dateTimeDelivered, FormatCartSummary(c.OrderItems, prlst.Catalogue, c.Pricing(prlst)))
+}
+
+// Reorder puts the items of a past order in a new draft, which becomes the customer's current order.
+// The items are checked against today's price list, the lines that can't be ordered any more are left
+// out and returned. When none of them can be, the error is an *OrderValidationError and nothing is stored.
+func (c *CustomerOrder) Reorder(orders OrderRepository, past CustomerOrder, prlst Pricelist, isAutoInc bool) ([]OrderLineError, error) {
+	var items OrderItems
+	var dropped []OrderLineError
+	for _, indication := range past.OrderItems.MenuIndications {
+		if indication.ItemAmount == "0" {
+			continue
+		}
+		reason := validateOrderLine(indication, prlst.Catalogue)
+		if reason != "" {
+			dropped = append(dropped, OrderLineError{ItemMenuNum: indication.ItemMenuNum, ItemAmount: indication.ItemAmount, Reason: reason})
+			continue
+		}
+		items.MenuIndications = append(items.MenuIndications, indication)
+	}
+	if len(items.MenuIndications) == 0 {
+		return dropped, &OrderValidationError{Lines: dropped}
+	}
+
+	order := CustomerOrder{CellNumber: past.CellNumber, CatalogueID: past.CatalogueID, OrderItems: items}
+	if !isAutoInc {
+		orderID, err := orders.NextOrderID()
+		if err != nil {
+			return dropped, err
+		}
+		order.OrderID = orderID
+	}
+	order.refreshOrderTotal(prlst)
+	err := order.insertOrder(orders)
+	if err != nil {
+		return dropped, err
+	}
+
+	*c = order
+	return dropped, nil
+}
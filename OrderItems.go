@@ -2,8 +2,6 @@ package menubotlib
 
 import (
 	"fmt"
-	"math"
-	"regexp"
 	"strconv"
 	"strings"
 )
@@ -32,96 +30,142 @@ func findItemInSelections(ItmMnuNum int, ctlgselections []CatalogueSelection) (C
 	return CatalogueItem{}, fmt.Errorf("item menu num not found")
 }
 
-func tallyOptions(options []string, userInput string) (int, error) {
+// Describes an amount the way the customer thinks of it: "5g" for a weight item and
+// "2 x box of 6, 1 x single" for a single item with options.
+func describeItemAmount(item CatalogueItem, amount string) string {
+	switch item.PricingType {
+	case WeightItem:
+		if len(item.Options) > 0 {
+			return amount + item.Options[0].Unit
+		}
+	case SingleItem:
+		var parts []string
+		for _, part := range strings.Split(amount, ",") {
+			var optionNumber, quantity int
+			_, err := fmt.Sscanf(strings.TrimSpace(part), "%dx%d", &optionNumber, &quantity)
+			if err != nil || optionNumber <= 0 || optionNumber > len(item.Options) {
+				return amount
+			}
+			parts = append(parts, fmt.Sprintf("%d x %s", quantity, item.Options[optionNumber-1].Label))
+		}
+		return strings.Join(parts, ", ")
+	}
+	return amount
+}
+
+func tallyOptions(options []CatalogueOption, userInput string) (Money, error) {
 	userItems := strings.Split(userInput, ",")
-	totalPrice := 0
+	totalPrice := NewMoney(0, DefaultCurrency)
 
 	for _, userItem := range userItems {
 		userItem = strings.TrimSpace(userItem)
 		var optionNumber, amount int
 		_, err := fmt.Sscanf(userItem, "%dx%d", &optionNumber, &amount)
 		if err != nil {
-			return -99, fmt.Errorf("while tallying order, error parsing userInput: %s, %v", userItem, err)
+			return Money{}, fmt.Errorf("while tallying order, error parsing userInput: %s, %v", userItem, err)
 		}
 
 		if optionNumber <= 0 || optionNumber > len(options) {
-			return -99, fmt.Errorf("while tallying order, invalid option number: %d", optionNumber)
-		}
-
-		var price int
-
-		// Define the regular expression pattern
-		re := regexp.MustCompile(`@ R(\d+)`)
-
-		// Find the match
-		match := re.FindStringSubmatch(options[optionNumber-1])
-		if len(match) < 2 {
-			return -99, fmt.Errorf("while tallying order, price for item number: " + strconv.Itoa(optionNumber) + " not found in item option string")
+			return Money{}, fmt.Errorf("while tallying order, invalid option number: %d", optionNumber)
 		}
-
-		// Convert the extracted string to an integer
-		price, err = strconv.Atoi(match[1])
-		if err != nil {
-			return -99, fmt.Errorf("while tallying order, error parsing option price: %s, %v", options[optionNumber-1], err)
+		if amount <= 0 {
+			return Money{}, fmt.Errorf("while tallying order, invalid quantity: %d", amount)
 		}
 
-		totalPrice += amount * price
+		totalPrice = totalPrice.Add(options[optionNumber-1].Price.Mul(int64(amount)))
 	}
 
 	return totalPrice, nil
 }
 
 // Helper function to find the best price based on the order amount and options available
-func findBestPrice(orderAmount int, options []string) (int, error) {
-	bestPrice := math.MaxInt32
+func findBestPrice(orderAmount int, options []CatalogueOption) (Money, error) {
+	var bestPrice Money
+	found := false
 	for _, option := range options {
-		var optionWeight int
-		var optionPrice int
-		_, err := fmt.Sscanf(option, "%dg @ R%d", &optionWeight, &optionPrice)
-		if err == nil && orderAmount >= optionWeight {
-			if bestPrice == -1 || optionPrice < bestPrice {
-				bestPrice = optionPrice
+		if orderAmount >= option.Threshold {
+			if !found || option.Price.Cmp(bestPrice) < 0 {
+				bestPrice = option.Price
+				found = true
 			}
 		}
 	}
-	if bestPrice == math.MaxInt32 {
+	if !found {
 		// No valid price found
-		return -99, fmt.Errorf("while tallying order, error finding best price")
+		return Money{}, fmt.Errorf("while tallying order, error finding best price")
 	}
 	return bestPrice, nil
 }
 
-func (c *OrderItems) CalculatePrice(ctlgselections []CatalogueSelection) (int, string) {
-	cartSummary := ""
-	cartTotal := 0
+// OrderLine is one item of an order priced against the catalogue. Err is set when the line can't be priced,
+// its Subtotal is then zero.
+type OrderLine struct {
+	MenuIndication
+	Item     CatalogueItem
+	Subtotal Money
+	Err      error
+}
+
+// PriceLines prices each item of the order on its own, in order.
+func (c *OrderItems) PriceLines(ctlgselections []CatalogueSelection) []OrderLine {
+	lines := make([]OrderLine, 0, len(c.MenuIndications))
 	for _, orderItem := range c.MenuIndications {
+		line := OrderLine{MenuIndication: orderItem, Subtotal: NewMoney(0, DefaultCurrency)}
 		// Look up the item in the sections
 		foundItem, err := findItemInSelections(orderItem.ItemMenuNum, ctlgselections)
 		if err != nil {
-			// Add excluded items to the cart summary.
-			cartSummary += fmt.Sprintf("while tallying the order, user specified Item menu nunmber: %d not found in price list", orderItem.ItemMenuNum)
+			line.Err = fmt.Errorf("while tallying the order, user specified Item menu nunmber: %d not found in price list", orderItem.ItemMenuNum)
+			lines = append(lines, line)
 			continue
 		}
-		switch foundItem.PricingType {
-		case WeightItem:
-			weight, err := strconv.Atoi(orderItem.ItemAmount)
-			if err != nil {
-				cartSummary += fmt.Sprintf("while tallying the order, error converting userInput to weight: %s to integer: %v", orderItem.ItemAmount, err)
-			}
-			price, err := findBestPrice(weight, foundItem.Options)
-			if err != nil {
-				cartSummary += fmt.Sprintln("while tallying the order, error finding best price")
-			}
-			cartTotal += (weight * price)
-		case SingleItem:
-			optionsTotal, err := tallyOptions(foundItem.Options, orderItem.ItemAmount)
-			if err != nil {
-				cartSummary += fmt.Sprintf("while tallying the order, error extracting the order item price: %v", err)
-			}
-			cartTotal += optionsTotal
-		default:
-			cartSummary += fmt.Sprintf("while tallying the order, unknown pricing type: %s", foundItem.PricingType)
+		line.Item = foundItem
+		subtotal, err := priceOrderLine(foundItem, orderItem.ItemAmount)
+		if err != nil {
+			line.Err = err
+		} else {
+			line.Subtotal = subtotal
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func priceOrderLine(foundItem CatalogueItem, itemAmount string) (Money, error) {
+	switch foundItem.PricingType {
+	case WeightItem:
+		weight, err := strconv.Atoi(itemAmount)
+		if err != nil {
+			return Money{}, fmt.Errorf("while tallying the order, error converting userInput to weight: %s to integer: %v", itemAmount, err)
+		}
+		if weight <= 0 {
+			return Money{}, fmt.Errorf("while tallying the order, invalid weight: %d", weight)
+		}
+		price, err := findBestPrice(weight, foundItem.Options)
+		if err != nil {
+			return Money{}, err
+		}
+		return price.Mul(int64(weight)), nil
+	case SingleItem:
+		optionsTotal, err := tallyOptions(foundItem.Options, itemAmount)
+		if err != nil {
+			return Money{}, fmt.Errorf("while tallying the order, error extracting the order item price: %v", err)
+		}
+		return optionsTotal, nil
+	default:
+		return Money{}, fmt.Errorf("while tallying the order, unknown pricing type: %s", foundItem.PricingType)
+	}
+}
+
+func (c *OrderItems) CalculatePrice(ctlgselections []CatalogueSelection) (Money, string) {
+	var excluded []string
+	cartTotal := NewMoney(0, DefaultCurrency)
+	for _, line := range c.PriceLines(ctlgselections) {
+		if line.Err != nil {
+			// Add excluded items to the cart summary.
+			excluded = append(excluded, line.Err.Error())
+			continue
 		}
+		cartTotal = cartTotal.Add(line.Subtotal)
 	}
-	return cartTotal, cartSummary
+	return cartTotal, strings.Join(excluded, "\n")
 }
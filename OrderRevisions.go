@@ -0,0 +1,200 @@
+package menubotlib
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// How many earlier versions of an order's items are kept for undo.
+const maxOrderRevisions = 20
+
+var ErrNothingToUndo = errors.New("nothing to undo")
+
+// OrderDiff is what an update changed in an order's items.
+type OrderDiff struct {
+	Added   []string
+	Changed []string
+	Removed []string
+	Total   Money
+}
+
+func (d OrderDiff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Changed) == 0 && len(d.Removed) == 0
+}
+
+func (d OrderDiff) String() string {
+	var lines []string
+	for _, added := range d.Added {
+		lines = append(lines, "Added: "+added)
+	}
+	for _, changed := range d.Changed {
+		lines = append(lines, "Changed: "+changed)
+	}
+	for _, removed := range d.Removed {
+		lines = append(lines, "Removed: "+removed)
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "No changes.")
+	}
+	return strings.Join(lines, "\n") + "\nNew total: " + d.Total.String()
+}
+
+// DiffOrderItems compares the items before and after an update, items are named from the catalogue.
+func DiffOrderItems(before, after OrderItems, ctlgselections []CatalogueSelection) OrderDiff {
+	var diff OrderDiff
+	previous := make(map[int]string, len(before.MenuIndications))
+	for _, indication := range before.MenuIndications {
+		previous[indication.ItemMenuNum] = indication.ItemAmount
+	}
+
+	current := make(map[int]bool, len(after.MenuIndications))
+	for _, indication := range after.MenuIndications {
+		current[indication.ItemMenuNum] = true
+		amount, existed := previous[indication.ItemMenuNum]
+		switch {
+		case !existed:
+			diff.Added = append(diff.Added, describeOrderLine(indication.ItemMenuNum, indication.ItemAmount, ctlgselections))
+		case amount != indication.ItemAmount:
+			item, err := findItemInSelections(indication.ItemMenuNum, ctlgselections)
+			name := fmt.Sprintf("item %d", indication.ItemMenuNum)
+			if err == nil {
+				name = item.Item
+			}
+			diff.Changed = append(diff.Changed, fmt.Sprintf("%s %s → %s", name,
+				describeItemAmount(item, amount), describeItemAmount(item, indication.ItemAmount)))
+		}
+	}
+	for _, indication := range before.MenuIndications {
+		if !current[indication.ItemMenuNum] {
+			diff.Removed = append(diff.Removed, describeOrderLine(indication.ItemMenuNum, indication.ItemAmount, ctlgselections))
+		}
+	}
+
+	diff.Total, _ = after.CalculatePrice(ctlgselections)
+	return diff
+}
+
+// Diffs the order against its items before a change, with the order's total after its promotions.
+func (c *CustomerOrder) diffFrom(before OrderItems, ctlgselections []CatalogueSelection) OrderDiff {
+	diff := DiffOrderItems(before, c.OrderItems, ctlgselections)
+	diff.Total = c.OrderTotal
+	return diff
+}
+
+func describeOrderLine(itemMenuNum int, amount string, ctlgselections []CatalogueSelection) string {
+	item, err := findItemInSelections(itemMenuNum, ctlgselections)
+	if err != nil {
+		return fmt.Sprintf("item %d: %s", itemMenuNum, amount)
+	}
+	return item.Item + " " + describeItemAmount(item, amount)
+}
+
+// Snapshot the items as they were before an update, so undo can bring them back.
+func (c *CustomerOrder) recordRevision(orders OrderRepository, before OrderItems) {
+	err := orders.PushOrderRevision(c.OrderID, before, time.Now())
+	if err != nil {
+		log.Printf("error recording a revision of order %d: %v", c.OrderID, err)
+	}
+}
+
+// UndoLastUpdate puts back the items the order had before its last update and returns the items it had.
+func (c *CustomerOrder) UndoLastUpdate(orders OrderRepository, senderNum string, prlst Pricelist, isAutoInc bool) (OrderItems, error) {
+	err := c.SetCurrentOrderFromDB(orders, senderNum, isAutoInc)
+	if err != nil {
+		if errors.Is(err, ErrNoRows) {
+			return OrderItems{}, ErrNothingToUndo
+		}
+		return OrderItems{}, err
+	}
+	if !c.Status.IsEditable() {
+		return OrderItems{}, fmt.Errorf("%w: order %d is %s", ErrOrderNotEditable, c.OrderID, c.Status.Label())
+	}
+
+	previous, err := orders.PopOrderRevision(c.OrderID)
+	if err != nil {
+		if errors.Is(err, ErrNoRows) {
+			return OrderItems{}, ErrNothingToUndo
+		}
+		return OrderItems{}, err
+	}
+
+	if c.Status == OrderAwaitingPayment {
+		err = c.TransitionTo(orders, OrderDraft)
+		if err != nil {
+			return OrderItems{}, err
+		}
+	}
+
+	undone := c.OrderItems
+	c.OrderItems = previous
+	c.refreshOrderTotal(prlst)
+	err = c.updateCurrentOrder(orders)
+	if err != nil {
+		return OrderItems{}, err
+	}
+	return undone, nil
+}
+
+func (r *PostgresOrderRepository) PushOrderRevision(orderID int, items OrderItems, at time.Time) error {
+	orderItemsJSON, err := json.Marshal(items)
+	if err != nil {
+		return fmt.Errorf("failed to marshal orderItems: %w", err)
+	}
+
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT INTO customerorderrevision (orderid, orderitems, createdat) VALUES ($1, $2, $3)`, orderID, string(orderItemsJSON), at)
+	if err != nil {
+		return fmt.Errorf("failed to insert order revision: %w", err)
+	}
+
+	// Only the newest revisions are worth keeping
+	queryString := `DELETE FROM customerorderrevision WHERE orderid = $1 AND revisionid NOT IN (
+		SELECT revisionid FROM customerorderrevision WHERE orderid = $1 ORDER BY revisionid DESC LIMIT $2)`
+	_, err = tx.Exec(queryString, orderID, maxOrderRevisions)
+	if err != nil {
+		return fmt.Errorf("failed to trim order revisions: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+func (r *PostgresOrderRepository) PopOrderRevision(orderID int) (OrderItems, error) {
+	var items OrderItems
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return items, err
+	}
+	defer tx.Rollback()
+
+	var revisionID int
+	var orderItemsJSON string
+	queryString := `SELECT revisionid, orderitems FROM customerorderrevision WHERE orderid = $1 ORDER BY revisionid DESC LIMIT 1 FOR UPDATE`
+	err = tx.QueryRow(queryString, orderID).Scan(&revisionID, &orderItemsJSON)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return items, ErrNoRows
+		}
+		return items, err
+	}
+
+	err = json.Unmarshal([]byte(orderItemsJSON), &items)
+	if err != nil {
+		return items, fmt.Errorf("failed to unmarshal orderItems: %w", err)
+	}
+
+	_, err = tx.Exec(`DELETE FROM customerorderrevision WHERE revisionid = $1`, revisionID)
+	if err != nil {
+		return items, err
+	}
+	return items, tx.Commit()
+}
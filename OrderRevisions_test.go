@@ -0,0 +1,167 @@
+package menubotlib
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func revisionConvo() *ConversationContext {
+	return &ConversationContext{UserInfo: UserInfo{CellNumber: testCellNumber}, Pricelist: testPricelist()}
+}
+
+func TestUpdateOrderCommandDiff(t *testing.T) {
+	tests := []struct {
+		name      string
+		cart      OrderItems
+		text      string
+		wantLines []string
+	}{
+		{"added", items("1", "5"), "3:1x2", []string{"Added: Brownie 2 x single", "New total: R550.00"}},
+		{"changed", items("1", "5"), "1:1", []string{"Changed: Lemon Haze 5g → 1g", "New total: R100.00"}},
+		{"removed", items("1", "5", "3", "1x2"), "1:0", []string{"Removed: Lemon Haze 5g", "New total: R100.00"}},
+		{"all three", items("1", "5", "2", "1"), "1:2, 2:0, 3:2x1", []string{
+			"Added: Brownie 1 x box of 6", "Changed: Lemon Haze 5g → 2g", "Removed: Purple Kush 1g", "New total: R450.00",
+		}},
+		{"same amount", items("1", "5"), "1:5", []string{"No changes.", "New total: R450.00"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := storeWithOrder(t, tt.cart, OrderDraft)
+			result := UpdateOrderCommand{Text: tt.text}.Execute(store, revisionConvo(), true)
+			if !result.Success {
+				t.Fatalf("update failed: %s", result.Reply)
+			}
+			want := "successfully updated current order\n" + strings.Join(tt.wantLines, "\n") + "\n\n" + undoOrder
+			if result.Reply != want {
+				t.Errorf("reply =\n%s\nwant\n%s", result.Reply, want)
+			}
+		})
+	}
+}
+
+func TestUndoOrderCommand(t *testing.T) {
+	store := NewMemoryStore()
+	convo := revisionConvo()
+	for _, text := range []string{"1:5", "3:1x2", "1:1"} {
+		if result := (UpdateOrderCommand{Text: text}).Execute(store, convo, true); !result.Success {
+			t.Fatalf("update %s failed: %s", text, result.Reply)
+		}
+	}
+
+	steps := []struct {
+		wantReply string
+		wantCart  OrderItems
+	}{
+		{"Changed: Lemon Haze 1g → 5g", items("1", "5", "3", "1x2")},
+		{"Removed: Brownie 2 x single", items("1", "5")},
+		{"Removed: Lemon Haze 5g", OrderItems{}},
+	}
+	for i, step := range steps {
+		result := UndoOrderCommand{}.Execute(store, revisionConvo(), true)
+		if !result.Success || !strings.HasPrefix(result.Reply, "undid your last change\n"+step.wantReply+"\n") {
+			t.Errorf("undo %d = %q, want it to say %q", i+1, result.Reply, step.wantReply)
+		}
+		order, err := store.Orders.GetCurrentOrder(testCellNumber)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sameItems(order.OrderItems, step.wantCart) {
+			t.Errorf("cart after undo %d = %v, want %v", i+1, order.OrderItems.MenuIndications, step.wantCart.MenuIndications)
+		}
+	}
+
+	result := UndoOrderCommand{}.Execute(store, revisionConvo(), true)
+	if result.Code != CommandInvalidInput || result.Reply != "Nothing to undo." || !errors.Is(result.Err, ErrNothingToUndo) {
+		t.Errorf("undo with no revisions left = %v %q %v", result.Code, result.Reply, result.Err)
+	}
+}
+
+func TestUndoOrderCommandStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     OrderStatus
+		wantCode   CommandCode
+		wantStatus OrderStatus
+		wantCart   OrderItems
+	}{
+		{"awaiting payment goes back to draft", OrderAwaitingPayment, CommandOK, OrderDraft, items("1", "5")},
+		{"paid order is left alone", OrderPaid, CommandInvalidInput, OrderPaid, items("1", "5", "2", "1")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := storeWithOrder(t, items("1", "5"), OrderDraft)
+			if result := (UpdateOrderCommand{Text: "2:1"}).Execute(store, revisionConvo(), true); !result.Success {
+				t.Fatalf("update failed: %s", result.Reply)
+			}
+			var c CustomerOrder
+			if err := c.SetCurrentOrderFromDB(store.Orders, testCellNumber, true); err != nil {
+				t.Fatal(err)
+			}
+			path := map[OrderStatus][]OrderStatus{
+				OrderAwaitingPayment: {OrderAwaitingPayment},
+				OrderPaid:            {OrderAwaitingPayment, OrderPaid},
+			}
+			for _, status := range path[tt.status] {
+				if err := c.TransitionTo(store.Orders, status); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			result := UndoOrderCommand{}.Execute(store, revisionConvo(), true)
+			if result.Code != tt.wantCode {
+				t.Fatalf("code = %v, want %v: %s", result.Code, tt.wantCode, result.Reply)
+			}
+			order, err := store.Orders.GetOrder(testCellNumber, c.OrderID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if order.Status != tt.wantStatus || !sameItems(order.OrderItems, tt.wantCart) {
+				t.Errorf("order = %s %v, want %s %v", order.Status, order.OrderItems.MenuIndications, tt.wantStatus, tt.wantCart.MenuIndications)
+			}
+		})
+	}
+}
+
+func TestOrderRevisionCap(t *testing.T) {
+	store := NewMemoryStore()
+	convo := revisionConvo()
+	updates := maxOrderRevisions + 5
+	for i := 1; i <= updates; i++ {
+		if result := (UpdateOrderCommand{Text: fmt.Sprintf("1:%d", i)}).Execute(store, convo, true); !result.Success {
+			t.Fatalf("update %d failed: %s", i, result.Reply)
+		}
+	}
+
+	// Only the newest revisions are kept, undo walks back through them newest first
+	undone := 0
+	for {
+		result := UndoOrderCommand{}.Execute(store, revisionConvo(), true)
+		if errors.Is(result.Err, ErrNothingToUndo) {
+			break
+		}
+		if !result.Success {
+			t.Fatalf("undo %d failed: %s", undone+1, result.Reply)
+		}
+		undone++
+		order, err := store.Orders.GetCurrentOrder(testCellNumber)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := items("1", fmt.Sprint(updates-undone))
+		if !sameItems(order.OrderItems, want) {
+			t.Fatalf("cart after undo %d = %v, want %v", undone, order.OrderItems.MenuIndications, want.MenuIndications)
+		}
+		if undone > updates {
+			t.Fatal("undo never ran out of revisions")
+		}
+	}
+	if undone != maxOrderRevisions {
+		t.Errorf("undid %d updates, want the newest %d", undone, maxOrderRevisions)
+	}
+
+	if _, err := store.Orders.PopOrderRevision(1); !errors.Is(err, ErrNoRows) {
+		t.Errorf("PopOrderRevision with none left = %v, want %v", err, ErrNoRows)
+	}
+}
@@ -0,0 +1,211 @@
+package menubotlib
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type OrderStatus string
+
+const (
+	OrderDraft           OrderStatus = "draft"
+	OrderAwaitingPayment OrderStatus = "awaiting_payment"
+	OrderPaid            OrderStatus = "paid"
+	OrderPreparing       OrderStatus = "preparing"
+	OrderOutForDelivery  OrderStatus = "out_for_delivery"
+	OrderDelivered       OrderStatus = "delivered"
+	OrderCancelled       OrderStatus = "cancelled"
+	OrderRefunded        OrderStatus = "refunded"
+)
+
+var ErrIllegalOrderTransition = errors.New("illegal order status transition")
+
+// Every status an order may move to from a given status, anything else is rejected.
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	OrderDraft:           {OrderAwaitingPayment, OrderCancelled},
+	OrderAwaitingPayment: {OrderDraft, OrderPaid, OrderCancelled},
+	OrderPaid:            {OrderPreparing, OrderRefunded},
+	OrderPreparing:       {OrderOutForDelivery, OrderDelivered, OrderRefunded},
+	OrderOutForDelivery:  {OrderDelivered, OrderRefunded},
+	OrderDelivered:       {OrderRefunded},
+	OrderCancelled:       {},
+	OrderRefunded:        {},
+}
+
+type OrderStatusChange struct {
+	OrderID    int
+	FromStatus OrderStatus
+	ToStatus   OrderStatus
+	ChangedAt  time.Time
+}
+
+func (s OrderStatus) CanTransitionTo(to OrderStatus) bool {
+	for _, allowed := range orderTransitions[s] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether money has been received for an order in this status.
+func (s OrderStatus) IsPaid() bool {
+	switch s {
+	case OrderPaid, OrderPreparing, OrderOutForDelivery, OrderDelivered, OrderRefunded:
+		return true
+	}
+	return false
+}
+
+func (s OrderStatus) IsClosed() bool {
+	return s == OrderDelivered || s == OrderCancelled || s == OrderRefunded
+}
+
+// Whether the customer may still change the items on the order.
+func (s OrderStatus) IsEditable() bool {
+	return s == OrderDraft || s == OrderAwaitingPayment
+}
+
+func (s OrderStatus) Label() string {
+	return strings.ReplaceAll(string(s), "_", " ")
+}
+
+// TransitionTo moves the order to a new status and keeps IsPaid, IsClosed and DateTimeDelivered in step.
+func (c *CustomerOrder) TransitionTo(orders OrderRepository, to OrderStatus) error {
+	changedAt := time.Now()
+	err := orders.TransitionOrderStatus(c.OrderID, to, changedAt)
+	if err != nil {
+		return err
+	}
+
+	c.Status = to
+	c.IsPaid = to.IsPaid()
+	c.IsClosed = to.IsClosed()
+	if to == OrderDelivered {
+		c.DateTimeDelivered = sql.NullTime{Time: changedAt, Valid: true}
+	}
+	return nil
+}
+
+func (c *CustomerOrder) MarkDelivered(orders OrderRepository) error {
+	return c.TransitionTo(orders, OrderDelivered)
+}
+
+// Reports whether the move is a no-op, moving to the status an order already has leaves no history.
+func checkOrderTransition(orderID int, from, to OrderStatus) (bool, error) {
+	if from == to {
+		return true, nil
+	}
+	if !from.CanTransitionTo(to) {
+		return false, fmt.Errorf("%w: order %d from %s to %s", ErrIllegalOrderTransition, orderID, from, to)
+	}
+	return false, nil
+}
+
+// Reports whether the order was already paid, repeated notifications for it are fine.
+// Otherwise the paid amount has to match the total stored at checkout.
+func checkPayment(orderID int, status OrderStatus, orderTotal *Money, amountPaid Money) (bool, error) {
+	if status.IsPaid() {
+		return true, nil
+	}
+	if orderTotal == nil {
+		return false, fmt.Errorf("%w: order %d has no total", ErrPaymentAmountMismatch, orderID)
+	}
+	cmp, err := orderTotal.CheckedCmp(amountPaid)
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", ErrPaymentAmountMismatch, err)
+	}
+	if cmp != 0 {
+		return false, fmt.Errorf("%w: paid %s, expected %s", ErrPaymentAmountMismatch, amountPaid, *orderTotal)
+	}
+	return false, nil
+}
+
+func (r *PostgresOrderRepository) TransitionOrderStatus(orderID int, to OrderStatus, at time.Time) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = transitionOrderStatusTx(tx, orderID, to, at)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func transitionOrderStatusTx(tx *sql.Tx, orderID int, to OrderStatus, changedAt time.Time) error {
+	var from OrderStatus
+	err := tx.QueryRow(`SELECT status FROM customerorder WHERE orderid = $1 FOR UPDATE`, orderID).Scan(&from)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNoRows
+		}
+		return err
+	}
+
+	noop, err := checkOrderTransition(orderID, from, to)
+	if err != nil || noop {
+		return err
+	}
+
+	var delivered sql.NullTime
+	if to == OrderDelivered {
+		delivered = sql.NullTime{Time: changedAt, Valid: true}
+	}
+
+	queryString := `UPDATE customerorder SET status = $1, ispaid = $2, isclosed = $3, datetimedelivered = COALESCE($4, datetimedelivered) WHERE orderid = $5`
+	_, err = tx.Exec(queryString, to, to.IsPaid(), to.IsClosed(), delivered, orderID)
+	if err != nil {
+		return fmt.Errorf("failed to update order status: %w", err)
+	}
+
+	err = settleOrderStockTx(tx, orderID, to)
+	if err != nil {
+		return err
+	}
+
+	return insertOrderStatusChange(tx, OrderStatusChange{OrderID: orderID, FromStatus: from, ToStatus: to, ChangedAt: changedAt})
+}
+
+func insertOrderStatusChange(tx *sql.Tx, change OrderStatusChange) error {
+	var from sql.NullString
+	if change.FromStatus != "" {
+		from = sql.NullString{String: string(change.FromStatus), Valid: true}
+	}
+
+	queryString := `INSERT INTO customerorderstatushistory (orderid, fromstatus, tostatus, changedat) VALUES ($1, $2, $3, $4)`
+	_, err := tx.Exec(queryString, change.OrderID, from, change.ToStatus, change.ChangedAt)
+	if err != nil {
+		return fmt.Errorf("failed to record order status change: %w", err)
+	}
+	return nil
+}
+
+// GetOrderStatusHistory returns the order's status changes, oldest first.
+func (r *PostgresOrderRepository) GetOrderStatusHistory(orderID int) ([]OrderStatusChange, error) {
+	queryString := `SELECT orderid, fromstatus, tostatus, changedat FROM customerorderstatushistory WHERE orderid = $1 ORDER BY changedat, historyid`
+	rows, err := r.DB.Query(queryString, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []OrderStatusChange
+	for rows.Next() {
+		var change OrderStatusChange
+		var from sql.NullString
+		err := rows.Scan(&change.OrderID, &from, &change.ToStatus, &change.ChangedAt)
+		if err != nil {
+			return nil, err
+		}
+		change.FromStatus = OrderStatus(from.String)
+		history = append(history, change)
+	}
+
+	return history, rows.Err()
+}
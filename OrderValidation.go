@@ -0,0 +1,116 @@
+package menubotlib
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// One option of a single item as it is stored, "2x3" is three of option 2.
+var regexOptionQuantity = regexp.MustCompile(`^(\d+)x(\d+)$`)
+
+// OrderLineError is a line of an order that can't be priced, Reason is written for the customer.
+type OrderLineError struct {
+	ItemMenuNum int
+	ItemAmount  string
+	Reason      string
+}
+
+func (e OrderLineError) Error() string {
+	return fmt.Sprintf("item %d (%s): %s", e.ItemMenuNum, e.ItemAmount, e.Reason)
+}
+
+// OrderValidationError lists every invalid line of an order, not just the first.
+type OrderValidationError struct {
+	Lines []OrderLineError
+}
+
+func (e *OrderValidationError) Error() string {
+	var lines []string
+	for _, line := range e.Lines {
+		lines = append(lines, line.Error())
+	}
+	return "invalid order: " + strings.Join(lines, "; ")
+}
+
+// Feedback tells the customer what is wrong with each line.
+func (e *OrderValidationError) Feedback() string {
+	feedback := "Some items need fixing:"
+	for _, line := range e.Lines {
+		feedback += fmt.Sprintf("\n- %d: %s", line.ItemMenuNum, line.Reason)
+	}
+	return feedback
+}
+
+// ValidateOrderItems checks every line against the catalogue: the item has to exist, a weight has to be
+// a whole number that reaches the item's smallest tier and single items need "optionxquantity" amounts
+// with options the item has. It returns an *OrderValidationError, or nil when every line can be priced.
+// Lines with an amount of "0" are removals and always valid.
+func ValidateOrderItems(items OrderItems, ctlgselections []CatalogueSelection) error {
+	var invalid []OrderLineError
+	for _, indication := range items.MenuIndications {
+		if indication.ItemAmount == "0" {
+			continue
+		}
+		reason := validateOrderLine(indication, ctlgselections)
+		if reason != "" {
+			invalid = append(invalid, OrderLineError{ItemMenuNum: indication.ItemMenuNum, ItemAmount: indication.ItemAmount, Reason: reason})
+		}
+	}
+	if len(invalid) > 0 {
+		return &OrderValidationError{Lines: invalid}
+	}
+	return nil
+}
+
+func validateOrderLine(indication MenuIndication, ctlgselections []CatalogueSelection) string {
+	item, err := findItemInSelections(indication.ItemMenuNum, ctlgselections)
+	if err != nil {
+		return fmt.Sprintf("there is no item %d on the price list", indication.ItemMenuNum)
+	}
+	if len(item.Options) == 0 {
+		return fmt.Sprintf("%s is not available right now", item.Item)
+	}
+
+	switch item.PricingType {
+	case WeightItem:
+		unit := item.Options[0].Unit
+		weight, err := strconv.Atoi(strings.TrimSpace(indication.ItemAmount))
+		if err != nil || weight <= 0 {
+			return fmt.Sprintf("%q is not a whole number of %s for %s, e.g. %d:5", indication.ItemAmount, unit, item.Item, item.CatalogueItemID)
+		}
+		smallest := item.Options[0].Threshold
+		for _, option := range item.Options {
+			smallest = min(smallest, option.Threshold)
+		}
+		if weight < smallest {
+			return fmt.Sprintf("the smallest amount of %s is %d%s", item.Item, smallest, unit)
+		}
+	case SingleItem:
+		for _, part := range strings.Split(indication.ItemAmount, ",") {
+			part = strings.TrimSpace(part)
+			match := regexOptionQuantity.FindStringSubmatch(part)
+			if match == nil {
+				return fmt.Sprintf("%q should be option x quantity for %s, e.g. %d:1x2", part, item.Item, item.CatalogueItemID)
+			}
+			optionNumber, _ := strconv.Atoi(match[1])
+			quantity, _ := strconv.Atoi(match[2])
+			if optionNumber <= 0 || optionNumber > len(item.Options) {
+				return fmt.Sprintf("%s has no option %d, choose 1 to %d", item.Item, optionNumber, len(item.Options))
+			}
+			if quantity <= 0 {
+				return fmt.Sprintf("the quantity of %s in %q has to be at least 1", item.Item, part)
+			}
+		}
+	default:
+		return fmt.Sprintf("%s can't be ordered right now", item.Item)
+	}
+
+	// Anything the checks above missed still must not reach the total
+	_, err = priceOrderLine(item, indication.ItemAmount)
+	if err != nil {
+		return fmt.Sprintf("%s could not be priced", item.Item)
+	}
+	return ""
+}
@@ -0,0 +1,252 @@
+package menubotlib
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+type PromotionKind string
+
+const (
+	// Percent off the lines the promotion covers.
+	PromotionPercentOff PromotionKind = "percent_off"
+	// A fixed Amount off, never more than the lines it covers cost.
+	PromotionFixedOff PromotionKind = "fixed_off"
+	// For every BuyQuantity of an option the next FreeQuantity are free, single items only.
+	PromotionBuyXGetY PromotionKind = "buy_x_get_y"
+)
+
+var (
+	ErrPromotionExpired  = errors.New("promotion is not running")
+	ErrPromotionUsedUp   = errors.New("promotion already used")
+	ErrNoPromoRepository = errors.New("promotions are not set up")
+)
+
+// Promotion is a sale or discount code. Automatic promotions apply to every order while they run,
+// the others only to orders the customer applied the Code to.
+type Promotion struct {
+	// Code identifies the promotion and is what customers type, it is matched case insensitively.
+	Code         string
+	Description  string
+	Kind         PromotionKind
+	Automatic    bool
+	Percent      int
+	Amount       Money
+	BuyQuantity  int
+	FreeQuantity int
+	// Selection limits the promotion to one selection of the price list, empty covers the whole order.
+	Selection string
+	// A zero StartsAt or ExpiresAt leaves that end open.
+	StartsAt  time.Time
+	ExpiresAt time.Time
+	// MaxUsesPerCustomer counts the customer's paid orders with the code or the automatic discount, 0 is unlimited.
+	MaxUsesPerCustomer int
+}
+
+// OrderDiscount is what one promotion took off an order.
+type OrderDiscount struct {
+	Code        string `json:"Code"`
+	Description string `json:"Description"`
+	Amount      Money  `json:"Amount"`
+	// Selection is the promotion's, the discount came off the lines of that selection or all of them when empty.
+	Selection string `json:"Selection"`
+}
+
+// OrderPricing is an order's total and how it was arrived at.
+type OrderPricing struct {
+	Subtotal  Money
+	Discounts []OrderDiscount
+	// Fulfilment is empty until the customer chose or the shop has only one way.
+	Fulfilment   Fulfilment
+	DeliveryZone string
+	DeliveryFee  Money
+	// Taxes are included in Total either way, TaxIncluded says whether the prices already held them.
+	Taxes       []OrderTax
+	TaxIncluded bool
+	// TaxNumber is the shop's tax registration, printed under the taxes.
+	TaxNumber string
+	Total     Money
+}
+
+// PromotionRepository stores promotions keyed by code.
+type PromotionRepository interface {
+	// GetPromotion returns ErrNoRows for an unknown code.
+	GetPromotion(code string) (Promotion, error)
+	// ActivePromotions returns the promotions running at the given time, automatic or not.
+	ActivePromotions(at time.Time) ([]Promotion, error)
+	SavePromotion(p Promotion) error
+}
+
+func NormalisePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func (p Promotion) ActiveAt(at time.Time) bool {
+	if !p.StartsAt.IsZero() && at.Before(p.StartsAt) {
+		return false
+	}
+	return p.ExpiresAt.IsZero() || at.Before(p.ExpiresAt)
+}
+
+func (p Promotion) covers(line OrderLine) bool {
+	return selectionCovers(p.Selection, line)
+}
+
+func (d OrderDiscount) covers(line OrderLine) bool {
+	return selectionCovers(d.Selection, line)
+}
+
+func selectionCovers(selection string, line OrderLine) bool {
+	return selection == "" || strings.EqualFold(selection, line.Item.Selection)
+}
+
+// Discount works out what the promotion takes off the priced lines, zero when it covers none of them.
+func (p Promotion) Discount(lines []OrderLine) Money {
+	discount := NewMoney(0, DefaultCurrency)
+	covered := NewMoney(0, DefaultCurrency)
+	for _, line := range lines {
+		if line.Err != nil || !p.covers(line) {
+			continue
+		}
+		covered = covered.Add(line.Subtotal)
+		if p.Kind == PromotionBuyXGetY && line.Item.PricingType == SingleItem && p.BuyQuantity > 0 && p.FreeQuantity > 0 {
+			for _, part := range CartLineParts(line) {
+				free := part.Quantity / (p.BuyQuantity + p.FreeQuantity) * p.FreeQuantity
+				discount = discount.Add(part.UnitPrice.Mul(int64(free)))
+			}
+		}
+	}
+
+	switch p.Kind {
+	case PromotionPercentOff:
+		discount = covered.MulFrac(int64(p.Percent), 100)
+	case PromotionFixedOff:
+		discount = p.Amount
+	}
+	// A discount never makes the covered lines cost less than nothing
+	if discount.Cmp(covered) > 0 {
+		discount = covered
+	}
+	if discount.IsNegative() {
+		discount = NewMoney(0, DefaultCurrency)
+	}
+	return discount
+}
+
+// PriceOrder totals the order's lines and takes off the automatic promotions and the promotion of promoCode,
+// as far as they are among the given promotions. Lines that can't be priced are left out.
+func PriceOrder(items OrderItems, promoCode string, prlst Pricelist) OrderPricing {
+	lines := items.PriceLines(prlst.Catalogue)
+	pricing := OrderPricing{Subtotal: NewMoney(0, DefaultCurrency)}
+	for _, line := range lines {
+		if line.Err == nil {
+			pricing.Subtotal = pricing.Subtotal.Add(line.Subtotal)
+		}
+	}
+
+	promoCode = NormalisePromoCode(promoCode)
+	remaining := pricing.Subtotal
+	for _, promotion := range prlst.Promotions {
+		if !promotion.Automatic && (promoCode == "" || NormalisePromoCode(promotion.Code) != promoCode) {
+			continue
+		}
+		amount := promotion.Discount(lines)
+		// Stacked promotions can't take off more than is left
+		if amount.Cmp(remaining) > 0 {
+			amount = remaining
+		}
+		if amount.IsZero() {
+			continue
+		}
+		remaining = remaining.Sub(amount)
+		pricing.Discounts = append(pricing.Discounts, OrderDiscount{Code: promotion.Code, Description: promotion.Description, Amount: amount, Selection: promotion.Selection})
+	}
+	pricing.Total = remaining
+	return pricing
+}
+
+// ActivePromotions reads the promotions running now, automatic ones first. Errors are logged
+// and leave the order at full price.
+func ActivePromotions(promotions PromotionRepository) []Promotion {
+	if promotions == nil {
+		return nil
+	}
+	active, err := promotions.ActivePromotions(time.Now())
+	if err != nil {
+		log.Printf("error reading active promotions: %v", err)
+		return nil
+	}
+	sort.SliceStable(active, func(i, j int) bool { return active[i].Automatic && !active[j].Automatic })
+	return active
+}
+
+// Leaves out the automatic promotions the customer has had as often as MaxUsesPerCustomer allows,
+// codes are checked when they are applied. A count that can't be read leaves the promotion out.
+func customerPromotions(orders OrderRepository, cellNumber string, promotions []Promotion) []Promotion {
+	var available []Promotion
+	for _, promotion := range promotions {
+		if promotion.Automatic && promotion.MaxUsesPerCustomer > 0 {
+			uses, err := orders.CountPromoCodeUses(cellNumber, promotion.Code)
+			if err != nil {
+				log.Printf("error counting uses of promotion %s by %s: %v", promotion.Code, cellNumber, err)
+				continue
+			}
+			if uses >= promotion.MaxUsesPerCustomer {
+				continue
+			}
+		}
+		available = append(available, promotion)
+	}
+	return available
+}
+
+// ApplyPromoCode puts the code on the order, an empty code takes it off. The total is recomputed,
+// so an order awaiting payment goes back to draft and has to be checked out again.
+func (c *CustomerOrder) ApplyPromoCode(orders OrderRepository, code string, prlst Pricelist) error {
+	if c.Status == OrderAwaitingPayment {
+		err := c.TransitionTo(orders, OrderDraft)
+		if err != nil {
+			return err
+		}
+	}
+	c.PromoCode = NormalisePromoCode(code)
+	c.refreshOrderTotal(prlst)
+	return c.updateCurrentOrder(orders)
+}
+
+func (c *CustomerOrder) hasDiscount(code string) bool {
+	for _, discount := range c.Discounts {
+		if NormalisePromoCode(discount.Code) == NormalisePromoCode(code) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckPromoCode looks the code up and checks it is running and the customer hasn't used it up.
+func CheckPromoCode(store Store, cellNumber, code string, at time.Time) (Promotion, error) {
+	if store.Promotions == nil {
+		return Promotion{}, ErrNoPromoRepository
+	}
+	promotion, err := store.Promotions.GetPromotion(NormalisePromoCode(code))
+	if err != nil {
+		return Promotion{}, err
+	}
+	if !promotion.ActiveAt(at) {
+		return promotion, fmt.Errorf("%w: %s", ErrPromotionExpired, promotion.Code)
+	}
+	if promotion.MaxUsesPerCustomer > 0 {
+		uses, err := store.Orders.CountPromoCodeUses(cellNumber, promotion.Code)
+		if err != nil {
+			return promotion, err
+		}
+		if uses >= promotion.MaxUsesPerCustomer {
+			return promotion, fmt.Errorf("%w: %s by %s", ErrPromotionUsedUp, promotion.Code, cellNumber)
+		}
+	}
+	return promotion, nil
+}
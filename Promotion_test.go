@@ -0,0 +1,38 @@
+package menubotlib
+
+import "testing"
+
+func TestAutomaticPromotionMaxUses(t *testing.T) {
+	welcome := Promotion{Code: "WELCOME", Description: "10% off your first order", Kind: PromotionPercentOff, Automatic: true, Percent: 10, MaxUsesPerCustomer: 1}
+	tests := []struct {
+		name      string
+		discounts []OrderDiscount
+		status    OrderStatus
+		wantTotal int64
+	}{
+		{"first order", nil, "", 40500},
+		{"earlier order unpaid", []OrderDiscount{{Code: "WELCOME", Amount: NewMoney(4500, DefaultCurrency)}}, OrderCancelled, 40500},
+		{"already had it", []OrderDiscount{{Code: "WELCOME", Amount: NewMoney(4500, DefaultCurrency)}}, OrderPaid, 45000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			err := store.Promotions.SavePromotion(welcome)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.status != "" {
+				_, err = store.Orders.InsertOrder(CustomerOrder{CellNumber: testCellNumber, Status: tt.status, IsPaid: tt.status.IsPaid(), Discounts: tt.discounts})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			convo := NewConversationContext(store, testCellNumber, "", testPricelist(), true)
+			pricing := PriceOrder(items("1", "5"), "", convo.Pricelist)
+			if pricing.Total.Minor != tt.wantTotal {
+				t.Errorf("total = %s, want %s", pricing.Total, NewMoney(tt.wantTotal, DefaultCurrency))
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package menubotlib
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// How long checkout holds stock for an order, after that it is available to others again.
+const StockReservationTimeout = 30 * time.Minute
+
+// The stock of a weight item is one pool in its unit, its options are price tiers rather than products.
+const weightItemStockOption = 0
+
+// StockKey names what is counted: an option of a single item, numbered from 1,
+// or a whole weight item with Option 0.
+type StockKey struct {
+	CatalogueID     string
+	CatalogueItemID int
+	Option          int
+}
+
+// StockLevel is the tracked stock of one StockKey, units for single items and the item's unit (grams) for weight items.
+// Items without a level aren't tracked and never run out.
+type StockLevel struct {
+	StockKey
+	OnHand int
+	// Reserved is held by orders awaiting payment whose reservation hasn't expired.
+	Reserved int
+}
+
+func (l StockLevel) Available() int {
+	return l.OnHand - l.Reserved
+}
+
+// StockQuantity is how much of a StockKey an order needs.
+type StockQuantity struct {
+	StockKey
+	Quantity int
+}
+
+// StockShortage is a quantity an order needs that isn't available.
+type StockShortage struct {
+	StockQuantity
+	Available int
+}
+
+// OutOfStockError lists everything a reservation was short of, nothing is reserved when it is returned.
+type OutOfStockError struct {
+	Shortages []StockShortage
+}
+
+func (e *OutOfStockError) Error() string {
+	var short []string
+	for _, s := range e.Shortages {
+		short = append(short, fmt.Sprintf("%s/%d/%d needs %d, %d available", s.CatalogueID, s.CatalogueItemID, s.Option, s.Quantity, s.Available))
+	}
+	return "out of stock: " + strings.Join(short, "; ")
+}
+
+// Feedback tells the customer which items ran out, naming them from the catalogue.
+func (e *OutOfStockError) Feedback(ctlgselections []CatalogueSelection) string {
+	feedback := "Sorry, we don't have enough of:"
+	for _, s := range e.Shortages {
+		name := fmt.Sprintf("item %d", s.CatalogueItemID)
+		item, err := findItemInSelections(s.CatalogueItemID, ctlgselections)
+		if err == nil {
+			name = item.Item
+			if s.Option != weightItemStockOption && s.Option <= len(item.Options) {
+				name += " (" + item.Options[s.Option-1].Label + ")"
+			}
+		}
+		if s.Available <= 0 {
+			feedback += fmt.Sprintf("\n- %s, it is sold out", name)
+			continue
+		}
+		feedback += fmt.Sprintf("\n- %s, only %d left", name, s.Available)
+	}
+	return feedback
+}
+
+// StockRepository keeps stock levels and the reservations orders hold against them.
+// Reservations are made at checkout, released when the order goes back to draft or is cancelled
+// and taken off the stock on hand when it is paid. An expired reservation no longer holds stock
+// but is still taken off when a late payment arrives.
+type StockRepository interface {
+	// GetStockLevels returns the tracked stock of a catalogue with what unexpired reservations hold.
+	GetStockLevels(catalogueID string) ([]StockLevel, error)
+	SetStockOnHand(key StockKey, onHand int) error
+	// ReserveStock replaces the order's reservation with the given quantities, all or nothing.
+	// When anything is short it returns an *OutOfStockError.
+	ReserveStock(orderID int, quantities []StockQuantity, expiresAt time.Time) error
+	ReleaseStock(orderID int) error
+	// CommitStock takes the order's reservation off the stock on hand.
+	CommitStock(orderID int) error
+}
+
+// OrderStockQuantities is the stock the order's items need, lines that can't be priced are left out.
+func OrderStockQuantities(items OrderItems, ctlgselections []CatalogueSelection) []StockQuantity {
+	var quantities []StockQuantity
+	for _, line := range items.PriceLines(ctlgselections) {
+		for _, part := range CartLineParts(line) {
+			key := StockKey{CatalogueID: line.Item.CatalogueID, CatalogueItemID: line.ItemMenuNum, Option: part.Option}
+			quantities = addStockQuantity(quantities, StockQuantity{StockKey: key, Quantity: part.Quantity})
+		}
+	}
+	return quantities
+}
+
+// "1x2, 1x3" asks for five of option 1.
+func addStockQuantity(quantities []StockQuantity, q StockQuantity) []StockQuantity {
+	for i := range quantities {
+		if quantities[i].StockKey == q.StockKey {
+			quantities[i].Quantity += q.Quantity
+			return quantities
+		}
+	}
+	return append(quantities, q)
+}
+
+// Works out which requested quantities the levels can't cover, untracked keys always can.
+func stockShortages(quantities []StockQuantity, levels map[StockKey]StockLevel) []StockShortage {
+	var short []StockShortage
+	for _, q := range quantities {
+		level, tracked := levels[q.StockKey]
+		if tracked && level.Available() < q.Quantity {
+			short = append(short, StockShortage{StockQuantity: q, Available: max(level.Available(), 0)})
+		}
+	}
+	return short
+}
+
+// Holds the order's stock before it is handed to the payment gateway.
+func reserveOrderStock(stock StockRepository, c CustomerOrder, ctlgselections []CatalogueSelection) error {
+	if stock == nil {
+		return nil
+	}
+	return stock.ReserveStock(c.OrderID, OrderStockQuantities(c.OrderItems, ctlgselections), time.Now().Add(StockReservationTimeout))
+}
+
+// WithStockLevels returns a copy of the catalogue with each item's Available filled in from the stock levels.
+// Errors are logged and leave the catalogue as it is, a price list without markers beats no price list.
+func WithStockLevels(stock StockRepository, ctlgselections []CatalogueSelection) []CatalogueSelection {
+	if stock == nil {
+		return ctlgselections
+	}
+
+	levels := make(map[StockKey]StockLevel)
+	fetched := make(map[string]bool)
+	for _, selection := range ctlgselections {
+		for _, item := range selection.Items {
+			if fetched[item.CatalogueID] {
+				continue
+			}
+			fetched[item.CatalogueID] = true
+			catalogueLevels, err := stock.GetStockLevels(item.CatalogueID)
+			if err != nil {
+				log.Printf("error reading stock levels of catalogue %s: %v", item.CatalogueID, err)
+				return ctlgselections
+			}
+			for _, level := range catalogueLevels {
+				levels[level.StockKey] = level
+			}
+		}
+	}
+	if len(levels) == 0 {
+		return ctlgselections
+	}
+
+	withLevels := make([]CatalogueSelection, len(ctlgselections))
+	for i, selection := range ctlgselections {
+		selection.Items = append([]CatalogueItem(nil), selection.Items...)
+		for j := range selection.Items {
+			item := &selection.Items[j]
+			item.Available = nil
+			for option := 0; option <= len(item.Options); option++ {
+				level, ok := levels[StockKey{CatalogueID: item.CatalogueID, CatalogueItemID: item.CatalogueItemID, Option: option}]
+				if !ok {
+					continue
+				}
+				if item.Available == nil {
+					item.Available = make(map[int]int)
+				}
+				item.Available[option] = max(level.Available(), 0)
+			}
+		}
+		withLevels[i] = selection
+	}
+	return withLevels
+}
+
+// Whether an option can't be ordered with the stock left, untracked stock never runs out.
+func (i *CatalogueItem) optionOutOfStock(optionIndex int) bool {
+	if i.PricingType == WeightItem {
+		available, tracked := i.Available[weightItemStockOption]
+		return tracked && available < max(i.Options[optionIndex].Threshold, 1)
+	}
+	available, tracked := i.Available[optionIndex+1]
+	return tracked && available <= 0
+}
+
+// Whether none of the item's options can be ordered.
+func (i *CatalogueItem) OutOfStock() bool {
+	if len(i.Available) == 0 || len(i.Options) == 0 {
+		return false
+	}
+	for index := range i.Options {
+		if !i.optionOutOfStock(index) {
+			return false
+		}
+	}
+	return true
+}
+
+// Keeps the stock in step with an order's new status: going back to draft or being cancelled releases
+// the reservation, being paid takes it off the stock on hand.
+func settleOrderStock(stock StockRepository, orderID int, to OrderStatus) error {
+	if stock == nil {
+		return nil
+	}
+	switch to {
+	case OrderDraft, OrderCancelled:
+		return stock.ReleaseStock(orderID)
+	case OrderPaid:
+		return stock.CommitStock(orderID)
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package menubotlib
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TaxRate is the tax charged on one class of items.
+type TaxRate struct {
+	// Name is how the tax is shown to the customer, e.g. VAT.
+	Name string
+	// BasisPoints is the rate in hundredths of a percent, 1500 is 15%. Zero rated classes have 0.
+	BasisPoints int64
+}
+
+func (r TaxRate) String() string {
+	percent := fmt.Sprintf("%d.%02d", r.BasisPoints/100, r.BasisPoints%100)
+	return r.Name + " " + strings.TrimSuffix(strings.TrimRight(percent, "0"), ".") + "%"
+}
+
+// TaxConfig is how orders are taxed, a Pricelist without one charges no tax.
+type TaxConfig struct {
+	// Rates are keyed by tax class, items of a class that isn't listed are taxed at the DefaultClass.
+	Rates        map[string]TaxRate
+	DefaultClass string
+	// DeliveryClass is the class of the delivery fee, empty is the DefaultClass.
+	DeliveryClass string
+	// PricesIncludeTax is true when catalogue prices and fees already include the tax,
+	// otherwise it is added on top of them.
+	PricesIncludeTax bool
+	// RegistrationNumber is printed under the tax on the cart summary, e.g. "VAT no. 4123456789".
+	RegistrationNumber string
+}
+
+// OrderTax is the tax on one class of an order.
+type OrderTax struct {
+	Class       string `json:"Class"`
+	Name        string `json:"Name"`
+	BasisPoints int64  `json:"BasisPoints"`
+	// Taxable is what the tax was worked out on, it includes the tax when prices do.
+	Taxable Money `json:"Taxable"`
+	Amount  Money `json:"Amount"`
+}
+
+func (t OrderTax) Rate() TaxRate {
+	return TaxRate{Name: t.Name, BasisPoints: t.BasisPoints}
+}
+
+func (t *TaxConfig) classOf(class string) string {
+	if _, ok := t.Rates[class]; ok {
+		return class
+	}
+	return t.DefaultClass
+}
+
+// TaxOn is the tax on an amount of the class, taken out of it when prices include tax and added to it otherwise.
+func (t *TaxConfig) TaxOn(class string, amount Money) Money {
+	rate := t.Rates[t.classOf(class)]
+	if t.PricesIncludeTax {
+		return amount.MulFrac(rate.BasisPoints, 10000+rate.BasisPoints)
+	}
+	return amount.MulFrac(rate.BasisPoints, 10000)
+}
+
+// Works out the tax of every class in the order. Each discount is spread over the classes of the lines
+// its promotion covers, in proportion to what those lines cost, the delivery fee is taxed in the DeliveryClass.
+// Tax that isn't included in the prices is added to the total.
+func (t *TaxConfig) priceTax(lines []OrderLine, pricing OrderPricing) OrderPricing {
+	if t == nil {
+		return pricing
+	}
+	pricing.TaxIncluded = t.PricesIncludeTax
+	pricing.TaxNumber = t.RegistrationNumber
+
+	var classes []string
+	taxable := make(map[string]Money)
+	addTaxable := func(class string, amount Money) {
+		class = t.classOf(class)
+		if _, ok := taxable[class]; !ok {
+			classes = append(classes, class)
+			taxable[class] = NewMoney(0, DefaultCurrency)
+		}
+		taxable[class] = taxable[class].Add(amount)
+	}
+	for _, line := range lines {
+		if line.Err == nil {
+			addTaxable(line.Item.TaxClass, line.Subtotal)
+		}
+	}
+	sort.Strings(classes)
+
+	for _, d := range pricing.Discounts {
+		covered := make(map[string]Money)
+		coveredTotal := NewMoney(0, DefaultCurrency)
+		for _, line := range lines {
+			if line.Err == nil && d.covers(line) {
+				class := t.classOf(line.Item.TaxClass)
+				covered[class] = covered[class].Add(line.Subtotal)
+				coveredTotal = coveredTotal.Add(line.Subtotal)
+			}
+		}
+		if d.Amount.IsZero() || coveredTotal.IsZero() {
+			continue
+		}
+		var coveredClasses []string
+		for _, class := range classes {
+			if !covered[class].IsZero() {
+				coveredClasses = append(coveredClasses, class)
+			}
+		}
+		remaining := d.Amount
+		for i, class := range coveredClasses {
+			share := remaining
+			if i < len(coveredClasses)-1 {
+				share = d.Amount.MulFrac(covered[class].Minor, coveredTotal.Minor)
+			}
+			taxable[class] = taxable[class].Sub(share)
+			remaining = remaining.Sub(share)
+		}
+	}
+
+	if !pricing.DeliveryFee.IsZero() {
+		deliveryClass := t.DeliveryClass
+		if deliveryClass == "" {
+			deliveryClass = t.DefaultClass
+		}
+		addTaxable(deliveryClass, pricing.DeliveryFee)
+		sort.Strings(classes)
+	}
+
+	pricing.Taxes = nil
+	for _, class := range classes {
+		rate := t.Rates[class]
+		amount := t.TaxOn(class, taxable[class])
+		if rate.BasisPoints == 0 || amount.IsZero() {
+			continue
+		}
+		pricing.Taxes = append(pricing.Taxes, OrderTax{Class: class, Name: rate.Name, BasisPoints: rate.BasisPoints, Taxable: taxable[class], Amount: amount})
+		if !t.PricesIncludeTax {
+			pricing.Total = pricing.Total.Add(amount)
+		}
+	}
+	return pricing
+}
+
+// TaxTotal adds up the order's taxes.
+func (p OrderPricing) TaxTotal() Money {
+	total := NewMoney(0, DefaultCurrency)
+	for _, tax := range p.Taxes {
+		total = total.Add(tax.Amount)
+	}
+	return total
+}
+
+// The tax added on top of the prices, none when they include it.
+func (p OrderPricing) addedTax() Money {
+	if p.TaxIncluded {
+		return NewMoney(0, DefaultCurrency)
+	}
+	return p.TaxTotal()
+}
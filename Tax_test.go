@@ -0,0 +1,52 @@
+package menubotlib
+
+import "testing"
+
+func TestPriceTaxSpreadsDiscountsOverCoveredLines(t *testing.T) {
+	prlst := testPricelist()
+	for i := range prlst.Catalogue {
+		for j := range prlst.Catalogue[i].Items {
+			if prlst.Catalogue[i].Items[j].Selection == "Edibles" {
+				prlst.Catalogue[i].Items[j].TaxClass = "food"
+			}
+		}
+	}
+	prlst.Tax = &TaxConfig{
+		Rates:            map[string]TaxRate{"standard": {Name: "VAT", BasisPoints: 1500}, "food": {Name: "VAT", BasisPoints: 0}},
+		DefaultClass:     "standard",
+		PricesIncludeTax: true,
+	}
+
+	tests := []struct {
+		name          string
+		promotion     Promotion
+		wantStandard  int64
+		wantDiscounts int64
+	}{
+		// R5 off the brownie is zero rated and leaves the flower's VAT alone
+		{"selection promotion", Promotion{Code: "SWEET", Kind: PromotionPercentOff, Automatic: true, Percent: 10, Selection: "Edibles"}, 45000, 500},
+		// R50 off the whole order, R45 of it comes off the flower
+		{"whole order promotion", Promotion{Code: "TENOFF", Kind: PromotionPercentOff, Automatic: true, Percent: 10}, 40500, 5000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prlst.Promotions = []Promotion{tt.promotion}
+			c := CustomerOrder{OrderItems: items("1", "5", "3", "1x1")}
+			pricing := c.priceOrder(prlst)
+
+			discounts := NewMoney(0, DefaultCurrency)
+			for _, d := range pricing.Discounts {
+				discounts = discounts.Add(d.Amount)
+			}
+			if discounts.Minor != tt.wantDiscounts {
+				t.Errorf("discounts = %s, want %s", discounts, NewMoney(tt.wantDiscounts, DefaultCurrency))
+			}
+			if len(pricing.Taxes) != 1 || pricing.Taxes[0].Class != "standard" {
+				t.Fatalf("taxes = %+v, want standard VAT only", pricing.Taxes)
+			}
+			if taxable := pricing.Taxes[0].Taxable; taxable.Minor != tt.wantStandard {
+				t.Errorf("standard taxable = %s, want %s", taxable, NewMoney(tt.wantStandard, DefaultCurrency))
+			}
+		})
+	}
+}
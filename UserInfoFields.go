@@ -0,0 +1,148 @@
+package menubotlib
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrUnknownUserInfoField = errors.New("unknown user info field")
+
+// UserInfoFieldError is a value the customer sent that can't be stored, the message is meant for them.
+type UserInfoFieldError struct {
+	Field  string
+	Reason string
+}
+
+func (e *UserInfoFieldError) Error() string {
+	return fmt.Sprintf("could not update your %s: %s", e.Field, e.Reason)
+}
+
+// UserInfoField is one userinfo column the customer may update themselves.
+type UserInfoField struct {
+	Column  string
+	Label   string
+	Aliases []string
+	// Hint stands in for the value in the menu, e.g. "update email: newEmail".
+	Hint   string
+	MaxLen int
+	// Checks the trimmed input and returns the value to store.
+	normalise func(value string) (string, error)
+	set       func(ui *UserInfo, value string)
+	get       func(ui UserInfo) string
+}
+
+// The only columns that may be updated, anything else never reaches the database.
+var userInfoFields = []UserInfoField{
+	{
+		Column:    "email",
+		Label:     "email",
+		Hint:      "newEmail",
+		MaxLen:    254,
+		normalise: normaliseEmail,
+		set:       func(ui *UserInfo, value string) { ui.Email = validNullString(value) },
+		get:       func(ui UserInfo) string { return ui.Email.Value() },
+	},
+	{
+		Column: "nickname",
+		Label:  "nickname",
+		Hint:   "newNickname",
+		MaxLen: 50,
+		set:    func(ui *UserInfo, value string) { ui.NickName = validNullString(value) },
+		get:    func(ui UserInfo) string { return ui.NickName.Value() },
+	},
+	{
+		Column:  "socialmedia",
+		Label:   "social",
+		Hint:    "newSocial",
+		Aliases: []string{"social"},
+		MaxLen:  255,
+		set:     func(ui *UserInfo, value string) { ui.SocialMedia = validNullString(value) },
+		get:     func(ui UserInfo) string { return ui.SocialMedia.Value() },
+	},
+	{
+		Column:    "consent",
+		Label:     "consent",
+		Hint:      "yes/no",
+		normalise: normaliseConsent,
+		set: func(ui *UserInfo, value string) {
+			consent, _ := strconv.ParseBool(value)
+			ui.Consent = NullBool{sql.NullBool{Bool: consent, Valid: true}}
+		},
+		get: func(ui UserInfo) string { return ui.Consent.Value() },
+	},
+}
+
+func validNullString(value string) NullString {
+	return NullString{sql.NullString{String: value, Valid: true}}
+}
+
+// LookupUserInfoField finds a field by its column name or one of its aliases.
+func LookupUserInfoField(name string) (UserInfoField, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for _, field := range userInfoFields {
+		if field.Column == name {
+			return field, nil
+		}
+		for _, alias := range field.Aliases {
+			if alias == name {
+				return field, nil
+			}
+		}
+	}
+	return UserInfoField{}, fmt.Errorf("%w: %q", ErrUnknownUserInfoField, name)
+}
+
+// Parse validates what the customer sent and returns the value to store.
+func (f UserInfoField) Parse(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", &UserInfoFieldError{Field: f.Label, Reason: "the new value can't be empty"}
+	}
+	if f.MaxLen > 0 && utf8.RuneCountInString(value) > f.MaxLen {
+		return "", &UserInfoFieldError{Field: f.Label, Reason: fmt.Sprintf("it can be at most %d characters long", f.MaxLen)}
+	}
+	if f.normalise == nil {
+		return value, nil
+	}
+
+	normalised, err := f.normalise(value)
+	if err != nil {
+		return "", &UserInfoFieldError{Field: f.Label, Reason: err.Error()}
+	}
+	return normalised, nil
+}
+
+// Value returns the field as shown to the customer.
+func (f UserInfoField) Value(ui UserInfo) string {
+	return f.get(ui)
+}
+
+// Only a bare address is stored, "Name <a@b.com>" and comments are rejected.
+// RFC 5322 allows a domain without a dot but no customer has one.
+func normaliseEmail(value string) (string, error) {
+	addr, err := mail.ParseAddress(value)
+	if err != nil || addr.Name != "" || addr.Address != value ||
+		!strings.Contains(value[strings.LastIndex(value, "@"):], ".") {
+		return "", fmt.Errorf("%q is not a valid email address, it should look like example@emailprovider.com", value)
+	}
+	return addr.Address, nil
+}
+
+func normaliseConsent(value string) (string, error) {
+	switch strings.ToLower(value) {
+	case "yes", "y":
+		return "true", nil
+	case "no", "n":
+		return "false", nil
+	}
+	consent, err := strconv.ParseBool(value)
+	if err != nil {
+		return "", fmt.Errorf("please answer yes or no")
+	}
+	return strconv.FormatBool(consent), nil
+}
@@ -0,0 +1,137 @@
+package menubotlib
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLookupUserInfoField(t *testing.T) {
+	tests := []struct {
+		name       string
+		wantColumn string
+		wantErr    bool
+	}{
+		{"email", "email", false},
+		{" Email ", "email", false},
+		{"social", "socialmedia", false},
+		{"socialmedia", "socialmedia", false},
+		{"consent", "consent", false},
+		{"cellnumber", "", true},
+		{"email; drop table userinfo", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			field, err := LookupUserInfoField(tt.name)
+			if tt.wantErr {
+				if !errors.Is(err, ErrUnknownUserInfoField) {
+					t.Errorf("error = %v, want %v", err, ErrUnknownUserInfoField)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if field.Column != tt.wantColumn {
+				t.Errorf("column = %q, want %q", field.Column, tt.wantColumn)
+			}
+		})
+	}
+}
+
+func TestUserInfoFieldParse(t *testing.T) {
+	tests := []struct {
+		name       string
+		field      string
+		value      string
+		want       string
+		wantReason string
+	}{
+		{"email", "email", "someone@example.com", "someone@example.com", ""},
+		{"email is trimmed", "email", "  someone@example.com ", "someone@example.com", ""},
+		{"email with a display name", "email", "Someone <someone@example.com>", "", "is not a valid email address"},
+		{"email without a dot", "email", "someone@localhost", "", "is not a valid email address"},
+		{"email without an at", "email", "someone.example.com", "", "is not a valid email address"},
+		{"email too long", "email", strings.Repeat("a", 250) + "@b.com", "", "at most 254 characters"},
+		{"empty", "nickname", "   ", "", "can't be empty"},
+		{"nickname at the limit", "nickname", strings.Repeat("é", 50), strings.Repeat("é", 50), ""},
+		{"nickname too long", "nickname", strings.Repeat("é", 51), "", "at most 50 characters"},
+		{"social", "social", "@someone", "@someone", ""},
+		{"consent yes", "consent", "Yes", "true", ""},
+		{"consent n", "consent", "n", "false", ""},
+		{"consent true", "consent", "TRUE", "true", ""},
+		{"consent 0", "consent", "0", "false", ""},
+		{"consent maybe", "consent", "maybe", "", "please answer yes or no"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			field, err := LookupUserInfoField(tt.field)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := field.Parse(tt.value)
+			if tt.wantReason != "" {
+				var fieldErr *UserInfoFieldError
+				if !errors.As(err, &fieldErr) || !strings.Contains(fieldErr.Reason, tt.wantReason) {
+					t.Fatalf("error = %v, want a UserInfoFieldError saying %q", err, tt.wantReason)
+				}
+				if !strings.HasPrefix(err.Error(), "could not update your "+field.Label+": ") {
+					t.Errorf("message = %q, want it to name the %s", err.Error(), field.Label)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Parse(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateSingularUserInfoField(t *testing.T) {
+	store := NewMemoryStore()
+	err := store.Users.InsertUserInfo(UserInfo{CellNumber: testCellNumber})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ui := UserInfo{CellNumber: testCellNumber}
+	err = ui.UpdateSingularUserInfoField(store.Users, "consent", "yes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ui.UpdateSingularUserInfoField(store.Users, "email", "not an address")
+	var fieldErr *UserInfoFieldError
+	if !errors.As(err, &fieldErr) {
+		t.Errorf("error = %v, want a UserInfoFieldError", err)
+	}
+
+	stored, err := store.Users.GetUserInfo(testCellNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Consent.Value() != "true" || ui.Consent.Value() != "true" {
+		t.Errorf("consent = %s stored, %s in the conversation, want true", stored.Consent.Value(), ui.Consent.Value())
+	}
+	if stored.Email.Valid {
+		t.Errorf("the rejected email was stored: %q", stored.Email.String)
+	}
+
+	// The repository stores what it is given, checking is UpdateSingularUserInfoField's job
+	err = store.Users.UpdateUserInfoField(testCellNumber, "socialmedia", "  @someone ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err = store.Users.GetUserInfo(testCellNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.SocialMedia.String != "  @someone " {
+		t.Errorf("social = %q, want the value as given", stored.SocialMedia.String)
+	}
+	if err := store.Users.UpdateUserInfoField(testCellNumber, "cellnumber", "1"); !errors.Is(err, ErrUnknownUserInfoField) {
+		t.Errorf("updating the cell number = %v, want %v", err, ErrUnknownUserInfoField)
+	}
+}
@@ -0,0 +1,458 @@
+package menubotlib
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Main menu sections, a registry lists its sections in the order they were first used.
+const (
+	SectionQuery  = "query"
+	SectionUpdate = "update"
+)
+
+// CommandRequest is everything a handler gets to answer a command.
+type CommandRequest struct {
+	Store        Store
+	Convo        *ConversationContext
+	CheckoutInfo CheckoutInfo
+	IsAutoInc    bool
+	// Match is the matched text followed by the pattern's submatches, as from FindStringSubmatch.
+	Match []string
+	// Registry is the one the command was found in, e.g. to render the menu.
+	Registry *CommandRegistry
+}
+
+type CommandHandler func(req CommandRequest) CommandResult
+
+// CommandDefinition describes a command the bot understands.
+type CommandDefinition struct {
+	Name    string
+	Aliases []string
+	// Pattern finds the command in the lower-cased message, when nil the name and aliases are matched literally.
+	Pattern *regexp.Regexp
+	// Help is the command's line in the main menu, commands without one aren't listed.
+	Help    string
+	Section string
+	Handler CommandHandler
+}
+
+// CommandRegistry holds the commands GetResponseToMsg looks for, it is safe for concurrent use.
+type CommandRegistry struct {
+	mu       sync.RWMutex
+	commands []CommandDefinition
+	names    map[string]bool
+	flows    map[string]Flow
+	// Whether messages without a command are read as free text orders.
+	naturalOrders bool
+}
+
+func NewCommandRegistry() *CommandRegistry {
+	return &CommandRegistry{names: make(map[string]bool), flows: make(map[string]Flow)}
+}
+
+// DefaultCommands is the registry GetResponseToMsg uses, applications may add their own commands to it.
+var DefaultCommands = NewDefaultCommandRegistry()
+
+// RegisterCommand adds a command to DefaultCommands.
+func RegisterCommand(def CommandDefinition) error {
+	return DefaultCommands.Register(def)
+}
+
+// Register adds a command, names and aliases have to be unique within the registry.
+func (r *CommandRegistry) Register(def CommandDefinition) error {
+	if def.Name == "" {
+		return fmt.Errorf("command has no name")
+	}
+	if def.Handler == nil {
+		return fmt.Errorf("command %s has no handler", def.Name)
+	}
+
+	def.Name = strings.ToLower(def.Name)
+	names := []string{def.Name}
+	for _, alias := range def.Aliases {
+		names = append(names, strings.ToLower(alias))
+	}
+	def.Aliases = names[1:]
+	if def.Pattern == nil {
+		quoted := make([]string, len(names))
+		for i, name := range names {
+			quoted[i] = regexp.QuoteMeta(name)
+		}
+		def.Pattern = regexp.MustCompile(`\b(?:` + strings.Join(quoted, "|") + `)`)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, name := range names {
+		if r.names[name] {
+			return fmt.Errorf("command %s is already registered", name)
+		}
+	}
+	for _, name := range names {
+		r.names[name] = true
+	}
+	r.commands = append(r.commands, def)
+	return nil
+}
+
+func (r *CommandRegistry) MustRegister(def CommandDefinition) {
+	err := r.Register(def)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Lookup finds a command by name or alias.
+func (r *CommandRegistry) Lookup(name string) (CommandDefinition, bool) {
+	name = strings.ToLower(name)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, def := range r.commands {
+		if def.Name == name {
+			return def, true
+		}
+		for _, alias := range def.Aliases {
+			if alias == name {
+				return def, true
+			}
+		}
+	}
+	return CommandDefinition{}, false
+}
+
+// Commands returns the registered commands in registration order.
+func (r *CommandRegistry) Commands() []CommandDefinition {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]CommandDefinition(nil), r.commands...)
+}
+
+// RegisteredCommand is a command found in a message, bound to the handler that answers it.
+type RegisteredCommand struct {
+	Definition   CommandDefinition
+	Match        []string
+	CheckoutInfo CheckoutInfo
+	registry     *CommandRegistry
+}
+
+func (cmd RegisteredCommand) Execute(store Store, convo *ConversationContext, isAutoInc bool) CommandResult {
+	return cmd.Definition.Handler(CommandRequest{
+		Store:        store,
+		Convo:        convo,
+		CheckoutInfo: cmd.CheckoutInfo,
+		IsAutoInc:    isAutoInc,
+		Match:        cmd.Match,
+		Registry:     cmd.registry,
+	})
+}
+
+// SetNaturalLanguageOrders turns reading free text orders on or off, it is off by default. When on,
+// a message without a command that names items on the price list, "two hats and a mug please",
+// is proposed as an order update and applied once the customer confirms it.
+func (r *CommandRegistry) SetNaturalLanguageOrders(enabled bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.naturalOrders = enabled
+}
+
+// Proposes the items in a free text message, if reading them is on and the message names any.
+func (r *CommandRegistry) respondWithNaturalOrder(convo *ConversationContext, store Store, checkoutInfo CheckoutInfo, isAutoInc bool) (string, bool) {
+	r.mu.RLock()
+	enabled := r.naturalOrders
+	r.mu.RUnlock()
+	if !enabled || len(ParseNaturalOrder(convo.MessageBody, convo.Pricelist.Catalogue)) == 0 {
+		return "", false
+	}
+
+	req := CommandRequest{Store: store, Convo: convo, CheckoutInfo: checkoutInfo, IsAutoInc: isAutoInc, Registry: r}
+	result := r.StartFlow(req, confirmOrderFlowName)
+	result.logFailure("reading a free text order", convo.UserInfo.CellNumber)
+	return result.Reply, true
+}
+
+// Parse finds every registered command in the message, in the order they appear in it.
+func (r *CommandRegistry) Parse(messageBody string, checkoutInfo CheckoutInfo) []Command {
+	messageBody = strings.ToLower(messageBody)
+
+	type found struct {
+		at      int
+		command RegisteredCommand
+	}
+	var matches []found
+	for _, def := range r.Commands() {
+		for _, loc := range def.Pattern.FindAllStringSubmatchIndex(messageBody, -1) {
+			match := make([]string, len(loc)/2)
+			for i := range match {
+				if loc[2*i] >= 0 {
+					match[i] = messageBody[loc[2*i]:loc[2*i+1]]
+				}
+			}
+			matches = append(matches, found{at: loc[0], command: RegisteredCommand{Definition: def, Match: match, CheckoutInfo: checkoutInfo, registry: r}})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].at < matches[j].at })
+
+	commands := make([]Command, len(matches))
+	for i, m := range matches {
+		commands[i] = m.command
+	}
+	return commands
+}
+
+// MainMenu lists the help of every command, grouped by section.
+func (r *CommandRegistry) MainMenu() string {
+	var sections []string
+	lines := make(map[string][]string)
+	for _, def := range r.Commands() {
+		if def.Help == "" {
+			continue
+		}
+		if _, ok := lines[def.Section]; !ok {
+			sections = append(sections, def.Section)
+		}
+		lines[def.Section] = append(lines[def.Section], def.Help)
+	}
+
+	menu := "Main Menu, command list:"
+	for _, section := range sections {
+		menu += "\n\n" + strings.Join(lines[section], "\n")
+	}
+	return menu
+}
+
+// Respond runs the commands in the conversation's message and builds the reply,
+// a message without commands answers the customer's flow if they are in one.
+func (r *CommandRegistry) Respond(convo *ConversationContext, store Store, checkoutInfo CheckoutInfo, isAutoInc bool) string {
+	commandRes := unhandledCommandException
+	commands := r.Parse(convo.MessageBody, checkoutInfo)
+	if len(commands) != 0 {
+		// Process commands
+		commandRes_Temp := CommandCollection(commands).ProcessCommands(convo, store, isAutoInc)
+		if commandRes_Temp != "" && commandRes_Temp != " " && commandRes_Temp != "\n" {
+			commandRes = commandRes_Temp
+		}
+	} else if convo.Location != nil {
+		result := SharedLocationCommand{Location: *convo.Location}.Execute(store, convo, isAutoInc)
+		result.logFailure("saving a shared location", convo.UserInfo.CellNumber)
+		commandRes = result.Reply
+	} else if reply, ok := r.respondFromFlow(convo, store, checkoutInfo, isAutoInc); ok {
+		commandRes = reply
+	} else if reply, ok := r.respondWithNaturalOrder(convo, store, checkoutInfo, isAutoInc); ok {
+		commandRes = reply
+	} else {
+		commandRes = noCommandText
+	}
+
+	if !convo.UserExisted {
+		if commandRes != noCommandText {
+			commandRes = smartyPantsGreeting + "\n\n" + commandRes + "\n\n" + reminderGreeting + "\n\n" + sayMenu
+		} else {
+			commandRes = coldGreeting + "\n\n" + reminderGreeting + "\n\n" + sayMenu
+		}
+	} else if commandRes == noCommandText {
+		commandRes += "\n\n" + sayMenu
+	}
+
+	convo.UserExisted = true
+
+	return commandRes
+}
+
+// NewDefaultCommandRegistry returns a registry holding the built-in commands.
+func NewDefaultCommandRegistry() *CommandRegistry {
+	r := NewCommandRegistry()
+
+	r.MustRegister(CommandDefinition{
+		Name:    "menu?",
+		Help:    "menu? - Prints this menu.",
+		Section: SectionQuery,
+		Handler: func(req CommandRequest) CommandResult {
+			return okResult(req.Registry.MainMenu())
+		},
+	})
+	r.MustRegister(CommandDefinition{
+		Name:    "shop?",
+		Aliases: []string{"fr.prlist?"},
+		Help:    "shop? - Prints the shop price list.",
+		Section: SectionQuery,
+		Handler: func(req CommandRequest) CommandResult {
+			return okResult(prclstPreamble + "\n\n" + AssembleCatalogueSelections(req.Convo.Pricelist.PrlstPreamble, req.Convo.Pricelist.Catalogue))
+		},
+	})
+	r.MustRegister(CommandDefinition{
+		Name:    "userinfo?",
+		Help:    "userinfo? - Prints your user info.",
+		Section: SectionQuery,
+		Handler: func(req CommandRequest) CommandResult {
+			return okResult(req.Convo.UserInfo.GetUserInfoAsAString())
+		},
+	})
+
+	r.MustRegister(CommandDefinition{
+		Name:    "delivery?",
+		Help:    "delivery? - Prints where we deliver and what it costs.",
+		Section: SectionQuery,
+		Handler: func(req CommandRequest) CommandResult {
+			return okResult(req.Convo.Pricelist.Delivery.String())
+		},
+	})
+
+	r.MustRegister(CommandDefinition{
+		Name:    "order?",
+		Pattern: regexp.MustCompile(`\border\?(?:\s*(\d+))?`),
+		Help:    "order? - Order step by step, order? 12 prints your order 12.",
+		Section: SectionQuery,
+		Handler: func(req CommandRequest) CommandResult {
+			if req.Match[1] != "" {
+				orderID, _ := strconv.Atoi(req.Match[1])
+				return OrderDetailCommand{OrderID: orderID}.Execute(req.Store, req.Convo, req.IsAutoInc)
+			}
+			return req.Registry.StartFlow(req, orderFlowName)
+		},
+	})
+	r.MustRegister(CommandDefinition{
+		Name:    "orders?",
+		Help:    "orders? - Prints your recent orders.",
+		Section: SectionQuery,
+		Handler: func(req CommandRequest) CommandResult {
+			return OrderHistoryCommand{}.Execute(req.Store, req.Convo, req.IsAutoInc)
+		},
+	})
+	for _, flow := range []Flow{orderFlow(), confirmOrderFlow()} {
+		err := r.RegisterFlow(flow)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	for _, field := range userInfoFields {
+		names := append([]string{field.Column}, field.Aliases...)
+		r.MustRegister(CommandDefinition{
+			Name:    "update " + field.Label,
+			Pattern: regexp.MustCompile(`update (` + strings.Join(names, "|") + `):\s*(\S*)`),
+			Help:    "update " + field.Label + ": " + field.Hint,
+			Section: SectionUpdate,
+			Handler: func(req CommandRequest) CommandResult {
+				return UpdateUserInfoCommand{Name: req.Match[1], Text: req.Match[2]}.Execute(req.Store, req.Convo, req.IsAutoInc)
+			},
+		})
+	}
+
+	r.MustRegister(CommandDefinition{
+		Name:    applyCodeCommand,
+		Pattern: regexp.MustCompile(`apply code:?\s+(\S+)`),
+		Help:    "apply code: XYZ - Applies a discount code to your order, none removes it.",
+		Section: SectionUpdate,
+		Handler: func(req CommandRequest) CommandResult {
+			return ApplyPromoCodeCommand{Code: req.Match[1]}.Execute(req.Store, req.Convo, req.IsAutoInc)
+		},
+	})
+
+	r.MustRegister(CommandDefinition{
+		Name:    "deliver to",
+		Pattern: regexp.MustCompile(`deliver to:?\s+(.+)`),
+		Help:    deliverToCommand + " - Delivers your order, coordinates like -33.92, 18.42 work too.",
+		Section: SectionUpdate,
+		Handler: func(req CommandRequest) CommandResult {
+			return SetFulfilmentCommand{Fulfilment: FulfilmentDelivery, Location: req.Match[1]}.Execute(req.Store, req.Convo, req.IsAutoInc)
+		},
+	})
+	r.MustRegister(CommandDefinition{
+		Name:    pickupCommand,
+		Help:    pickupCommand + " - You collect your order yourself.",
+		Section: SectionUpdate,
+		Handler: func(req CommandRequest) CommandResult {
+			return SetFulfilmentCommand{Fulfilment: FulfilmentPickup}.Execute(req.Store, req.Convo, req.IsAutoInc)
+		},
+	})
+
+	r.MustRegister(CommandDefinition{
+		Name:    "addresses?",
+		Help:    "addresses? - Prints your saved addresses.",
+		Section: SectionQuery,
+		Handler: func(req CommandRequest) CommandResult {
+			if req.Store.Addresses == nil {
+				return failedResult(CommandRejected, "Sorry, addresses can't be saved right now.", ErrNoAddressRepository)
+			}
+			addresses, err := req.Store.Addresses.ListAddresses(req.Convo.UserInfo.CellNumber)
+			if err != nil {
+				return failedResult(CommandInternalError, "could not read your addresses, please try again later", err)
+			}
+			return okResult(FormatAddressBook(addresses))
+		},
+	})
+	r.MustRegister(CommandDefinition{
+		Name:    "add address",
+		Pattern: regexp.MustCompile(`add address:?\s+(.+)`),
+		Help:    addAddressCommand + " - Saves an address, sharing a location pin works too.",
+		Section: SectionUpdate,
+		Handler: func(req CommandRequest) CommandResult {
+			return AddAddressCommand{Text: req.Match[1]}.Execute(req.Store, req.Convo, req.IsAutoInc)
+		},
+	})
+	r.MustRegister(CommandDefinition{
+		Name:    "use address",
+		Pattern: regexp.MustCompile(`use address:?\s+(.+)`),
+		Help:    useAddressCommand + " - Delivers your order to a saved address and makes it your default.",
+		Section: SectionUpdate,
+		Handler: func(req CommandRequest) CommandResult {
+			return UseAddressCommand{Label: req.Match[1]}.Execute(req.Store, req.Convo, req.IsAutoInc)
+		},
+	})
+	r.MustRegister(CommandDefinition{
+		Name:    "remove address",
+		Pattern: regexp.MustCompile(`remove address:?\s+(.+)`),
+		Help:    "remove address: home - Removes a saved address.",
+		Section: SectionUpdate,
+		Handler: func(req CommandRequest) CommandResult {
+			return RemoveAddressCommand{Label: req.Match[1]}.Execute(req.Store, req.Convo, req.IsAutoInc)
+		},
+	})
+
+	r.MustRegister(CommandDefinition{
+		Name:    "reorder",
+		Pattern: regexp.MustCompile(`\breorder:?\s*(\d+)`),
+		Help:    "reorder 12 - Starts a new order with the items of your order 12.",
+		Section: SectionUpdate,
+		Handler: func(req CommandRequest) CommandResult {
+			orderID, _ := strconv.Atoi(req.Match[1])
+			return ReorderCommand{OrderID: orderID}.Execute(req.Store, req.Convo, req.IsAutoInc)
+		},
+	})
+
+	// The order commands are explained in the shop text rather than the main menu
+	r.MustRegister(CommandDefinition{
+		Name:    "update order",
+		Pattern: regexp.MustCompile(`(update order):?\s*(.*)`),
+		Handler: func(req CommandRequest) CommandResult {
+			return UpdateOrderCommand{Text: req.Match[2]}.Execute(req.Store, req.Convo, req.IsAutoInc)
+		},
+	})
+	r.MustRegister(CommandDefinition{
+		Name: "undo?",
+		Handler: func(req CommandRequest) CommandResult {
+			return UndoOrderCommand{}.Execute(req.Store, req.Convo, req.IsAutoInc)
+		},
+	})
+	r.MustRegister(CommandDefinition{
+		Name: "currentorder?",
+		Handler: func(req CommandRequest) CommandResult {
+			return okResult(req.Convo.CurrentOrder.GetCurrentOrderAsAString(req.Store.Orders, req.Convo.UserInfo.CellNumber, req.Convo.Pricelist, req.IsAutoInc))
+		},
+	})
+	r.MustRegister(CommandDefinition{
+		Name: "checkoutnow?",
+		Handler: func(req CommandRequest) CommandResult {
+			return CheckoutCommand{CheckoutInfo: req.CheckoutInfo}.Execute(req.Store, req.Convo, req.IsAutoInc)
+		},
+	})
+
+	return r
+}
@@ -0,0 +1,112 @@
+package menubotlib
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func replyWith(reply string) CommandHandler {
+	return func(req CommandRequest) CommandResult { return okResult(reply) }
+}
+
+func TestRegisterRejectsDuplicates(t *testing.T) {
+	tests := []struct {
+		name    string
+		def     CommandDefinition
+		wantErr string
+	}{
+		{"new command", CommandDefinition{Name: "hours?", Aliases: []string{"open?"}, Handler: replyWith("9 to 5")}, ""},
+		{"same name", CommandDefinition{Name: "menu?", Handler: replyWith("menu")}, "menu? is already registered"},
+		{"name in another case", CommandDefinition{Name: "MENU?", Handler: replyWith("menu")}, "menu? is already registered"},
+		{"name of an alias", CommandDefinition{Name: "fr.prlist?", Handler: replyWith("shop")}, "fr.prlist? is already registered"},
+		{"alias of a name", CommandDefinition{Name: "hours?", Aliases: []string{"shop?"}, Handler: replyWith("9 to 5")}, "shop? is already registered"},
+		{"no name", CommandDefinition{Handler: replyWith("nothing")}, "has no name"},
+		{"no handler", CommandDefinition{Name: "hours?"}, "has no handler"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewDefaultCommandRegistry()
+			before := len(r.Commands())
+			err := r.Register(tt.def)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, ok := r.Lookup("OPEN?"); !ok {
+					t.Error("the alias of the new command isn't found")
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+			if len(r.Commands()) != before {
+				t.Error("the rejected command was added")
+			}
+		})
+	}
+
+	// A rejected command doesn't hold on to the names that didn't clash
+	r := NewDefaultCommandRegistry()
+	if err := r.Register(CommandDefinition{Name: "hours?", Aliases: []string{"menu?"}, Handler: replyWith("9 to 5")}); err == nil {
+		t.Fatal("an alias clashing with menu? was registered")
+	}
+	if err := r.Register(CommandDefinition{Name: "hours?", Handler: replyWith("9 to 5")}); err != nil {
+		t.Errorf("registering hours? after the rejected one = %v", err)
+	}
+}
+
+func TestMustRegisterPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("MustRegister of a duplicate name did not panic")
+		}
+	}()
+	NewDefaultCommandRegistry().MustRegister(CommandDefinition{Name: "menu?", Handler: replyWith("menu")})
+}
+
+func TestMainMenu(t *testing.T) {
+	r := NewCommandRegistry()
+	r.MustRegister(CommandDefinition{Name: "hours?", Help: "hours? - Prints our opening hours.", Section: SectionQuery, Handler: replyWith("9 to 5")})
+	r.MustRegister(CommandDefinition{Name: "book", Help: "book 10am - Books a table.", Section: "booking", Handler: replyWith("booked")})
+	r.MustRegister(CommandDefinition{Name: "secret?", Section: SectionQuery, Handler: replyWith("not listed")})
+	r.MustRegister(CommandDefinition{Name: "where?", Help: "where? - Prints our address.", Section: SectionQuery, Handler: replyWith("here")})
+
+	want := "Main Menu, command list:" +
+		"\n\nhours? - Prints our opening hours.\nwhere? - Prints our address." +
+		"\n\nbook 10am - Books a table."
+	if got := r.MainMenu(); got != want {
+		t.Errorf("MainMenu() =\n%s\nwant\n%s", got, want)
+	}
+
+	// Every built-in command with help is on the default menu
+	menu := NewDefaultCommandRegistry().MainMenu()
+	for _, def := range NewDefaultCommandRegistry().Commands() {
+		if def.Help != "" && !strings.Contains(menu, def.Help) {
+			t.Errorf("the menu leaves out %q", def.Help)
+		}
+	}
+}
+
+func TestParseOrdersByPosition(t *testing.T) {
+	r := NewCommandRegistry()
+	r.MustRegister(CommandDefinition{Name: "menu?", Handler: replyWith("menu")})
+	r.MustRegister(CommandDefinition{Name: "hours?", Aliases: []string{"open?"}, Handler: replyWith("hours")})
+	r.MustRegister(CommandDefinition{Name: "book", Pattern: regexp.MustCompile(`\bbook (\d+)(am|pm)`), Handler: replyWith("book")})
+
+	commands := r.Parse("Open? I'd like to BOOK 10am, then menu? and book 2pm", CheckoutInfo{})
+	var got []string
+	for _, command := range commands {
+		registered := command.(RegisteredCommand)
+		got = append(got, registered.Definition.Name+" "+strings.Join(registered.Match[1:], ""))
+	}
+	want := []string{"hours? ", "book 10am", "menu? ", "book 2pm"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("parsed %q, want %q", got, want)
+	}
+
+	if commands := r.Parse("nothing to do here", CheckoutInfo{}); len(commands) != 0 {
+		t.Errorf("parsed %d commands from a message without any", len(commands))
+	}
+}
@@ -0,0 +1,60 @@
+package menubotlib
+
+import "log"
+
+// CommandCode says how a command went, for callers that branch or log on it rather than on the reply text.
+type CommandCode string
+
+const (
+	CommandOK CommandCode = "ok"
+	// The customer sent something that couldn't be understood or fails validation.
+	CommandInvalidInput CommandCode = "invalid_input"
+	// The command is understood but not allowed right now, e.g. changing a paid order.
+	CommandRejected CommandCode = "rejected"
+	// Something went wrong on our side, the customer can try again later.
+	CommandInternalError CommandCode = "internal_error"
+)
+
+// SideEffect names state a command changed.
+type SideEffect string
+
+const (
+	SideEffectUserInfoUpdated SideEffect = "user_info_updated"
+	SideEffectOrderUpdated    SideEffect = "order_updated"
+	SideEffectCheckoutStarted SideEffect = "checkout_started"
+)
+
+type CommandResult struct {
+	// Reply is the text for the customer, failures have one too.
+	Reply       string
+	Success     bool
+	Code        CommandCode
+	SideEffects []SideEffect
+	// Err is the underlying error of a failure, it is for logs and never shown to the customer.
+	Err error
+}
+
+func okResult(reply string, sideEffects ...SideEffect) CommandResult {
+	return CommandResult{Reply: reply, Success: true, Code: CommandOK, SideEffects: sideEffects}
+}
+
+func failedResult(code CommandCode, reply string, err error) CommandResult {
+	return CommandResult{Reply: reply, Code: code, Err: err}
+}
+
+func (r CommandResult) HasSideEffect(effect SideEffect) bool {
+	for _, e := range r.SideEffects {
+		if e == effect {
+			return true
+		}
+	}
+	return false
+}
+
+// Logs failures worth looking into, customers mistyping something isn't one of them.
+func (r CommandResult) logFailure(what, cellNumber string) {
+	if r.Success || r.Code == CommandInvalidInput {
+		return
+	}
+	log.Printf("%s failed for %s (%s): %v", what, cellNumber, r.Code, r.Err)
+}
@@ -0,0 +1,94 @@
+package menubotlib
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// How long Run waits after a failed Receive, doubling with every failure in a row up to the maximum.
+const (
+	minReceiveBackoff = 100 * time.Millisecond
+	maxReceiveBackoff = 30 * time.Second
+)
+
+// Dispatcher reads messages off a Transport, runs them through the conversation
+// logic and sends the response back to the sender.
+type Dispatcher struct {
+	Store        Store
+	Transport    Transport
+	Pricelist    Pricelist
+	CheckoutInfo CheckoutInfo
+	IsAutoInc    bool
+	// Commands defaults to DefaultCommands.
+	Commands *CommandRegistry
+	// OnStatus is optional and called for delivery receipts, which are never answered.
+	OnStatus func(status DeliveryStatus)
+}
+
+func NewDispatcher(store Store, transport Transport, prlst Pricelist, checkoutInfo CheckoutInfo, isAutoInc bool) *Dispatcher {
+	return &Dispatcher{
+		Store:        store,
+		Transport:    transport,
+		Pricelist:    prlst,
+		CheckoutInfo: checkoutInfo,
+		IsAutoInc:    isAutoInc,
+	}
+}
+
+// Run handles messages one at a time until ctx is done or the transport is closed.
+// A failure on a single message is logged and does not stop the loop, a failing transport
+// is retried with a growing delay.
+func (d *Dispatcher) Run(ctx context.Context) error {
+	backoff := minReceiveBackoff
+	for {
+		msg, err := d.Transport.Receive(ctx)
+		if err != nil {
+			if errors.Is(err, ErrTransportClosed) {
+				return nil
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Printf("error receiving message, retrying in %s: %v", backoff, err)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, maxReceiveBackoff)
+			continue
+		}
+		backoff = minReceiveBackoff
+
+		err = d.Handle(ctx, msg)
+		if err != nil {
+			log.Printf("error handling message %s from %s: %v", msg.MessageID, msg.Sender, err)
+		}
+	}
+}
+
+// Handle answers a single inbound message.
+func (d *Dispatcher) Handle(ctx context.Context, msg InboundMessage) error {
+	if msg.Status != nil {
+		if d.OnStatus != nil {
+			d.OnStatus(*msg.Status)
+		}
+		return nil
+	}
+
+	convo := NewConversationContext(d.Store, msg.Sender, msg.Body(), d.Pricelist, d.IsAutoInc)
+	convo.Location = msg.Location
+	commands := d.Commands
+	if commands == nil {
+		commands = DefaultCommands
+	}
+	reply := commands.Respond(convo, d.Store, d.CheckoutInfo, d.IsAutoInc)
+
+	return d.Transport.Send(ctx, OutboundMessage{
+		Recipient: msg.Sender,
+		Text:      reply,
+		ReplyTo:   msg.MessageID,
+	})
+}
@@ -0,0 +1,38 @@
+package menubotlib
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// A transport whose Receive always fails, the way one with a broken connection does.
+type failingTransport struct {
+	receives int
+}
+
+func (t *failingTransport) Receive(ctx context.Context) (InboundMessage, error) {
+	t.receives++
+	return InboundMessage{}, errors.New("connection refused")
+}
+
+func (t *failingTransport) Send(ctx context.Context, msg OutboundMessage) error {
+	return nil
+}
+
+func TestDispatcherRunBacksOff(t *testing.T) {
+	tr := &failingTransport{}
+	d := NewDispatcher(NewMemoryStore(), tr, Pricelist{}, CheckoutInfo{}, false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 350*time.Millisecond)
+	defer cancel()
+	err := d.Run(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Run = %v, want %v", err, context.DeadlineExceeded)
+	}
+	// Waiting 100ms, 200ms, 400ms leaves room for three receives before the deadline.
+	if tr.receives > 3 {
+		t.Errorf("Receive was called %d times, want at most 3", tr.receives)
+	}
+}
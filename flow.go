@@ -0,0 +1,180 @@
+package menubotlib
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// How long a flow waits for the customer's next answer unless it sets its own Timeout.
+const DefaultFlowTimeout = 15 * time.Minute
+
+const cancelFlowWord = "cancel"
+
+var ErrNoSessionRepository = errors.New("no session repository configured")
+
+// Session is where a customer is in a multi-step flow, there is at most one per customer.
+type Session struct {
+	CellNumber string
+	Flow       string
+	// Step names the FlowStep that takes the next answer, an empty step ends the flow.
+	Step      string
+	Data      map[string]string
+	ExpiresAt time.Time
+	UpdatedAt time.Time
+}
+
+func (s *Session) End() {
+	s.Step = ""
+}
+
+func (s Session) Expired(now time.Time) bool {
+	return !now.Before(s.ExpiresAt)
+}
+
+// FlowStep handles the customer's answer to the question asked before it. It asks the next question
+// in its reply and moves session.Step on, leaving the step as is asks again.
+type FlowStep func(req CommandRequest, session *Session, input string) CommandResult
+
+// Flow is a conversation of several questions, started by a command and answered one message at a time.
+type Flow struct {
+	Name string
+	// Label names the flow to the customer, e.g. when it is cancelled.
+	Label   string
+	Timeout time.Duration
+	// Start asks the first question and sets session.Step to the step that takes the answer.
+	Start func(req CommandRequest, session *Session) CommandResult
+	Steps map[string]FlowStep
+}
+
+func (f Flow) timeout() time.Duration {
+	if f.Timeout > 0 {
+		return f.Timeout
+	}
+	return DefaultFlowTimeout
+}
+
+func (f Flow) label() string {
+	if f.Label != "" {
+		return f.Label
+	}
+	return f.Name
+}
+
+// RegisterFlow makes a flow startable with StartFlow, the flow's name has to be unique within the registry.
+func (r *CommandRegistry) RegisterFlow(flow Flow) error {
+	if flow.Name == "" || flow.Start == nil {
+		return fmt.Errorf("flow %q needs a name and a start", flow.Name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.flows[flow.Name]; ok {
+		return fmt.Errorf("flow %s is already registered", flow.Name)
+	}
+	r.flows[flow.Name] = flow
+	return nil
+}
+
+func (r *CommandRegistry) flow(name string) (Flow, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	flow, ok := r.flows[name]
+	return flow, ok
+}
+
+// StartFlow begins the named flow for the customer, replacing any flow they were in.
+func (r *CommandRegistry) StartFlow(req CommandRequest, name string) CommandResult {
+	flow, ok := r.flow(name)
+	if !ok {
+		return failedResult(CommandInternalError, unhandledCommandException, fmt.Errorf("flow %s is not registered", name))
+	}
+	if req.Store.Sessions == nil {
+		return failedResult(CommandInternalError, unhandledCommandException, ErrNoSessionRepository)
+	}
+
+	session := Session{CellNumber: req.Convo.UserInfo.CellNumber, Flow: flow.Name, Data: make(map[string]string)}
+	result := flow.Start(req, &session)
+	return r.saveSession(req.Store, flow, session, result)
+}
+
+// Runs the answer through the step the session is waiting on.
+func (r *CommandRegistry) continueFlow(req CommandRequest, session Session, input string) CommandResult {
+	flow, ok := r.flow(session.Flow)
+	if !ok {
+		// The flow was removed since the session started
+		req.Store.Sessions.DeleteSession(session.CellNumber)
+		return failedResult(CommandInternalError, unhandledCommandException, fmt.Errorf("flow %s is not registered", session.Flow))
+	}
+
+	input = strings.TrimSpace(input)
+	if strings.EqualFold(input, cancelFlowWord) {
+		err := req.Store.Sessions.DeleteSession(session.CellNumber)
+		if err != nil {
+			return failedResult(CommandInternalError, unhandledCommandException, err)
+		}
+		return okResult(fmt.Sprintf("Okay, %s cancelled.", flow.label()))
+	}
+
+	step, ok := flow.Steps[session.Step]
+	if !ok {
+		req.Store.Sessions.DeleteSession(session.CellNumber)
+		return failedResult(CommandInternalError, unhandledCommandException, fmt.Errorf("flow %s has no step %s", flow.Name, session.Step))
+	}
+	if session.Data == nil {
+		session.Data = make(map[string]string)
+	}
+
+	result := step(req, &session, input)
+	return r.saveSession(req.Store, flow, session, result)
+}
+
+// An ended session is deleted, otherwise it waits for the next answer for another timeout.
+func (r *CommandRegistry) saveSession(store Store, flow Flow, session Session, result CommandResult) CommandResult {
+	var err error
+	if session.Step == "" {
+		err = store.Sessions.DeleteSession(session.CellNumber)
+	} else {
+		now := time.Now()
+		session.UpdatedAt = now
+		session.ExpiresAt = now.Add(flow.timeout())
+		err = store.Sessions.SaveSession(session)
+	}
+	if err != nil {
+		log.Printf("error saving the %s session for %s: %v", flow.Name, session.CellNumber, err)
+		return failedResult(CommandInternalError, unhandledCommandException, err)
+	}
+	return result
+}
+
+// Answers the message from the customer's flow, if they are in one. Messages holding a command
+// are left to the command and the flow keeps waiting.
+func (r *CommandRegistry) respondFromFlow(convo *ConversationContext, store Store, checkoutInfo CheckoutInfo, isAutoInc bool) (string, bool) {
+	if store.Sessions == nil {
+		return "", false
+	}
+	session, err := store.Sessions.GetSession(convo.UserInfo.CellNumber)
+	if err != nil {
+		if !errors.Is(err, ErrNoRows) {
+			log.Printf("error reading the session for %s: %v", convo.UserInfo.CellNumber, err)
+		}
+		return "", false
+	}
+
+	if session.Expired(time.Now()) {
+		store.Sessions.DeleteSession(session.CellNumber)
+		label := session.Flow
+		if flow, ok := r.flow(session.Flow); ok {
+			label = flow.label()
+		}
+		return fmt.Sprintf("Sorry, your %s timed out, please start again.\n\n%s", label, sayMenu), true
+	}
+
+	req := CommandRequest{Store: store, Convo: convo, CheckoutInfo: checkoutInfo, IsAutoInc: isAutoInc, Registry: r}
+	result := r.continueFlow(req, session, convo.MessageBody)
+	result.logFailure("flow "+session.Flow, session.CellNumber)
+	return result.Reply, true
+}
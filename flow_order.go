@@ -0,0 +1,317 @@
+package menubotlib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	orderFlowName = "order"
+
+	orderStepItem     = "item"
+	orderStepOption   = "option"
+	orderStepQuantity = "quantity"
+
+	orderFlowDone = "done"
+
+	confirmOrderFlowName = "confirmorder"
+	confirmOrderStep     = "confirm"
+)
+
+// The "order?" flow builds up the current order one item at a time: item, option, then how many.
+func orderFlow() Flow {
+	return Flow{
+		Name:  orderFlowName,
+		Label: "order",
+		Start: startOrderFlow,
+		Steps: map[string]FlowStep{
+			orderStepItem:     orderFlowItem,
+			orderStepOption:   orderFlowOption,
+			orderStepQuantity: orderFlowQuantity,
+		},
+	}
+}
+
+func startOrderFlow(req CommandRequest, session *Session) CommandResult {
+	if req.Convo.CurrentOrder.Status != "" && !req.Convo.CurrentOrder.Status.IsEditable() {
+		session.End()
+		return failedResult(CommandRejected, fmt.Sprintf("Your order is already %s.", req.Convo.CurrentOrder.Status.Label()), ErrOrderNotEditable)
+	}
+
+	var items []string
+	for _, selection := range req.Convo.Pricelist.Catalogue {
+		for _, item := range selection.Items {
+			items = append(items, fmt.Sprintf("%d: %s", item.CatalogueItemID, item.Item))
+		}
+	}
+	if len(items) == 0 {
+		session.End()
+		return failedResult(CommandRejected, "Sorry, there is nothing in the shop right now.", nil)
+	}
+
+	session.Step = orderStepItem
+	return okResult("Which item would you like? Reply with its price list number or name, or cancel to stop.\n\n" + strings.Join(items, "\n"))
+}
+
+func orderFlowItem(req CommandRequest, session *Session, input string) CommandResult {
+	if strings.EqualFold(input, orderFlowDone) {
+		session.End()
+		return okResult("Your order is saved.\ncurrentorder? - Prints your current pending order.\nTo checkout type & send-: checkoutnow?")
+	}
+
+	item, err := orderFlowFindItem(req, input)
+	if err != nil {
+		var ambiguous *AmbiguousItemError
+		if errors.As(err, &ambiguous) {
+			return failedResult(CommandInvalidInput, ambiguous.Prompt(), err)
+		}
+		return failedResult(CommandInvalidInput, fmt.Sprintf("There is no item %q on the price list, please reply with a number or name from the list.", input), err)
+	}
+
+	session.Data["item"] = strconv.Itoa(item.CatalogueItemID)
+	delete(session.Data, "option")
+
+	if item.PricingType == SingleItem && len(item.Options) > 1 {
+		session.Step = orderStepOption
+		var options []string
+		for i, option := range item.Options {
+			options = append(options, fmt.Sprintf("%d. %s", i+1, option))
+		}
+		return okResult(fmt.Sprintf("Which option for %s?\n\n%s", item.Item, strings.Join(options, "\n")))
+	}
+
+	session.Data["option"] = "1"
+	session.Step = orderStepQuantity
+	return okResult(quantityQuestion(item, item.Options[0]))
+}
+
+func orderFlowOption(req CommandRequest, session *Session, input string) CommandResult {
+	item, err := sessionItem(req, session)
+	if err != nil {
+		session.End()
+		return failedResult(CommandInternalError, unhandledCommandException, err)
+	}
+
+	optionNum, err := strconv.Atoi(input)
+	if err != nil || optionNum < 1 || optionNum > len(item.Options) {
+		return failedResult(CommandInvalidInput, fmt.Sprintf("Please reply with an option number from 1 to %d.", len(item.Options)), err)
+	}
+
+	session.Data["option"] = strconv.Itoa(optionNum)
+	session.Step = orderStepQuantity
+	return okResult(quantityQuestion(item, item.Options[optionNum-1]))
+}
+
+func orderFlowQuantity(req CommandRequest, session *Session, input string) CommandResult {
+	item, err := sessionItem(req, session)
+	if err != nil {
+		session.End()
+		return failedResult(CommandInternalError, unhandledCommandException, err)
+	}
+	optionNum, err := strconv.Atoi(session.Data["option"])
+	if err != nil || optionNum < 1 || optionNum > len(item.Options) {
+		session.End()
+		return failedResult(CommandInternalError, unhandledCommandException, fmt.Errorf("order flow has no valid option: %q", session.Data["option"]))
+	}
+
+	quantity, err := strconv.Atoi(input)
+	if err != nil || quantity < 1 {
+		return failedResult(CommandInvalidInput, "Please reply with a whole number, like 2.", err)
+	}
+
+	order := &req.Convo.CurrentOrder
+	update := MenuIndication{ItemMenuNum: item.CatalogueItemID, ItemAmount: strconv.Itoa(quantity)}
+	added := fmt.Sprintf("%d%s %s", quantity, item.Options[0].Unit, item.Item)
+	if item.PricingType == SingleItem {
+		update.ItemAmount = setOptionAmount(currentItemAmount(*order, item.CatalogueItemID), optionNum, quantity)
+		added = fmt.Sprintf("%d x %s (%s)", quantity, item.Item, item.Options[optionNum-1].Label)
+	}
+
+	// Below the smallest weight the line couldn't be priced, ask again rather than save it
+	err = ValidateOrderItems(OrderItems{MenuIndications: []MenuIndication{update}}, req.Convo.Pricelist.Catalogue)
+	if err != nil {
+		var invalid *OrderValidationError
+		if errors.As(err, &invalid) {
+			return failedResult(CommandInvalidInput, invalid.Feedback()+"\n\nPlease reply with another quantity.", err)
+		}
+		session.End()
+		return failedResult(CommandInternalError, unhandledCommandException, err)
+	}
+
+	err = order.UpdateOrInsertCurrentOrder(req.Store.Orders, req.Convo.UserInfo.CellNumber, OrderItems{MenuIndications: []MenuIndication{update}}, req.Convo.Pricelist, req.IsAutoInc)
+	if err != nil {
+		session.End()
+		if errors.Is(err, ErrOrderNotEditable) {
+			return failedResult(CommandRejected, fmt.Sprintf("Your order is already %s.", order.Status.Label()), err)
+		}
+		return failedResult(CommandInternalError, unhandledCommandException, err)
+	}
+
+	session.Step = orderStepItem
+	return okResult(fmt.Sprintf("Your order now has %s.\n\nReply with another item number to add more, or %s to finish.", added, orderFlowDone), SideEffectOrderUpdated)
+}
+
+// The customer may answer with the price list number or the item's name.
+func orderFlowFindItem(req CommandRequest, input string) (CatalogueItem, error) {
+	var item CatalogueItem
+	itemNum, err := strconv.Atoi(input)
+	if err == nil {
+		item, err = findItemInSelections(itemNum, req.Convo.Pricelist.Catalogue)
+	} else {
+		item, err = MatchCatalogueItem(input, req.Convo.Pricelist.Catalogue)
+	}
+	if err != nil {
+		return CatalogueItem{}, err
+	}
+	if len(item.Options) == 0 {
+		return CatalogueItem{}, fmt.Errorf("item %d has no options", item.CatalogueItemID)
+	}
+	return item, nil
+}
+
+func sessionItem(req CommandRequest, session *Session) (CatalogueItem, error) {
+	itemNum, err := strconv.Atoi(session.Data["item"])
+	if err != nil {
+		return CatalogueItem{}, fmt.Errorf("order flow has no valid item: %q", session.Data["item"])
+	}
+	// The price list may have changed since the item was picked
+	return findItemInSelections(itemNum, req.Convo.Pricelist.Catalogue)
+}
+
+func quantityQuestion(item CatalogueItem, option CatalogueOption) string {
+	if item.PricingType == WeightItem && option.Unit != "" {
+		return fmt.Sprintf("How many %s of %s would you like?", option.Unit, item.Item)
+	}
+	if item.PricingType == SingleItem && len(item.Options) > 1 {
+		return fmt.Sprintf("How many %s (%s) would you like?", item.Item, option.Label)
+	}
+	return fmt.Sprintf("How many %s would you like?", item.Item)
+}
+
+func currentItemAmount(order CustomerOrder, itemMenuNum int) string {
+	for _, indication := range order.OrderItems.MenuIndications {
+		if indication.ItemMenuNum == itemMenuNum {
+			return indication.ItemAmount
+		}
+	}
+	return ""
+}
+
+// Sets one option's quantity in an "optionxquantity, ..." amount and keeps the others,
+// so ordering a second option of an item doesn't drop the first.
+func setOptionAmount(itemAmount string, optionNum, quantity int) string {
+	var parts []string
+	replaced := false
+	for _, part := range strings.Split(itemAmount, ",") {
+		part = strings.TrimSpace(part)
+		var option, amount int
+		_, err := fmt.Sscanf(part, "%dx%d", &option, &amount)
+		if err != nil {
+			continue
+		}
+		if option == optionNum {
+			part = fmt.Sprintf("%dx%d", optionNum, quantity)
+			replaced = true
+		}
+		parts = append(parts, part)
+	}
+	if !replaced {
+		parts = append(parts, fmt.Sprintf("%dx%d", optionNum, quantity))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// The quantity of one option in an "optionxquantity, ..." amount, 0 when it has none.
+func optionQuantity(itemAmount string, optionNum int) int {
+	for _, part := range strings.Split(itemAmount, ",") {
+		var option, amount int
+		_, err := fmt.Sscanf(strings.TrimSpace(part), "%dx%d", &option, &amount)
+		if err == nil && option == optionNum {
+			return amount
+		}
+	}
+	return 0
+}
+
+// The "confirmorder" flow proposes the items read from a free text message and only changes
+// the order once the customer says yes.
+func confirmOrderFlow() Flow {
+	return Flow{
+		Name:  confirmOrderFlowName,
+		Label: "order",
+		Start: startConfirmOrderFlow,
+		Steps: map[string]FlowStep{
+			confirmOrderStep: confirmOrderAnswer,
+		},
+	}
+}
+
+func startConfirmOrderFlow(req CommandRequest, session *Session) CommandResult {
+	order := req.Convo.CurrentOrder
+	if order.Status != "" && !order.Status.IsEditable() {
+		session.End()
+		return failedResult(CommandRejected, fmt.Sprintf("Your order is already %s.", order.Status.Label()), ErrOrderNotEditable)
+	}
+
+	lines := ParseNaturalOrder(req.Convo.MessageBody, req.Convo.Pricelist.Catalogue)
+	if len(lines) == 0 {
+		session.End()
+		return failedResult(CommandInvalidInput, noCommandText, nil)
+	}
+
+	update, err := json.Marshal(NaturalOrderUpdate(lines, order))
+	if err != nil {
+		session.End()
+		return failedResult(CommandInternalError, unhandledCommandException, err)
+	}
+	session.Data["update"] = string(update)
+	session.Step = confirmOrderStep
+
+	var proposed []string
+	for _, line := range lines {
+		proposed = append(proposed, line.String())
+	}
+	return okResult("Would you like this on your order?\n\n" + strings.Join(proposed, "\n") + "\n\nReply yes to save it, or no to leave your order as it is.")
+}
+
+func confirmOrderAnswer(req CommandRequest, session *Session, input string) CommandResult {
+	switch strings.ToLower(input) {
+	case "yes", "y", "yeah", "yep", "ok", "okay":
+	case "no", "n", "nope":
+		session.End()
+		return okResult("Okay, your order is unchanged.")
+	default:
+		return failedResult(CommandInvalidInput, "Please reply yes to save it, or no to leave your order as it is.", nil)
+	}
+
+	session.End()
+	var update OrderItems
+	err := json.Unmarshal([]byte(session.Data["update"]), &update)
+	if err != nil {
+		return failedResult(CommandInternalError, unhandledCommandException, err)
+	}
+
+	// The price list may have changed since the update was proposed
+	err = ValidateOrderItems(update, req.Convo.Pricelist.Catalogue)
+	if err != nil {
+		var invalid *OrderValidationError
+		if errors.As(err, &invalid) {
+			return failedResult(CommandInvalidInput, invalid.Feedback()+"\n\nYour order was not changed.", err)
+		}
+		return failedResult(CommandInternalError, unhandledCommandException, err)
+	}
+
+	order := &req.Convo.CurrentOrder
+	before, err := order.ApplyOrderUpdate(req.Store.Orders, req.Convo.UserInfo.CellNumber, update, req.Convo.Pricelist, req.IsAutoInc)
+	if err != nil {
+		if errors.Is(err, ErrOrderNotEditable) {
+			return failedResult(CommandRejected, fmt.Sprintf("Your order is already %s.", order.Status.Label()), err)
+		}
+		return failedResult(CommandInternalError, unhandledCommandException, err)
+	}
+	diff := order.diffFrom(before, req.Convo.Pricelist.Catalogue)
+	return okResult("successfully updated current order\n"+diff.String()+"\n\n"+undoOrder+"\ncurrentorder? - Prints your current pending order.\nTo checkout type & send-: checkoutnow?", SideEffectOrderUpdated)
+}
@@ -0,0 +1,80 @@
+package menubotlib
+
+import (
+	"errors"
+	"testing"
+)
+
+// The test price list with a weight item that is only sold from 5g.
+func bulkPricelist() Pricelist {
+	prlst := testPricelist()
+	prlst.Catalogue = append(prlst.Catalogue, CmpsCtlgSlctnsFromCtlgItms([]CatalogueItem{
+		{CatalogueID: "c1", CatalogueItemID: 4, Selection: "Bulk", Item: "Bulk Haze", PricingType: WeightItem, Options: []CatalogueOption{
+			{Label: "5g", Unit: "g", Threshold: 5, Price: NewMoney(8000, DefaultCurrency)},
+		}},
+	})...)
+	return prlst
+}
+
+func TestOrderFlowQuantity(t *testing.T) {
+	tests := []struct {
+		name     string
+		quantity string
+		wantCode CommandCode
+		wantStep string
+		wantCart OrderItems
+	}{
+		{"below the smallest weight", "2", CommandInvalidInput, orderStepQuantity, OrderItems{}},
+		{"smallest weight", "5", CommandOK, orderStepItem, items("4", "5")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			session := &Session{Step: orderStepQuantity, Data: map[string]string{"item": "4", "option": "1"}}
+			result := orderFlowQuantity(flowRequest(store, bulkPricelist()), session, tt.quantity)
+			if result.Code != tt.wantCode {
+				t.Errorf("code = %v, want %v: %s", result.Code, tt.wantCode, result.Reply)
+			}
+			if session.Step != tt.wantStep {
+				t.Errorf("step = %q, want %q", session.Step, tt.wantStep)
+			}
+			order, err := store.Orders.GetCurrentOrder(testCellNumber)
+			if err != nil && !errors.Is(err, ErrNoRows) {
+				t.Fatal(err)
+			}
+			if !sameItems(order.OrderItems, tt.wantCart) {
+				t.Errorf("cart = %v, want %v", order.OrderItems.MenuIndications, tt.wantCart.MenuIndications)
+			}
+		})
+	}
+}
+
+func TestConfirmOrderAnswer(t *testing.T) {
+	tests := []struct {
+		name     string
+		update   string
+		wantCode CommandCode
+		wantCart OrderItems
+	}{
+		{"valid update", `{"MenuIndications":[{"ItemMenuNum":3,"ItemAmount":"1x2"}]}`, CommandOK, items("3", "1x2")},
+		{"item no longer listed", `{"MenuIndications":[{"ItemMenuNum":9,"ItemAmount":"1x2"}]}`, CommandInvalidInput, OrderItems{}},
+		{"below the smallest weight", `{"MenuIndications":[{"ItemMenuNum":4,"ItemAmount":"2"}]}`, CommandInvalidInput, OrderItems{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			session := &Session{Step: confirmOrderStep, Data: map[string]string{"update": tt.update}}
+			result := confirmOrderAnswer(flowRequest(store, bulkPricelist()), session, "yes")
+			if result.Code != tt.wantCode {
+				t.Errorf("code = %v, want %v: %s", result.Code, tt.wantCode, result.Reply)
+			}
+			order, err := store.Orders.GetCurrentOrder(testCellNumber)
+			if err != nil && !errors.Is(err, ErrNoRows) {
+				t.Fatal(err)
+			}
+			if !sameItems(order.OrderItems, tt.wantCart) {
+				t.Errorf("cart = %v, want %v", order.OrderItems.MenuIndications, tt.wantCart.MenuIndications)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package menubotlib
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A registry with a two question flow, asking for a name and then a colour.
+func testFlowRegistry(t *testing.T) *CommandRegistry {
+	t.Helper()
+	r := NewCommandRegistry()
+	err := r.RegisterFlow(Flow{
+		Name:    "survey",
+		Label:   "survey",
+		Timeout: time.Minute,
+		Start: func(req CommandRequest, session *Session) CommandResult {
+			session.Step = "name"
+			return okResult("What is your name?")
+		},
+		Steps: map[string]FlowStep{
+			"name": func(req CommandRequest, session *Session, input string) CommandResult {
+				session.Data["name"] = input
+				session.Step = "colour"
+				return okResult("What is your favourite colour?")
+			},
+			"colour": func(req CommandRequest, session *Session, input string) CommandResult {
+				session.End()
+				return okResult(session.Data["name"] + " likes " + input)
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// A request from the test customer for a flow step.
+func flowRequest(store Store, prlst Pricelist) CommandRequest {
+	convo := &ConversationContext{UserInfo: UserInfo{CellNumber: testCellNumber}, Pricelist: prlst}
+	return CommandRequest{Store: store, Convo: convo, IsAutoInc: true}
+}
+
+// Sends the message to the customer's flow, ok is false when they aren't in one.
+func answerFlow(r *CommandRegistry, store Store, message string) (string, bool) {
+	convo := &ConversationContext{UserInfo: UserInfo{CellNumber: testCellNumber}, MessageBody: message}
+	return r.respondFromFlow(convo, store, CheckoutInfo{}, true)
+}
+
+func expireSession(t *testing.T, store Store) {
+	t.Helper()
+	session, err := store.Sessions.GetSession(testCellNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.ExpiresAt = time.Now().Add(-time.Second)
+	err = store.Sessions.SaveSession(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFlowSessionExpiry(t *testing.T) {
+	r := testFlowRegistry(t)
+	store := NewMemoryStore()
+	req := flowRequest(store, testPricelist())
+
+	start := time.Now()
+	if result := r.StartFlow(req, "survey"); result.Reply != "What is your name?" {
+		t.Fatalf("start = %q", result.Reply)
+	}
+	session, err := store.Sessions.GetSession(testCellNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.ExpiresAt.Before(start.Add(time.Minute)) || session.Expired(start) {
+		t.Errorf("session expires at %v, want a minute after it started at %v", session.ExpiresAt, start)
+	}
+	if !session.Expired(session.ExpiresAt) {
+		t.Error("a session is still live at its expiry time")
+	}
+
+	if reply, ok := answerFlow(r, store, "Sam"); !ok || reply != "What is your favourite colour?" {
+		t.Fatalf("answer = %q, %v", reply, ok)
+	}
+
+	expireSession(t, store)
+	reply, ok := answerFlow(r, store, "blue")
+	if !ok || !strings.HasPrefix(reply, "Sorry, your survey timed out, please start again.") {
+		t.Errorf("answer after expiry = %q, %v, want the timeout message", reply, ok)
+	}
+	if _, err := store.Sessions.GetSession(testCellNumber); !errors.Is(err, ErrNoRows) {
+		t.Errorf("the expired session was kept: %v", err)
+	}
+	if reply, ok := answerFlow(r, store, "blue"); ok {
+		t.Errorf("a message after the timeout went to the flow: %q", reply)
+	}
+
+	// Starting again asks from the first question and doesn't remember the old answers
+	if result := r.StartFlow(req, "survey"); result.Reply != "What is your name?" {
+		t.Fatalf("restart = %q", result.Reply)
+	}
+	answerFlow(r, store, "Alex")
+	if reply, _ := answerFlow(r, store, "green"); reply != "Alex likes green" {
+		t.Errorf("restarted flow ended with %q, want Alex likes green", reply)
+	}
+	if _, err := store.Sessions.GetSession(testCellNumber); !errors.Is(err, ErrNoRows) {
+		t.Errorf("the finished session was kept: %v", err)
+	}
+}
+
+func TestFlowAnswerExtendsSession(t *testing.T) {
+	r := testFlowRegistry(t)
+	store := NewMemoryStore()
+	r.StartFlow(flowRequest(store, testPricelist()), "survey")
+
+	// An answer just before the timeout gives the customer another full timeout
+	session, err := store.Sessions.GetSession(testCellNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.ExpiresAt = time.Now().Add(time.Second)
+	store.Sessions.SaveSession(session)
+
+	answerFlow(r, store, "Sam")
+	session, err = store.Sessions.GetSession(testCellNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Until(session.ExpiresAt) < 50*time.Second {
+		t.Errorf("session expires in %v after an answer, want about a minute", time.Until(session.ExpiresAt))
+	}
+}
+
+func TestDeleteExpiredSessions(t *testing.T) {
+	sessions := NewMemorySessionRepository()
+	now := time.Now()
+	for cellNumber, expiresAt := range map[string]time.Time{
+		"27000000001": now.Add(-time.Hour),
+		"27000000002": now.Add(-time.Second),
+		"27000000003": now.Add(time.Minute),
+	} {
+		err := sessions.SaveSession(Session{CellNumber: cellNumber, Flow: "survey", Step: "name", ExpiresAt: expiresAt})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deleted, err := sessions.DeleteExpiredSessions(now)
+	if err != nil || deleted != 2 {
+		t.Fatalf("DeleteExpiredSessions = %d, %v, want 2", deleted, err)
+	}
+	for _, cellNumber := range []string{"27000000001", "27000000002"} {
+		if _, err := sessions.GetSession(cellNumber); !errors.Is(err, ErrNoRows) {
+			t.Errorf("the expired session of %s was kept: %v", cellNumber, err)
+		}
+	}
+	if _, err := sessions.GetSession("27000000003"); err != nil {
+		t.Errorf("the live session was deleted: %v", err)
+	}
+
+	deleted, err = sessions.DeleteExpiredSessions(now)
+	if err != nil || deleted != 0 {
+		t.Errorf("a second DeleteExpiredSessions = %d, %v, want 0", deleted, err)
+	}
+}
@@ -0,0 +1,655 @@
+package menubotlib
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryUserRepository is a UserRepository kept in process memory.
+type MemoryUserRepository struct {
+	mu    sync.Mutex
+	users map[string]UserInfo
+}
+
+func NewMemoryUserRepository() *MemoryUserRepository {
+	return &MemoryUserRepository{users: make(map[string]UserInfo)}
+}
+
+func (r *MemoryUserRepository) GetUserInfo(cellNumber string) (UserInfo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ui, ok := r.users[cellNumber]
+	if !ok {
+		return UserInfo{CellNumber: cellNumber}, ErrNoRows
+	}
+	return ui, nil
+}
+
+func (r *MemoryUserRepository) InsertUserInfo(ui UserInfo) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.users[ui.CellNumber]; ok {
+		return fmt.Errorf("error:11, user already exists")
+	}
+	r.users[ui.CellNumber] = ui
+	return nil
+}
+
+func (r *MemoryUserRepository) UpdateUserInfoField(cellNumber, column, value string) error {
+	// Only the column is checked, the value is stored as given like the Postgres repository does
+	field, err := LookupUserInfoField(column)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Like an UPDATE matching no rows, an unknown user is not an error
+	ui, ok := r.users[cellNumber]
+	if !ok {
+		return nil
+	}
+	field.set(&ui, value)
+
+	r.users[cellNumber] = ui
+	return nil
+}
+
+// MemoryOrderRepository is an OrderRepository kept in process memory.
+type MemoryOrderRepository struct {
+	// Stock, when set, has reservations released and taken with the order status changes,
+	// the way the Postgres tables are.
+	Stock StockRepository
+
+	mu        sync.Mutex
+	orders    map[int]CustomerOrder
+	hasTotal  map[int]bool
+	history   []OrderStatusChange
+	revisions map[int][]OrderItems
+	lastID    int
+}
+
+func NewMemoryOrderRepository() *MemoryOrderRepository {
+	return &MemoryOrderRepository{
+		orders:    make(map[int]CustomerOrder),
+		hasTotal:  make(map[int]bool),
+		revisions: make(map[int][]OrderItems),
+	}
+}
+
+// Orders hold a slice, callers get their own copy so they can't change the stored order.
+func copyOrder(c CustomerOrder) CustomerOrder {
+	c.OrderItems.MenuIndications = append([]MenuIndication(nil), c.OrderItems.MenuIndications...)
+	c.Discounts = append([]OrderDiscount(nil), c.Discounts...)
+	c.Taxes = append([]OrderTax(nil), c.Taxes...)
+	return c
+}
+
+func (r *MemoryOrderRepository) GetCurrentOrder(cellNumber string) (CustomerOrder, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var current *CustomerOrder
+	for id := range r.orders {
+		order := r.orders[id]
+		if order.CellNumber != cellNumber || order.IsClosed || !order.Status.IsEditable() {
+			continue
+		}
+		if current == nil || order.OrderID > current.OrderID {
+			current = &order
+		}
+	}
+	if current == nil {
+		return CustomerOrder{}, ErrNoRows
+	}
+	return copyOrder(*current), nil
+}
+
+func (r *MemoryOrderRepository) GetOrder(cellNumber string, orderID int) (CustomerOrder, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	order, ok := r.orders[orderID]
+	if !ok || order.CellNumber != cellNumber {
+		return CustomerOrder{}, ErrNoRows
+	}
+	return copyOrder(order), nil
+}
+
+func (r *MemoryOrderRepository) ListOrders(cellNumber string, limit int) ([]CustomerOrder, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var orders []CustomerOrder
+	for _, order := range r.orders {
+		if order.CellNumber == cellNumber {
+			orders = append(orders, copyOrder(order))
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].OrderID > orders[j].OrderID })
+	if len(orders) > limit {
+		orders = orders[:limit]
+	}
+	return orders, nil
+}
+
+func (r *MemoryOrderRepository) NextOrderID() (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastID++
+	return r.lastID, nil
+}
+
+func (r *MemoryOrderRepository) InsertOrder(order CustomerOrder) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if order.OrderID == 0 {
+		r.lastID++
+		order.OrderID = r.lastID
+	} else if order.OrderID > r.lastID {
+		r.lastID = order.OrderID
+	}
+	if _, ok := r.orders[order.OrderID]; ok {
+		return 0, fmt.Errorf("failed to insert order: order %d already exists", order.OrderID)
+	}
+
+	order.OrderTotal = NewMoney(order.OrderTotal.Minor, order.OrderTotal.Currency)
+	r.orders[order.OrderID] = copyOrder(order)
+	r.hasTotal[order.OrderID] = true
+	r.history = append(r.history, OrderStatusChange{OrderID: order.OrderID, ToStatus: order.Status, ChangedAt: time.Now()})
+	return order.OrderID, nil
+}
+
+func (r *MemoryOrderRepository) UpdateOrderItems(order CustomerOrder) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.orders[order.OrderID]
+	if !ok {
+		return nil
+	}
+	stored.CellNumber = order.CellNumber
+	stored.CatalogueID = order.CatalogueID
+	stored.OrderItems = order.OrderItems
+	stored.OrderTotal = NewMoney(order.OrderTotal.Minor, order.OrderTotal.Currency)
+	stored.PromoCode = order.PromoCode
+	stored.Discounts = order.Discounts
+	stored.Fulfilment = order.Fulfilment
+	stored.DeliveryLocation = order.DeliveryLocation
+	stored.DeliveryFee = order.DeliveryFee
+	stored.Taxes = order.Taxes
+	stored.TaxIncluded = order.TaxIncluded
+	r.orders[order.OrderID] = copyOrder(stored)
+	r.hasTotal[order.OrderID] = true
+	return nil
+}
+
+func (r *MemoryOrderRepository) SetOrderTotal(orderID int, total Money) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.orders[orderID]
+	if !ok {
+		return nil
+	}
+	stored.OrderTotal = total
+	r.orders[orderID] = stored
+	r.hasTotal[orderID] = true
+	return nil
+}
+
+func (r *MemoryOrderRepository) TransitionOrderStatus(orderID int, to OrderStatus, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.transition(orderID, to, at)
+}
+
+// Callers hold r.mu.
+func (r *MemoryOrderRepository) transition(orderID int, to OrderStatus, at time.Time) error {
+	stored, ok := r.orders[orderID]
+	if !ok {
+		return ErrNoRows
+	}
+
+	noop, err := checkOrderTransition(orderID, stored.Status, to)
+	if err != nil || noop {
+		return err
+	}
+	err = settleOrderStock(r.Stock, orderID, to)
+	if err != nil {
+		return err
+	}
+
+	r.history = append(r.history, OrderStatusChange{OrderID: orderID, FromStatus: stored.Status, ToStatus: to, ChangedAt: at})
+	stored.Status = to
+	stored.IsPaid = to.IsPaid()
+	stored.IsClosed = to.IsClosed()
+	if to == OrderDelivered {
+		stored.DateTimeDelivered = sql.NullTime{Time: at, Valid: true}
+	}
+	r.orders[orderID] = stored
+	return nil
+}
+
+func (r *MemoryOrderRepository) ConfirmPayment(orderID int, amountPaid Money, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.orders[orderID]
+	if !ok {
+		return ErrNoRows
+	}
+
+	var expected *Money
+	if r.hasTotal[orderID] {
+		expected = &stored.OrderTotal
+	}
+	alreadyPaid, err := checkPayment(orderID, stored.Status, expected, amountPaid)
+	if err != nil || alreadyPaid {
+		return err
+	}
+
+	err = r.transition(orderID, OrderPaid, at)
+	if err != nil {
+		return fmt.Errorf("failed to mark order as paid: %w", err)
+	}
+	return nil
+}
+
+func (r *MemoryOrderRepository) GetOrderStatusHistory(orderID int) ([]OrderStatusChange, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var history []OrderStatusChange
+	for _, change := range r.history {
+		if change.OrderID == orderID {
+			history = append(history, change)
+		}
+	}
+	return history, nil
+}
+
+func (r *MemoryOrderRepository) PushOrderRevision(orderID int, items OrderItems, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	items.MenuIndications = append([]MenuIndication(nil), items.MenuIndications...)
+	revisions := append(r.revisions[orderID], items)
+	if len(revisions) > maxOrderRevisions {
+		revisions = revisions[len(revisions)-maxOrderRevisions:]
+	}
+	r.revisions[orderID] = revisions
+	return nil
+}
+
+func (r *MemoryOrderRepository) PopOrderRevision(orderID int) (OrderItems, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	revisions := r.revisions[orderID]
+	if len(revisions) == 0 {
+		return OrderItems{}, ErrNoRows
+	}
+	r.revisions[orderID] = revisions[:len(revisions)-1]
+	return revisions[len(revisions)-1], nil
+}
+
+func (r *MemoryOrderRepository) CountPromoCodeUses(cellNumber, code string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	uses := 0
+	for _, order := range r.orders {
+		if order.CellNumber == cellNumber && order.IsPaid && (NormalisePromoCode(order.PromoCode) == NormalisePromoCode(code) || order.hasDiscount(code)) {
+			uses++
+		}
+	}
+	return uses, nil
+}
+
+type memoryReservation struct {
+	StockQuantity
+	ExpiresAt time.Time
+}
+
+// MemoryStockRepository is a StockRepository kept in process memory.
+type MemoryStockRepository struct {
+	mu           sync.Mutex
+	onHand       map[StockKey]int
+	reservations map[int][]memoryReservation
+}
+
+func NewMemoryStockRepository() *MemoryStockRepository {
+	return &MemoryStockRepository{
+		onHand:       make(map[StockKey]int),
+		reservations: make(map[int][]memoryReservation),
+	}
+}
+
+// Callers hold r.mu.
+func (r *MemoryStockRepository) level(key StockKey, now time.Time) (StockLevel, bool) {
+	onHand, ok := r.onHand[key]
+	if !ok {
+		return StockLevel{}, false
+	}
+	level := StockLevel{StockKey: key, OnHand: onHand}
+	for _, reservations := range r.reservations {
+		for _, reservation := range reservations {
+			if reservation.StockKey == key && reservation.ExpiresAt.After(now) {
+				level.Reserved += reservation.Quantity
+			}
+		}
+	}
+	return level, true
+}
+
+func (r *MemoryStockRepository) GetStockLevels(catalogueID string) ([]StockLevel, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	var levels []StockLevel
+	for key := range r.onHand {
+		if key.CatalogueID != catalogueID {
+			continue
+		}
+		level, _ := r.level(key, now)
+		levels = append(levels, level)
+	}
+	sort.Slice(levels, func(i, j int) bool {
+		if levels[i].CatalogueItemID != levels[j].CatalogueItemID {
+			return levels[i].CatalogueItemID < levels[j].CatalogueItemID
+		}
+		return levels[i].Option < levels[j].Option
+	})
+	return levels, nil
+}
+
+func (r *MemoryStockRepository) SetStockOnHand(key StockKey, onHand int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.onHand[key] = onHand
+	return nil
+}
+
+func (r *MemoryStockRepository) ReserveStock(orderID int, quantities []StockQuantity, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// The order's own earlier reservation doesn't count against it
+	previous := r.reservations[orderID]
+	delete(r.reservations, orderID)
+
+	now := time.Now()
+	levels := make(map[StockKey]StockLevel)
+	for _, q := range quantities {
+		if level, ok := r.level(q.StockKey, now); ok {
+			levels[q.StockKey] = level
+		}
+	}
+	short := stockShortages(quantities, levels)
+	if len(short) > 0 {
+		if previous != nil {
+			r.reservations[orderID] = previous
+		}
+		return &OutOfStockError{Shortages: short}
+	}
+
+	var reservations []memoryReservation
+	for _, q := range quantities {
+		reservations = append(reservations, memoryReservation{StockQuantity: q, ExpiresAt: expiresAt})
+	}
+	r.reservations[orderID] = reservations
+	return nil
+}
+
+func (r *MemoryStockRepository) ReleaseStock(orderID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.reservations, orderID)
+	return nil
+}
+
+func (r *MemoryStockRepository) CommitStock(orderID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, reservation := range r.reservations[orderID] {
+		if onHand, ok := r.onHand[reservation.StockKey]; ok {
+			r.onHand[reservation.StockKey] = onHand - reservation.Quantity
+		}
+	}
+	delete(r.reservations, orderID)
+	return nil
+}
+
+// MemoryPromotionRepository is a PromotionRepository kept in process memory.
+type MemoryPromotionRepository struct {
+	mu         sync.Mutex
+	promotions map[string]Promotion
+}
+
+func NewMemoryPromotionRepository() *MemoryPromotionRepository {
+	return &MemoryPromotionRepository{promotions: make(map[string]Promotion)}
+}
+
+func (r *MemoryPromotionRepository) GetPromotion(code string) (Promotion, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p, ok := r.promotions[NormalisePromoCode(code)]
+	if !ok {
+		return Promotion{}, ErrNoRows
+	}
+	return p, nil
+}
+
+func (r *MemoryPromotionRepository) ActivePromotions(at time.Time) ([]Promotion, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var active []Promotion
+	for _, p := range r.promotions {
+		if p.ActiveAt(at) {
+			active = append(active, p)
+		}
+	}
+	sort.Slice(active, func(i, j int) bool { return active[i].Code < active[j].Code })
+	return active, nil
+}
+
+func (r *MemoryPromotionRepository) SavePromotion(p Promotion) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p.Code = NormalisePromoCode(p.Code)
+	r.promotions[p.Code] = p
+	return nil
+}
+
+// MemoryAddressRepository is an AddressRepository kept in process memory.
+type MemoryAddressRepository struct {
+	mu        sync.Mutex
+	addresses map[string][]Address
+	nextID    int
+}
+
+func NewMemoryAddressRepository() *MemoryAddressRepository {
+	return &MemoryAddressRepository{addresses: make(map[string][]Address), nextID: 1}
+}
+
+func (r *MemoryAddressRepository) ListAddresses(cellNumber string) ([]Address, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Address(nil), r.addresses[cellNumber]...), nil
+}
+
+func (r *MemoryAddressRepository) SaveAddress(a Address) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	a.Label = NormaliseAddressLabel(a.Label)
+	book := r.addresses[a.CellNumber]
+	for i, existing := range book {
+		if existing.Label == a.Label {
+			a.AddressID = existing.AddressID
+			a.IsDefault = existing.IsDefault
+			book[i] = a
+			return a.AddressID, nil
+		}
+	}
+	a.AddressID = r.nextID
+	r.nextID++
+	a.IsDefault = len(book) == 0
+	r.addresses[a.CellNumber] = append(book, a)
+	return a.AddressID, nil
+}
+
+func (r *MemoryAddressRepository) SetDefaultAddress(cellNumber string, addressID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	book := r.addresses[cellNumber]
+	found := false
+	for _, a := range book {
+		found = found || a.AddressID == addressID
+	}
+	if !found {
+		return ErrNoRows
+	}
+	for i := range book {
+		book[i].IsDefault = book[i].AddressID == addressID
+	}
+	return nil
+}
+
+func (r *MemoryAddressRepository) DeleteAddress(cellNumber string, addressID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	book := r.addresses[cellNumber]
+	for i, a := range book {
+		if a.AddressID != addressID {
+			continue
+		}
+		book = append(book[:i:i], book[i+1:]...)
+		if a.IsDefault && len(book) > 0 {
+			book[0].IsDefault = true
+		}
+		r.addresses[cellNumber] = book
+		return nil
+	}
+	return ErrNoRows
+}
+
+// MemoryCatalogueRepository is a CatalogueRepository kept in process memory.
+type MemoryCatalogueRepository struct {
+	mu    sync.Mutex
+	items map[string][]CatalogueItem
+}
+
+func NewMemoryCatalogueRepository() *MemoryCatalogueRepository {
+	return &MemoryCatalogueRepository{items: make(map[string][]CatalogueItem)}
+}
+
+func (r *MemoryCatalogueRepository) InsertCatalogueItems(selections []CatalogueSelection) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, selection := range selections {
+		for _, item := range selection.Items {
+			for _, existing := range r.items[item.CatalogueID] {
+				if existing.CatalogueItemID == item.CatalogueItemID {
+					return fmt.Errorf("duplicate key value violates unique constraint \"catalogueitem_pk\": %s, %d", item.CatalogueID, item.CatalogueItemID)
+				}
+			}
+			item.Selection = selection.Preamble
+			item.Options = append([]CatalogueOption(nil), item.Options...)
+			r.items[item.CatalogueID] = append(r.items[item.CatalogueID], item)
+		}
+	}
+	return nil
+}
+
+func (r *MemoryCatalogueRepository) GetCatalogueItems(catalogueID string) ([]CatalogueItem, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var items []CatalogueItem
+	for _, item := range r.items[catalogueID] {
+		item.Options = append([]CatalogueOption(nil), item.Options...)
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// MemorySessionRepository is a SessionRepository kept in process memory.
+type MemorySessionRepository struct {
+	mu       sync.Mutex
+	sessions map[string]Session
+}
+
+func NewMemorySessionRepository() *MemorySessionRepository {
+	return &MemorySessionRepository{sessions: make(map[string]Session)}
+}
+
+func copySession(s Session) Session {
+	data := make(map[string]string, len(s.Data))
+	for k, v := range s.Data {
+		data[k] = v
+	}
+	s.Data = data
+	return s
+}
+
+func (r *MemorySessionRepository) GetSession(cellNumber string) (Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	session, ok := r.sessions[cellNumber]
+	if !ok {
+		return Session{}, ErrNoRows
+	}
+	return copySession(session), nil
+}
+
+func (r *MemorySessionRepository) SaveSession(session Session) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sessions[session.CellNumber] = copySession(session)
+	return nil
+}
+
+func (r *MemorySessionRepository) DeleteSession(cellNumber string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.sessions, cellNumber)
+	return nil
+}
+
+func (r *MemorySessionRepository) DeleteExpiredSessions(before time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deleted := 0
+	for cellNumber, session := range r.sessions {
+		if session.ExpiresAt.Before(before) {
+			delete(r.sessions, cellNumber)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
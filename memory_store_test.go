@@ -0,0 +1,486 @@
+package menubotlib
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testCellNumber = "27820000000"
+
+// Flower sold by weight, 1g at R100 and R90/g from 5g, and brownies sold singly or by the box.
+func testPricelist() Pricelist {
+	items := []CatalogueItem{
+		{CatalogueID: "c1", CatalogueItemID: 1, Selection: "Flower", Item: "Lemon Haze", PricingType: WeightItem, Options: []CatalogueOption{
+			{Label: "1g", Unit: "g", Threshold: 1, Price: NewMoney(10000, DefaultCurrency)},
+			{Label: "5g", Unit: "g", Threshold: 5, Price: NewMoney(9000, DefaultCurrency)},
+		}},
+		{CatalogueID: "c1", CatalogueItemID: 2, Selection: "Flower", Item: "Purple Kush", PricingType: WeightItem, Options: []CatalogueOption{
+			{Label: "1g", Unit: "g", Threshold: 1, Price: NewMoney(12000, DefaultCurrency)},
+		}},
+		{CatalogueID: "c1", CatalogueItemID: 3, Selection: "Edibles", Item: "Brownie", PricingType: SingleItem, Options: []CatalogueOption{
+			{Label: "single", Threshold: 1, Price: NewMoney(5000, DefaultCurrency)},
+			{Label: "box of 6", Threshold: 1, Price: NewMoney(25000, DefaultCurrency)},
+		}},
+	}
+	return Pricelist{PrlstPreamble: "Prices", Catalogue: CmpsCtlgSlctnsFromCtlgItms(items)}
+}
+
+// The order items of menu number and amount pairs, items("1", "5") is 5 of item 1.
+func items(pairs ...string) OrderItems {
+	var orderItems OrderItems
+	for i := 0; i+1 < len(pairs); i += 2 {
+		num, _ := strconv.Atoi(pairs[i])
+		orderItems.MenuIndications = append(orderItems.MenuIndications, MenuIndication{ItemMenuNum: num, ItemAmount: pairs[i+1]})
+	}
+	return orderItems
+}
+
+func sameItems(a, b OrderItems) bool {
+	if len(a.MenuIndications) != len(b.MenuIndications) {
+		return false
+	}
+	for i := range a.MenuIndications {
+		if a.MenuIndications[i] != b.MenuIndications[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// A store holding one order for the test customer in the given status, nothing when status is empty.
+func storeWithOrder(t *testing.T, cart OrderItems, status OrderStatus) Store {
+	t.Helper()
+	store := NewMemoryStore()
+	if status == "" {
+		return store
+	}
+	var c CustomerOrder
+	err := c.UpdateOrInsertCurrentOrder(store.Orders, testCellNumber, cart, testPricelist(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := map[OrderStatus][]OrderStatus{
+		OrderAwaitingPayment: {OrderAwaitingPayment},
+		OrderPaid:            {OrderAwaitingPayment, OrderPaid},
+	}
+	for _, to := range path[status] {
+		err = store.Orders.TransitionOrderStatus(c.OrderID, to, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store
+}
+
+func TestApplyOrderUpdate(t *testing.T) {
+	tests := []struct {
+		name       string
+		cart       OrderItems
+		status     OrderStatus
+		update     OrderItems
+		wantID     int
+		wantItems  OrderItems
+		wantTotal  int64
+		wantBefore OrderItems
+	}{
+		{"first item starts an order", OrderItems{}, "", items("1", "5"), 1, items("1", "5"), 45000, OrderItems{}},
+		{"new item is added", items("1", "5"), OrderDraft, items("3", "1x2"), 1, items("1", "5", "3", "1x2"), 55000, items("1", "5")},
+		{"same item is replaced", items("1", "5"), OrderDraft, items("1", "1"), 1, items("1", "1"), 10000, items("1", "5")},
+		{"zero removes the item", items("1", "5", "3", "1x2"), OrderDraft, items("1", "0"), 1, items("3", "1x2"), 10000, items("1", "5", "3", "1x2")},
+		{"awaiting payment goes back to draft", items("1", "5"), OrderAwaitingPayment, items("2", "1"), 1, items("1", "5", "2", "1"), 57000, items("1", "5")},
+		{"paid order is left alone", items("1", "5"), OrderPaid, items("2", "1"), 2, items("2", "1"), 12000, OrderItems{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := storeWithOrder(t, tt.cart, tt.status)
+			var c CustomerOrder
+			before, err := c.ApplyOrderUpdate(store.Orders, testCellNumber, tt.update, testPricelist(), true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !sameItems(before, tt.wantBefore) {
+				t.Errorf("before = %v, want %v", before.MenuIndications, tt.wantBefore.MenuIndications)
+			}
+
+			stored, err := store.Orders.GetCurrentOrder(testCellNumber)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.OrderID != tt.wantID || stored.Status != OrderDraft {
+				t.Errorf("current order = %d %s, want %d draft", stored.OrderID, stored.Status, tt.wantID)
+			}
+			if !sameItems(stored.OrderItems, tt.wantItems) {
+				t.Errorf("items = %v, want %v", stored.OrderItems.MenuIndications, tt.wantItems.MenuIndications)
+			}
+			if stored.OrderTotal.Minor != tt.wantTotal {
+				t.Errorf("total = %s, want %s", stored.OrderTotal, NewMoney(tt.wantTotal, DefaultCurrency))
+			}
+		})
+	}
+}
+
+func TestTallyOrder(t *testing.T) {
+	tests := []struct {
+		name         string
+		cart         OrderItems
+		wantTotal    int64
+		wantExcluded bool
+	}{
+		{"below the bulk tier", items("1", "4"), 40000, false},
+		{"bulk tier", items("1", "5"), 45000, false},
+		{"weights and singles", items("1", "1", "2", "2", "3", "1x1,2x1"), 10000 + 24000 + 5000 + 25000, false},
+		{"unknown item is left out", items("1", "5", "9", "1"), 45000, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := storeWithOrder(t, tt.cart, OrderDraft)
+			var c CustomerOrder
+			total, excluded, err := c.TallyOrder(store.Orders, testCellNumber, testPricelist(), true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if total.Minor != tt.wantTotal {
+				t.Errorf("total = %s, want %s", total, NewMoney(tt.wantTotal, DefaultCurrency))
+			}
+			if (excluded != "") != tt.wantExcluded {
+				t.Errorf("excluded lines = %q, want some %t", excluded, tt.wantExcluded)
+			}
+		})
+	}
+
+	var c CustomerOrder
+	_, _, err := c.TallyOrder(NewMemoryStore().Orders, testCellNumber, testPricelist(), true)
+	if err == nil {
+		t.Error("tallying without an order did not fail")
+	}
+}
+
+func TestBeginCheckout(t *testing.T) {
+	tests := []struct {
+		name       string
+		cart       OrderItems
+		wantReply  string
+		wantStatus OrderStatus
+		wantTotal  int64
+	}{
+		{"valid cart", items("1", "5"), "memory://payments/checkout/1", OrderAwaitingPayment, 45000},
+		{"line below the smallest weight", items("1", "5", "2", "0.5"), "Some items need fixing", OrderDraft, 45000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := storeWithOrder(t, tt.cart, OrderDraft)
+			payments := NewMemoryPaymentProvider()
+			RegisterPaymentProvider(t.Name(), func(CheckoutInfo) PaymentProvider { return payments })
+			ui := UserInfo{CellNumber: testCellNumber}
+			reply := BeginCheckout(store, ui, testPricelist().Catalogue, CustomerOrder{}, CheckoutInfo{Provider: t.Name()}, true)
+			if !strings.Contains(reply, tt.wantReply) {
+				t.Errorf("reply = %q, want it to contain %q", reply, tt.wantReply)
+			}
+
+			order, err := store.Orders.GetOrder(testCellNumber, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if order.Status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", order.Status, tt.wantStatus)
+			}
+			if order.OrderTotal.Minor != tt.wantTotal {
+				t.Errorf("total = %s, want %s", order.OrderTotal, NewMoney(tt.wantTotal, DefaultCurrency))
+			}
+			if tt.wantStatus == OrderAwaitingPayment {
+				form := payments.NotificationForm(1, PaymentComplete)
+				if form.Get("amount_gross") != order.OrderTotal.Decimal() {
+					t.Errorf("checkout amount = %s, want %s", form.Get("amount_gross"), order.OrderTotal.Decimal())
+				}
+			}
+		})
+	}
+}
+
+func TestConfirmPayment(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     OrderStatus
+		orderID    int
+		paid       int64
+		wantErr    error
+		wantStatus OrderStatus
+	}{
+		{"exact amount", OrderAwaitingPayment, 1, 45000, nil, OrderPaid},
+		{"short payment", OrderAwaitingPayment, 1, 40000, ErrPaymentAmountMismatch, OrderAwaitingPayment},
+		{"repeated notification", OrderPaid, 1, 45000, nil, OrderPaid},
+		{"draft order", OrderDraft, 1, 45000, ErrIllegalOrderTransition, OrderDraft},
+		{"unknown order", OrderAwaitingPayment, 9, 45000, ErrNoRows, OrderAwaitingPayment},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := storeWithOrder(t, items("1", "5"), tt.status)
+			err := store.Orders.ConfirmPayment(tt.orderID, NewMoney(tt.paid, DefaultCurrency), time.Now())
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ConfirmPayment = %v, want %v", err, tt.wantErr)
+			}
+
+			order, err := store.Orders.GetOrder(testCellNumber, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if order.Status != tt.wantStatus || order.IsPaid != tt.wantStatus.IsPaid() {
+				t.Errorf("order = %s paid %t, want %s", order.Status, order.IsPaid, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestOrderStatusTransitions(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    []OrderStatus
+		wantErr bool
+	}{
+		{"checkout", []OrderStatus{OrderAwaitingPayment}, false},
+		{"back to draft", []OrderStatus{OrderAwaitingPayment, OrderDraft}, false},
+		{"paid without checkout", []OrderStatus{OrderPaid}, true},
+		{"delivered", []OrderStatus{OrderAwaitingPayment, OrderPaid, OrderPreparing, OrderOutForDelivery, OrderDelivered}, false},
+		{"refunded after delivery", []OrderStatus{OrderAwaitingPayment, OrderPaid, OrderPreparing, OrderDelivered, OrderRefunded}, false},
+		{"delivered before it was prepared", []OrderStatus{OrderAwaitingPayment, OrderPaid, OrderDelivered}, true},
+		{"reopened after delivery", []OrderStatus{OrderAwaitingPayment, OrderPaid, OrderPreparing, OrderDelivered, OrderDraft}, true},
+		{"cancelled is final", []OrderStatus{OrderCancelled, OrderDraft}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := storeWithOrder(t, items("1", "5"), OrderDraft)
+			var err error
+			reached := OrderDraft
+			for _, to := range tt.path {
+				err = store.Orders.TransitionOrderStatus(1, to, time.Now())
+				if err != nil {
+					break
+				}
+				reached = to
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("transition error = %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrIllegalOrderTransition) {
+				t.Errorf("error = %v, want %v", err, ErrIllegalOrderTransition)
+			}
+
+			order, err := store.Orders.GetOrder(testCellNumber, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if order.Status != reached || order.IsPaid != reached.IsPaid() || order.IsClosed != reached.IsClosed() {
+				t.Errorf("order = %s paid %t closed %t, want %s", order.Status, order.IsPaid, order.IsClosed, reached)
+			}
+			if reached == OrderDelivered && !order.DateTimeDelivered.Valid {
+				t.Error("a delivered order has no delivery time")
+			}
+
+			history, err := store.Orders.GetOrderStatusHistory(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if last := history[len(history)-1]; last.ToStatus != reached {
+				t.Errorf("history ends at %s, want %s", last.ToStatus, reached)
+			}
+		})
+	}
+}
+
+// A gateway that is down, every checkout fails.
+type unavailableGateway struct {
+	*MemoryPaymentProvider
+}
+
+func (unavailableGateway) CreateCheckout(cart CheckoutCart) (string, error) {
+	return "", errors.New("gateway unavailable")
+}
+
+func TestBeginCheckoutGatewayFailure(t *testing.T) {
+	RegisterPaymentProvider("unavailable", func(CheckoutInfo) PaymentProvider {
+		return unavailableGateway{NewMemoryPaymentProvider()}
+	})
+	store := storeWithOrder(t, items("1", "5"), OrderDraft)
+	key := StockKey{CatalogueID: "c1", CatalogueItemID: 1}
+	err := store.Stock.SetStockOnHand(key, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ui := UserInfo{CellNumber: testCellNumber}
+	reply := BeginCheckout(store, ui, testPricelist().Catalogue, CustomerOrder{}, CheckoutInfo{Provider: "unavailable"}, true)
+	if !strings.Contains(reply, "Checkout initiation failed") {
+		t.Errorf("reply = %q, want the checkout to fail", reply)
+	}
+
+	order, err := store.Orders.GetOrder(testCellNumber, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != OrderDraft {
+		t.Errorf("status = %s, want %s", order.Status, OrderDraft)
+	}
+	levels, err := store.Stock.GetStockLevels("c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, level := range levels {
+		if level.Reserved != 0 {
+			t.Errorf("%+v is still reserved after the checkout failed", level)
+		}
+	}
+}
+
+// An order repository whose status changes all fail.
+type stuckOrders struct {
+	OrderRepository
+}
+
+func (stuckOrders) TransitionOrderStatus(orderID int, to OrderStatus, changedAt time.Time) error {
+	return errors.New("database unavailable")
+}
+
+func TestBeginCheckoutTransitionFailure(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       OrderStatus
+		wantReserved int
+	}{
+		{"draft releases its new reservation", OrderDraft, 0},
+		{"awaiting payment keeps its reservation", OrderAwaitingPayment, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := storeWithOrder(t, items("1", "5"), OrderDraft)
+			key := StockKey{CatalogueID: "c1", CatalogueItemID: 1}
+			err := store.Stock.SetStockOnHand(key, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.status == OrderAwaitingPayment {
+				var c CustomerOrder
+				err = c.SetCurrentOrderFromDB(store.Orders, testCellNumber, true)
+				if err == nil {
+					err = reserveOrderStock(store.Stock, c, testPricelist().Catalogue)
+				}
+				if err == nil {
+					err = c.TransitionTo(store.Orders, OrderAwaitingPayment)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			store.Orders = stuckOrders{store.Orders}
+
+			ui := UserInfo{CellNumber: testCellNumber}
+			reply := BeginCheckout(store, ui, testPricelist().Catalogue, CustomerOrder{}, CheckoutInfo{Provider: "memory"}, true)
+			if !strings.Contains(reply, "Checkout initiation failed") {
+				t.Errorf("reply = %q, want the checkout to fail", reply)
+			}
+
+			levels, err := store.Stock.GetStockLevels("c1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, level := range levels {
+				if level.StockKey == key && level.Reserved != tt.wantReserved {
+					t.Errorf("reserved = %d, want %d", level.Reserved, tt.wantReserved)
+				}
+			}
+		})
+	}
+}
+
+func TestReorderLeavesOpenOrder(t *testing.T) {
+	tests := []struct {
+		name         string
+		open         OrderStatus
+		wantID       int
+		wantReply    string
+		wantReserved int
+	}{
+		{"no open order", "", 2, "", 0},
+		{"draft", OrderDraft, 3, "Your order 2 is still open, to list your orders type & send-: orders?", 0},
+		{"awaiting payment", OrderAwaitingPayment, 3, "Your order 2 is still open, to list your orders type & send-: orders?", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := storeWithOrder(t, items("1", "5"), OrderPaid)
+			prlst := testPricelist()
+			key := StockKey{CatalogueID: "c1", CatalogueItemID: 3, Option: 1}
+			err := store.Stock.SetStockOnHand(key, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var open CustomerOrder
+			if tt.open != "" {
+				err = open.UpdateOrInsertCurrentOrder(store.Orders, testCellNumber, items("3", "1x2"), prlst, true)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.open == OrderAwaitingPayment {
+				err = reserveOrderStock(store.Stock, open, prlst.Catalogue)
+				if err == nil {
+					err = open.TransitionTo(store.Orders, OrderAwaitingPayment)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			convo := &ConversationContext{UserInfo: UserInfo{CellNumber: testCellNumber}, Pricelist: prlst, CurrentOrder: open}
+			result := ReorderCommand{OrderID: 1}.Execute(store, convo, true)
+			if !result.Success {
+				t.Fatalf("reorder failed: %s", result.Reply)
+			}
+			if !strings.Contains(result.Reply, tt.wantReply) || (tt.wantReply == "" && strings.Contains(result.Reply, "still open")) {
+				t.Errorf("reply = %q, want it to contain %q", result.Reply, tt.wantReply)
+			}
+
+			current, err := store.Orders.GetCurrentOrder(testCellNumber)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if current.OrderID != tt.wantID || !sameItems(current.OrderItems, items("1", "5")) {
+				t.Errorf("current order = %d %v, want %d with the items of order 1", current.OrderID, current.OrderItems.MenuIndications, tt.wantID)
+			}
+			if tt.open != "" {
+				kept, err := store.Orders.GetOrder(testCellNumber, open.OrderID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if kept.Status != tt.open || !sameItems(kept.OrderItems, items("3", "1x2")) {
+					t.Errorf("open order = %s %v, want it left %s", kept.Status, kept.OrderItems.MenuIndications, tt.open)
+				}
+			}
+			levels, err := store.Stock.GetStockLevels("c1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, level := range levels {
+				if level.StockKey == key && level.Reserved != tt.wantReserved {
+					t.Errorf("reserved = %d, want %d", level.Reserved, tt.wantReserved)
+				}
+			}
+		})
+	}
+}
+
+func TestConfirmPaymentInAnotherCurrency(t *testing.T) {
+	store := storeWithOrder(t, items("1", "5"), OrderAwaitingPayment)
+	err := store.Orders.ConfirmPayment(1, NewMoney(45000, "USD"), time.Now())
+	if !errors.Is(err, ErrPaymentAmountMismatch) {
+		t.Errorf("ConfirmPayment = %v, want %v", err, ErrPaymentAmountMismatch)
+	}
+	order, err := store.Orders.GetOrder(testCellNumber, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != OrderAwaitingPayment {
+		t.Errorf("status = %s, want %s", order.Status, OrderAwaitingPayment)
+	}
+}
@@ -0,0 +1,359 @@
+package menubotlib
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed Migrations/*.SQL
+var migrationFiles embed.FS
+
+const migrationDir = "Migrations"
+
+// Files are named <version>_<name>.up.SQL with a matching .down.SQL, e.g. 0002_Order_Total_Cents.up.SQL.
+var regexMigrationFile = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.SQL$`)
+
+// Any fixed key will do, it only has to be the same for every process migrating the database.
+const migrationLockKey = 6362873420
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// LoadMigrations returns the migrations embedded from the Migrations directory, oldest first.
+func LoadMigrations() ([]Migration, error) {
+	return loadMigrations(migrationFiles, migrationDir)
+}
+
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.EqualFold(path.Ext(entry.Name()), ".SQL") {
+			continue
+		}
+		// A misnamed file would silently never run
+		match := regexMigrationFile.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migration file %s isn't named <version>_<name>.up.SQL or .down.SQL", entry.Name())
+		}
+
+		version, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %s: %w", entry.Name(), err)
+		}
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration version %d is used by both %s and %s", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %s has no up step", migration)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrator applies and reverts migrations, the applied versions are recorded in schema_migrations.
+type Migrator struct {
+	DB         *sql.DB
+	Migrations []Migration
+	// DryRun writes the SQL each step would run to Log instead of running it.
+	DryRun bool
+	// Log receives a line per step, nil discards it.
+	Log io.Writer
+}
+
+// NewMigrator returns a Migrator for the embedded migrations.
+func NewMigrator(db *sql.DB) (*Migrator, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{DB: db, Migrations: migrations}, nil
+}
+
+// Migrate brings the database up to the latest embedded migration.
+func Migrate(ctx context.Context, db *sql.DB) error {
+	m, err := NewMigrator(db)
+	if err != nil {
+		return err
+	}
+	_, err = m.Up(ctx)
+	return err
+}
+
+func (m *Migrator) logf(format string, args ...interface{}) {
+	if m.Log != nil {
+		fmt.Fprintf(m.Log, format, args...)
+	}
+}
+
+func (m *Migrator) ensureTable(ctx context.Context) error {
+	queryString := `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version int PRIMARY KEY,
+	name varchar(255) NOT NULL,
+	appliedat timestamp NOT NULL DEFAULT now()
+)`
+	return m.inLockedTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, queryString)
+		return err
+	})
+}
+
+// Every change runs in its own transaction holding the migration lock, so two processes starting
+// at once apply each step only once.
+func (m *Migrator) inLockedTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationLockKey)
+	if err != nil {
+		return fmt.Errorf("failed to take migration lock: %w", err)
+	}
+
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Applied returns the versions recorded in schema_migrations, oldest first.
+// A database that was never migrated has none.
+func (m *Migrator) Applied(ctx context.Context) ([]int, error) {
+	var table sql.NullString
+	err := m.DB.QueryRowContext(ctx, `SELECT to_regclass('schema_migrations')::text`).Scan(&table)
+	if err != nil {
+		return nil, err
+	}
+	if !table.Valid {
+		return nil, nil
+	}
+
+	rows, err := m.DB.QueryContext(ctx, `SELECT version FROM schema_migrations ORDER BY version`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []int
+	for rows.Next() {
+		var version int
+		err := rows.Scan(&version)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
+
+// Version returns the newest applied version, 0 for a database that was never migrated.
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	applied, err := m.Applied(ctx)
+	if err != nil || len(applied) == 0 {
+		return 0, err
+	}
+	return applied[len(applied)-1], nil
+}
+
+// Pending returns the migrations Up would apply, oldest first.
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	applied, err := m.Applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	done := make(map[int]bool, len(applied))
+	for _, version := range applied {
+		done[version] = true
+	}
+
+	var pending []Migration
+	for _, migration := range m.Migrations {
+		if !done[migration.Version] {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies every pending migration and returns the ones it applied, or would apply on a dry run.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.DryRun {
+		for _, migration := range pending {
+			m.logf("-- %s up\n%s\n", migration, migration.Up)
+		}
+		return pending, nil
+	}
+
+	err = m.ensureTable(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	var applied []Migration
+	for _, migration := range pending {
+		ran, err := m.step(ctx, migration, true)
+		if err != nil {
+			return applied, err
+		}
+		if ran {
+			m.logf("applied %s\n", migration)
+			applied = append(applied, migration)
+		}
+	}
+	return applied, nil
+}
+
+// Down reverts the newest steps applied migrations, newest first, and returns the ones it reverted.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	applied, err := m.Applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]Migration, len(m.Migrations))
+	for _, migration := range m.Migrations {
+		byVersion[migration.Version] = migration
+	}
+
+	var targets []Migration
+	for i := len(applied) - 1; i >= 0 && len(targets) < steps; i-- {
+		migration, ok := byVersion[applied[i]]
+		if !ok {
+			return nil, fmt.Errorf("applied migration version %d is unknown to this build", applied[i])
+		}
+		if migration.Down == "" {
+			return nil, fmt.Errorf("migration %s has no down step", migration)
+		}
+		targets = append(targets, migration)
+	}
+
+	if m.DryRun {
+		for _, migration := range targets {
+			m.logf("-- %s down\n%s\n", migration, migration.Down)
+		}
+		return targets, nil
+	}
+
+	var reverted []Migration
+	for _, migration := range targets {
+		ran, err := m.step(ctx, migration, false)
+		if err != nil {
+			return reverted, err
+		}
+		if ran {
+			m.logf("reverted %s\n", migration)
+			reverted = append(reverted, migration)
+		}
+	}
+	return reverted, nil
+}
+
+// Baseline records every migration up to version as applied without running it,
+// for databases that were set up by hand from the SQL files.
+func (m *Migrator) Baseline(ctx context.Context, version int) error {
+	if m.DryRun {
+		m.logf("-- baseline at %d\n", version)
+		return nil
+	}
+
+	err := m.ensureTable(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	return m.inLockedTx(ctx, func(tx *sql.Tx) error {
+		for _, migration := range m.Migrations {
+			if migration.Version > version {
+				break
+			}
+			queryString := `INSERT INTO schema_migrations (version, name) VALUES ($1, $2) ON CONFLICT (version) DO NOTHING`
+			_, err := tx.ExecContext(ctx, queryString, migration.Version, migration.Name)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Runs one direction of a migration, reports false when another process got to it first.
+func (m *Migrator) step(ctx context.Context, migration Migration, up bool) (bool, error) {
+	ran := false
+	err := m.inLockedTx(ctx, func(tx *sql.Tx) error {
+		var applied bool
+		err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)`, migration.Version).Scan(&applied)
+		if err != nil {
+			return err
+		}
+		if applied == up {
+			return nil
+		}
+
+		if up {
+			_, err = tx.ExecContext(ctx, migration.Up)
+			if err != nil {
+				return fmt.Errorf("migration %s failed: %w", migration, err)
+			}
+			_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, migration.Version, migration.Name)
+		} else {
+			_, err = tx.ExecContext(ctx, migration.Down)
+			if err != nil {
+				return fmt.Errorf("reverting migration %s failed: %w", migration, err)
+			}
+			_, err = tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, migration.Version)
+		}
+		if err != nil {
+			return err
+		}
+		ran = true
+		return nil
+	})
+	return ran, err
+}
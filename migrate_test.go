@@ -0,0 +1,358 @@
+package menubotlib
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func migrationFS(names ...string) fstest.MapFS {
+	fsys := fstest.MapFS{}
+	for _, name := range names {
+		fsys["Migrations/"+name] = &fstest.MapFile{Data: []byte("-- " + name)}
+	}
+	return fsys
+}
+
+func TestLoadMigrations(t *testing.T) {
+	tests := []struct {
+		name         string
+		fsys         fstest.MapFS
+		wantVersions []int
+		wantErr      string
+	}{
+		{"ordered by version", migrationFS("0010_Later.up.SQL", "0010_Later.down.SQL", "0002_First.up.SQL", "0002_First.down.SQL"), []int{2, 10}, ""},
+		{"other files are skipped", migrationFS("0001_Only.up.SQL", "0001_Only.down.SQL", "README.md"), []int{1}, ""},
+		{"down step is optional", migrationFS("0001_Only.up.SQL"), []int{1}, ""},
+		{"duplicate version", migrationFS("0002_First.up.SQL", "0002_Second.up.SQL"), nil, "is used by both"},
+		{"orphan down step", migrationFS("0001_Only.up.SQL", "0003_Gone.down.SQL"), nil, "has no up step"},
+		{"malformed name", migrationFS("0001_Only.up.SQL", "0002-Dashed.up.SQL"), nil, "isn't named"},
+		{"upper case direction", migrationFS("0001_Only.UP.SQL"), nil, "isn't named"},
+		{"no directory", fstest.MapFS{}, nil, "failed to read migrations"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := loadMigrations(tt.fsys, "Migrations")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var versions []int
+			for _, migration := range migrations {
+				versions = append(versions, migration.Version)
+				if migration.Up != "-- "+migration.String()+".up.SQL" {
+					t.Errorf("%s up = %q", migration, migration.Up)
+				}
+			}
+			if fmt.Sprint(versions) != fmt.Sprint(tt.wantVersions) {
+				t.Errorf("versions = %v, want %v", versions, tt.wantVersions)
+			}
+		})
+	}
+}
+
+func TestEmbeddedMigrationsArePaired(t *testing.T) {
+	entries, err := fs.ReadDir(migrationFiles, migrationDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := make(map[string]int)
+	for _, entry := range entries {
+		match := regexMigrationFile.FindStringSubmatch(entry.Name())
+		if match == nil {
+			t.Errorf("%s isn't named like a migration", entry.Name())
+			continue
+		}
+		steps[match[1]+"_"+match[2]]++
+	}
+	for migration, count := range steps {
+		if count != 2 {
+			t.Errorf("%s has %d of its up and down steps, want both", migration, count)
+		}
+	}
+
+	migrations, err := LoadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			t.Errorf("migration %s is number %d, versions should have no gaps", migration, i+1)
+		}
+	}
+}
+
+// fakeSchema stands in for Postgres as far as the Migrator uses it: schema_migrations, the
+// statements migrations run, and transactions that only keep their changes when committed.
+type fakeSchema struct {
+	table   bool
+	applied map[int]string
+	ran     []string
+	// failOn makes a migration whose SQL contains it fail.
+	failOn string
+}
+
+func (s *fakeSchema) copy() *fakeSchema {
+	c := *s
+	c.applied = make(map[int]string, len(s.applied))
+	for version, name := range s.applied {
+		c.applied[version] = name
+	}
+	c.ran = append([]string(nil), s.ran...)
+	return &c
+}
+
+type fakeConnector struct{ schema *fakeSchema }
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) {
+	return &fakeConn{schema: c.schema}, nil
+}
+
+func (c fakeConnector) Driver() driver.Driver { return nil }
+
+type fakeConn struct {
+	schema *fakeSchema
+	// tx is the schema as the open transaction sees it.
+	tx *fakeSchema
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("fake database: prepared statements aren't supported")
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.tx = c.schema.copy()
+	return c, nil
+}
+
+func (c *fakeConn) Commit() error {
+	*c.schema = *c.tx
+	c.tx = nil
+	return nil
+}
+
+func (c *fakeConn) Rollback() error {
+	c.tx = nil
+	return nil
+}
+
+func (c *fakeConn) current() *fakeSchema {
+	if c.tx != nil {
+		return c.tx
+	}
+	return c.schema
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	s := c.current()
+	switch {
+	case strings.Contains(query, "pg_advisory_xact_lock"):
+	case strings.HasPrefix(query, "CREATE TABLE IF NOT EXISTS schema_migrations"):
+		s.table = true
+	case strings.HasPrefix(query, "INSERT INTO schema_migrations"):
+		version := int(args[0].Value.(int64))
+		if _, ok := s.applied[version]; ok && !strings.Contains(query, "ON CONFLICT") {
+			return nil, fmt.Errorf("duplicate key value violates unique constraint, version %d", version)
+		}
+		if _, ok := s.applied[version]; !ok {
+			s.applied[version] = args[1].Value.(string)
+		}
+	case strings.HasPrefix(query, "DELETE FROM schema_migrations"):
+		delete(s.applied, int(args[0].Value.(int64)))
+	default:
+		if s.failOn != "" && strings.Contains(query, s.failOn) {
+			return nil, errors.New("syntax error")
+		}
+		s.ran = append(s.ran, query)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	s := c.current()
+	switch {
+	case strings.Contains(query, "to_regclass"):
+		var table driver.Value
+		if s.table {
+			table = "schema_migrations"
+		}
+		return &fakeRows{columns: []string{"to_regclass"}, values: [][]driver.Value{{table}}}, nil
+	case strings.HasPrefix(query, "SELECT version FROM schema_migrations"):
+		var versions []int
+		for version := range s.applied {
+			versions = append(versions, version)
+		}
+		sort.Ints(versions)
+		rows := &fakeRows{columns: []string{"version"}}
+		for _, version := range versions {
+			rows.values = append(rows.values, []driver.Value{int64(version)})
+		}
+		return rows, nil
+	case strings.HasPrefix(query, "SELECT EXISTS"):
+		_, ok := s.applied[int(args[0].Value.(int64))]
+		return &fakeRows{columns: []string{"exists"}, values: [][]driver.Value{{ok}}}, nil
+	}
+	return nil, fmt.Errorf("fake database: unexpected query %q", query)
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+func testMigrator(t *testing.T) (*Migrator, *fakeSchema) {
+	t.Helper()
+	migrations, err := loadMigrations(migrationFS(
+		"0001_Users.up.SQL", "0001_Users.down.SQL",
+		"0002_Orders.up.SQL", "0002_Orders.down.SQL",
+		"0003_Stock.up.SQL", "0003_Stock.down.SQL",
+	), "Migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := &fakeSchema{applied: make(map[int]string)}
+	db := sql.OpenDB(fakeConnector{schema: schema})
+	t.Cleanup(func() { db.Close() })
+	return &Migrator{DB: db, Migrations: migrations}, schema
+}
+
+func versionsOf(migrations []Migration) string {
+	var versions []int
+	for _, migration := range migrations {
+		versions = append(versions, migration.Version)
+	}
+	return fmt.Sprint(versions)
+}
+
+func TestMigratorUpAndDown(t *testing.T) {
+	ctx := context.Background()
+	m, schema := testMigrator(t)
+
+	applied, err := m.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if versionsOf(applied) != "[1 2 3]" || len(schema.ran) != 3 {
+		t.Fatalf("Up applied %s and ran %d statements, want all three once", versionsOf(applied), len(schema.ran))
+	}
+	if version, err := m.Version(ctx); err != nil || version != 3 {
+		t.Errorf("Version = %d, %v, want 3", version, err)
+	}
+
+	applied, err = m.Up(ctx)
+	if err != nil || len(applied) != 0 {
+		t.Errorf("a second Up applied %s, %v, want nothing", versionsOf(applied), err)
+	}
+
+	reverted, err := m.Down(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if versionsOf(reverted) != "[3 2]" {
+		t.Errorf("Down reverted %s, want [3 2]", versionsOf(reverted))
+	}
+	if last := schema.ran[len(schema.ran)-1]; last != "-- 0002_Orders.down.SQL" {
+		t.Errorf("last statement = %q, want the down step of 0002", last)
+	}
+	pending, err := m.Pending(ctx)
+	if err != nil || versionsOf(pending) != "[2 3]" {
+		t.Errorf("Pending = %s, %v, want [2 3]", versionsOf(pending), err)
+	}
+}
+
+func TestMigratorStopsAtFailure(t *testing.T) {
+	ctx := context.Background()
+	m, schema := testMigrator(t)
+	schema.failOn = "0002_Orders.up"
+
+	applied, err := m.Up(ctx)
+	if err == nil || !strings.Contains(err.Error(), "0002_Orders") {
+		t.Fatalf("Up error = %v, want the failing migration named", err)
+	}
+	if versionsOf(applied) != "[1]" {
+		t.Errorf("Up applied %s, want [1]", versionsOf(applied))
+	}
+	if _, ok := schema.applied[2]; ok {
+		t.Error("the failed migration was recorded as applied")
+	}
+	if _, ok := schema.applied[3]; ok {
+		t.Error("a migration after the failed one was applied")
+	}
+}
+
+func TestMigratorDryRun(t *testing.T) {
+	ctx := context.Background()
+	m, schema := testMigrator(t)
+	var log bytes.Buffer
+	m.DryRun = true
+	m.Log = &log
+
+	planned, err := m.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if versionsOf(planned) != "[1 2 3]" {
+		t.Errorf("dry run planned %s, want [1 2 3]", versionsOf(planned))
+	}
+	if len(schema.ran) != 0 || len(schema.applied) != 0 || schema.table {
+		t.Errorf("dry run changed the database: %+v", schema)
+	}
+	if !strings.Contains(log.String(), "-- 0003_Stock up\n-- 0003_Stock.up.SQL") {
+		t.Errorf("dry run log = %q, want the SQL of every step", log.String())
+	}
+}
+
+func TestMigratorBaseline(t *testing.T) {
+	ctx := context.Background()
+	m, schema := testMigrator(t)
+
+	err := m.Baseline(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schema.ran) != 0 {
+		t.Errorf("baseline ran %v", schema.ran)
+	}
+	if schema.applied[1] != "Users" || schema.applied[2] != "Orders" {
+		t.Errorf("recorded %v, want 1 and 2", schema.applied)
+	}
+
+	applied, err := m.Up(ctx)
+	if err != nil || versionsOf(applied) != "[3]" {
+		t.Errorf("Up after the baseline applied %s, %v, want [3]", versionsOf(applied), err)
+	}
+	// A baseline over versions that were already recorded changes nothing
+	err = m.Baseline(ctx, 3)
+	if err != nil || len(schema.applied) != 3 {
+		t.Errorf("second baseline = %v, recorded %v", err, schema.applied)
+	}
+}
@@ -7,17 +7,34 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 )
 
 type CheckoutCart struct {
-	ItemName      string
-	CartTotal     int
+	ItemName  string
+	CartTotal Money
+	// Lines break CartTotal down for gateways that show it, the items and the delivery fee.
+	Lines         []CheckoutLine
 	CustFirstName string
 	CustLastName  string
 	CustEmail     string
 	OrderID       int
 }
 
+type CheckoutLine struct {
+	Name   string
+	Amount Money
+}
+
+// Describes the lines in one string, "Order 12: R450.00, Delivery (City Bowl): R35.00".
+func (cart CheckoutCart) describeLines() string {
+	var lines []string
+	for _, line := range cart.Lines {
+		lines = append(lines, line.Name+": "+line.Amount.String())
+	}
+	return strings.Join(lines, ", ")
+}
+
 type KeyValue struct {
 	Key   string
 	Value string
@@ -69,7 +86,36 @@ func sliceToValues(params []KeyValue) url.Values {
 	return values
 }
 
+// PayFastProvider is the PayFast style form POST checkout the library started out with.
+type PayFastProvider struct {
+	Info   CheckoutInfo
+	Client *http.Client
+}
+
+func NewPayFastProvider(checkoutInfo CheckoutInfo) *PayFastProvider {
+	return &PayFastProvider{
+		Info: checkoutInfo,
+		Client: &http.Client{
+			// The gateway answers the POST with a redirect to the payment page, we want the
+			// Location header and not the page itself.
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
 func ProcessPayment(cart CheckoutCart, checkoutInfo CheckoutInfo) string {
+	redirectURL, err := NewPayFastProvider(checkoutInfo).CreateCheckout(cart)
+	if err != nil {
+		fmt.Println(err)
+		return "Checkout initiation failed"
+	}
+	return redirectURL
+}
+
+func (p *PayFastProvider) CreateCheckout(cart CheckoutCart) (string, error) {
+	checkoutInfo := p.Info
 	params := []KeyValue{
 		{"merchant_id", checkoutInfo.MerchantId},
 		{"merchant_key", checkoutInfo.MerchantKey},
@@ -81,9 +127,12 @@ func ProcessPayment(cart CheckoutCart, checkoutInfo CheckoutInfo) string {
 		{"email_address", cart.CustEmail},
 		{"cell_number", cart.CustLastName},
 		{"m_payment_id", strconv.Itoa(cart.OrderID)},
-		{"amount", fmt.Sprintf("%.2f", float64(cart.CartTotal))},
+		{"amount", cart.CartTotal.Decimal()},
 		{"item_name", cart.ItemName},
 	}
+	if len(cart.Lines) > 1 {
+		params = append(params, KeyValue{"item_description", cart.describeLines()})
+	}
 
 	// Generate the signature
 	signature := generateSignature(concatParams(params, checkoutInfo.Passphrase))
@@ -93,18 +142,34 @@ func ProcessPayment(cart CheckoutCart, checkoutInfo CheckoutInfo) string {
 	urlParams.Add("signature", signature)
 
 	// Make the HTTP POST request
-	resp, err := http.PostForm(checkoutInfo.HostURL, urlParams)
+	resp, err := p.client().PostForm(checkoutInfo.HostURL, urlParams)
 	if err != nil {
-		fmt.Println("error making POST request:", err)
-		return "Checkout initiation failed"
+		return "", fmt.Errorf("error making POST request: %v", err)
 	}
 	defer resp.Body.Close()
 
 	// Check if it's a redirect (3xx status code)
 	if resp.StatusCode >= 300 && resp.StatusCode < 400 {
 		redirectURL := resp.Header.Get("Location")
-		return redirectURL
+		return redirectURL, nil
+	}
+
+	return "", fmt.Errorf("checkout initiation failed, gateway answered: %s", resp.Status)
+}
+
+func (p *PayFastProvider) client() *http.Client {
+	if p.Client == nil {
+		return http.DefaultClient
 	}
+	return p.Client
+}
+
+// Querying and refunding go through PayFast's separate merchant API, which needs its own
+// credentials, so they are left to the gateway's dashboard for now.
+func (p *PayFastProvider) QueryStatus(orderID int) (PaymentStatus, error) {
+	return "", ErrPaymentOperationUnsupported
+}
 
-	return "Checkout initiation failed"
+func (p *PayFastProvider) Refund(orderID int, amount Money) error {
+	return ErrPaymentOperationUnsupported
 }
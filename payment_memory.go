@@ -0,0 +1,133 @@
+package menubotlib
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+)
+
+const memoryPaymentProvider = "memory"
+
+var ErrUnknownPayment = errors.New("no payment was started for the order")
+
+type memoryPayment struct {
+	amount Money
+	status PaymentStatus
+	// token stands in for the gateway's signature, only notifications carrying it are accepted.
+	token string
+}
+
+// MemoryPaymentProvider is a deterministic PaymentProvider that never leaves the process,
+// checkout URLs are derived from the order ID and notifications are plain form posts.
+// CheckoutInfo.Provider "memory" builds a new one every time, register a factory returning
+// the same instance to read back the checkouts BeginCheckout started and post their notifications.
+type MemoryPaymentProvider struct {
+	BaseURL string
+
+	mu       sync.Mutex
+	payments map[int]*memoryPayment
+	started  int
+}
+
+func NewMemoryPaymentProvider() *MemoryPaymentProvider {
+	return &MemoryPaymentProvider{
+		BaseURL:  "memory://payments",
+		payments: make(map[int]*memoryPayment),
+	}
+}
+
+func (p *MemoryPaymentProvider) CreateCheckout(cart CheckoutCart) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.started++
+	token := fmt.Sprintf("%d-%d", cart.OrderID, p.started)
+	p.payments[cart.OrderID] = &memoryPayment{amount: cart.CartTotal, status: PaymentPending, token: token}
+	return fmt.Sprintf("%s/checkout/%d", p.BaseURL, cart.OrderID), nil
+}
+
+// NotificationForm builds the form a notification for the order would carry,
+// post it to a PaymentNotificationHandler to simulate the gateway. The amount is the one
+// checked out, change it in the form to simulate a short payment.
+func (p *MemoryPaymentProvider) NotificationForm(orderID int, status PaymentStatus) url.Values {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	form := url.Values{}
+	form.Set("m_payment_id", strconv.Itoa(orderID))
+	form.Set("payment_status", string(status))
+	if payment, ok := p.payments[orderID]; ok {
+		form.Set("amount_gross", payment.amount.Decimal())
+		form.Set("token", payment.token)
+	}
+	return form
+}
+
+func (p *MemoryPaymentProvider) VerifyNotification(r *http.Request) (PaymentNotification, error) {
+	err := r.ParseForm()
+	if err != nil {
+		return PaymentNotification{}, fmt.Errorf("error parsing payment notification: %v", err)
+	}
+
+	orderID, err := strconv.Atoi(r.PostForm.Get("m_payment_id"))
+	if err != nil {
+		return PaymentNotification{}, fmt.Errorf("invalid m_payment_id: %v", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	payment, ok := p.payments[orderID]
+	if !ok {
+		return PaymentNotification{}, fmt.Errorf("%w: no checkout for order %d", ErrInvalidSignature, orderID)
+	}
+	if subtle.ConstantTimeCompare([]byte(payment.token), []byte(r.PostForm.Get("token"))) != 1 {
+		return PaymentNotification{}, ErrInvalidSignature
+	}
+	amountPaid, err := ParseMoney(r.PostForm.Get("amount_gross"), payment.amount.Currency)
+	if err != nil {
+		return PaymentNotification{}, fmt.Errorf("invalid amount_gross: %v", err)
+	}
+	status := PaymentStatus(r.PostForm.Get("payment_status"))
+	payment.status = status
+
+	return PaymentNotification{
+		OrderID:    orderID,
+		Status:     status,
+		AmountPaid: amountPaid,
+		GatewayRef: "memory-" + strconv.Itoa(orderID),
+	}, nil
+}
+
+func (p *MemoryPaymentProvider) QueryStatus(orderID int) (PaymentStatus, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	payment, ok := p.payments[orderID]
+	if !ok {
+		return "", fmt.Errorf("%w: order %d", ErrUnknownPayment, orderID)
+	}
+	return payment.status, nil
+}
+
+func (p *MemoryPaymentProvider) Refund(orderID int, amount Money) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	payment, ok := p.payments[orderID]
+	if !ok {
+		return fmt.Errorf("%w: order %d", ErrUnknownPayment, orderID)
+	}
+	if payment.status != PaymentComplete {
+		return fmt.Errorf("cannot refund order %d, payment is %s", orderID, payment.status)
+	}
+	if cmp, err := amount.CheckedCmp(payment.amount); err != nil || amount.Minor <= 0 || cmp > 0 {
+		return fmt.Errorf("invalid refund amount %s for order %d", amount, orderID)
+	}
+	payment.status = PaymentRefunded
+	return nil
+}
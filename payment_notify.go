@@ -0,0 +1,176 @@
+package menubotlib
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Largest ITN body we are willing to read, the gateway sends a few hundred bytes.
+const maxNotificationBytes = 64 << 10
+
+var (
+	ErrInvalidSignature      = errors.New("payment notification signature mismatch")
+	ErrNotificationRejected  = errors.New("payment notification rejected by gateway validation")
+	ErrPaymentAmountMismatch = errors.New("payment amount does not match order total")
+)
+
+// PaymentNotificationHandler receives the gateway's Instant Transaction Notification
+// (the POST sent to notify_url) and marks the matching customer order as paid.
+type PaymentNotificationHandler struct {
+	Orders   OrderRepository
+	Provider PaymentProvider
+}
+
+func NewPaymentNotificationHandler(orders OrderRepository, checkoutInfo CheckoutInfo) (*PaymentNotificationHandler, error) {
+	provider, err := NewPaymentProvider(checkoutInfo)
+	if err != nil {
+		return nil, err
+	}
+	return &PaymentNotificationHandler{Orders: orders, Provider: provider}, nil
+}
+
+func (h *PaymentNotificationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxNotificationBytes)
+	notification, err := h.Provider.VerifyNotification(r)
+	if err != nil {
+		log.Printf("payment notification rejected: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Anything other than a completed payment is acknowledged but leaves the order as is.
+	if notification.Status != PaymentComplete {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	err = h.Orders.ConfirmPayment(notification.OrderID, notification.AmountPaid, time.Now())
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusOK)
+	case errors.Is(err, ErrNoRows):
+		http.Error(w, "order not found", http.StatusNotFound)
+	case errors.Is(err, ErrIllegalOrderTransition):
+		log.Printf("payment notification for order %d: %v", notification.OrderID, err)
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrPaymentAmountMismatch):
+		log.Printf("payment notification for order %d: %v", notification.OrderID, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("error marking order %d as paid: %v", notification.OrderID, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	}
+}
+
+func (p *PayFastProvider) VerifyNotification(r *http.Request) (PaymentNotification, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return PaymentNotification{}, fmt.Errorf("unable to read notification: %v", err)
+	}
+
+	params, err := parseNotificationParams(string(body))
+	if err != nil {
+		return PaymentNotification{}, err
+	}
+
+	err = p.verifySignature(params)
+	if err != nil {
+		return PaymentNotification{}, err
+	}
+
+	values := sliceToValues(params)
+	orderID, err := strconv.Atoi(values.Get("m_payment_id"))
+	if err != nil {
+		return PaymentNotification{}, fmt.Errorf("invalid m_payment_id: %v", err)
+	}
+	amountPaid, err := ParseMoney(values.Get("amount_gross"), DefaultCurrency)
+	if err != nil {
+		return PaymentNotification{}, fmt.Errorf("invalid amount_gross: %v", err)
+	}
+
+	return PaymentNotification{
+		OrderID:    orderID,
+		Status:     PaymentStatus(values.Get("payment_status")),
+		AmountPaid: amountPaid,
+		GatewayRef: values.Get("pf_payment_id"),
+	}, nil
+}
+
+// The signature is calculated over the parameters in the order they were received,
+// so the body is split by hand instead of going through url.ParseQuery.
+func parseNotificationParams(body string) ([]KeyValue, error) {
+	var params []KeyValue
+	for _, pair := range strings.Split(body, "&") {
+		if pair == "" {
+			continue
+		}
+		rawKey, value, _ := strings.Cut(pair, "=")
+		key, err := url.QueryUnescape(rawKey)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding notification key %q: %v", rawKey, err)
+		}
+		value, err = url.QueryUnescape(value)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding notification value for %s: %v", key, err)
+		}
+		params = append(params, KeyValue{Key: key, Value: value})
+	}
+	if len(params) == 0 {
+		return nil, fmt.Errorf("empty payment notification")
+	}
+	return params, nil
+}
+
+func (p *PayFastProvider) verifySignature(params []KeyValue) error {
+	var signature string
+	var signed []KeyValue
+	for _, kv := range params {
+		if kv.Key == "signature" {
+			signature = kv.Value
+			continue
+		}
+		signed = append(signed, kv)
+	}
+
+	expected := generateSignature(concatParams(signed, p.Info.Passphrase))
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(strings.ToLower(signature))) != 1 {
+		return ErrInvalidSignature
+	}
+
+	if p.Info.ValidateURL == "" {
+		return nil
+	}
+	return p.validateWithGateway(concatParams(signed, ""))
+}
+
+// Post the received data back to the gateway, which answers VALID or INVALID.
+func (p *PayFastProvider) validateWithGateway(paramString string) error {
+	resp, err := p.client().Post(p.Info.ValidateURL, "application/x-www-form-urlencoded", strings.NewReader(paramString))
+	if err != nil {
+		return fmt.Errorf("error validating payment notification: %v", err)
+	}
+	defer resp.Body.Close()
+
+	answer, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return fmt.Errorf("error reading validation response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || strings.TrimSpace(string(answer)) != "VALID" {
+		return ErrNotificationRejected
+	}
+	return nil
+}
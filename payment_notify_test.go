@@ -0,0 +1,158 @@
+package menubotlib
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testPassphrase = "jt7NOE43FZPn"
+
+// A local stand-in for the gateway's validation endpoint, it answers every post with answer.
+func fakeGateway(t *testing.T, answer string) *httptest.Server {
+	t.Helper()
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(answer))
+	}))
+	t.Cleanup(gateway.Close)
+	return gateway
+}
+
+// An order waiting for a payment of R100.00.
+func awaitingPaymentOrders(t *testing.T) *MemoryOrderRepository {
+	t.Helper()
+	orders := NewMemoryOrderRepository()
+	_, err := orders.InsertOrder(CustomerOrder{OrderID: 1, CellNumber: "27820000000", Status: OrderAwaitingPayment, OrderTotal: NewMoney(10000, DefaultCurrency)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return orders
+}
+
+// The body of an ITN the way the gateway signs it, the signature comes last.
+func signedNotification(amount, passphrase string) string {
+	params := []KeyValue{
+		{Key: "m_payment_id", Value: "1"},
+		{Key: "pf_payment_id", Value: "1089250"},
+		{Key: "payment_status", Value: string(PaymentComplete)},
+		{Key: "item_name", Value: "Order 1"},
+		{Key: "amount_gross", Value: amount},
+	}
+	return concatParams(params, "") + "&signature=" + generateSignature(concatParams(params, passphrase))
+}
+
+func postNotification(handler http.Handler, body string) int {
+	req := httptest.NewRequest(http.MethodPost, "/notify", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestPaymentNotificationHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		gateway    string
+		wantCode   int
+		wantStatus OrderStatus
+	}{
+		{"valid signature", signedNotification("100.00", testPassphrase), "VALID", http.StatusOK, OrderPaid},
+		{"bad signature", signedNotification("100.00", "wrong passphrase"), "VALID", http.StatusBadRequest, OrderAwaitingPayment},
+		{"rejected by gateway", signedNotification("100.00", testPassphrase), "INVALID", http.StatusBadRequest, OrderAwaitingPayment},
+		{"amount mismatch", signedNotification("90.00", testPassphrase), "VALID", http.StatusBadRequest, OrderAwaitingPayment},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orders := awaitingPaymentOrders(t)
+			provider := NewPayFastProvider(CheckoutInfo{Passphrase: testPassphrase, ValidateURL: fakeGateway(t, tt.gateway).URL})
+			handler := &PaymentNotificationHandler{Orders: orders, Provider: provider}
+
+			if code := postNotification(handler, tt.body); code != tt.wantCode {
+				t.Errorf("status code = %d, want %d", code, tt.wantCode)
+			}
+			order, err := orders.GetOrder("27820000000", 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if order.Status != tt.wantStatus {
+				t.Errorf("order status = %s, want %s", order.Status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestPaymentNotificationHandlerDuplicate(t *testing.T) {
+	orders := awaitingPaymentOrders(t)
+	provider := NewPayFastProvider(CheckoutInfo{Passphrase: testPassphrase, ValidateURL: fakeGateway(t, "VALID").URL})
+	handler := &PaymentNotificationHandler{Orders: orders, Provider: provider}
+
+	body := signedNotification("100.00", testPassphrase)
+	for i := 0; i < 2; i++ {
+		if code := postNotification(handler, body); code != http.StatusOK {
+			t.Fatalf("notification %d: status code = %d, want %d", i+1, code, http.StatusOK)
+		}
+	}
+
+	history, err := orders.GetOrderStatusHistory(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paid := 0
+	for _, change := range history {
+		if change.ToStatus == OrderPaid {
+			paid++
+		}
+	}
+	if paid != 1 {
+		t.Errorf("order was marked paid %d times, want once", paid)
+	}
+}
+
+func TestMemoryPaymentNotification(t *testing.T) {
+	orders := awaitingPaymentOrders(t)
+	provider := NewMemoryPaymentProvider()
+	handler := &PaymentNotificationHandler{Orders: orders, Provider: provider}
+	_, err := provider.CreateCheckout(CheckoutCart{OrderID: 1, CartTotal: NewMoney(10000, DefaultCurrency)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forged := provider.NotificationForm(1, PaymentComplete)
+	forged.Set("token", "forged")
+	if code := postNotification(handler, forged.Encode()); code != http.StatusBadRequest {
+		t.Errorf("forged notification: status code = %d, want %d", code, http.StatusBadRequest)
+	}
+
+	if code := postNotification(handler, provider.NotificationForm(1, PaymentComplete).Encode()); code != http.StatusOK {
+		t.Errorf("status code = %d, want %d", code, http.StatusOK)
+	}
+	status, err := provider.QueryStatus(1)
+	if err != nil || status != PaymentComplete {
+		t.Errorf("QueryStatus = %s, %v, want %s", status, err, PaymentComplete)
+	}
+}
+
+func TestMemoryProviderIsNotShared(t *testing.T) {
+	first, err := NewPaymentProvider(CheckoutInfo{Provider: "memory", HostURL: "memory://shop/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewPaymentProvider(CheckoutInfo{Provider: "memory"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url, err := first.CreateCheckout(CheckoutCart{OrderID: 1, CartTotal: NewMoney(10000, DefaultCurrency)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != "memory://shop/checkout/1" {
+		t.Errorf("checkout URL = %q, want it under the HostURL", url)
+	}
+	if _, err := second.QueryStatus(1); !errors.Is(err, ErrUnknownPayment) {
+		t.Errorf("the other provider saw the checkout: %v", err)
+	}
+}
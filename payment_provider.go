@@ -0,0 +1,97 @@
+package menubotlib
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type PaymentStatus string
+
+const (
+	PaymentPending   PaymentStatus = "PENDING"
+	PaymentComplete  PaymentStatus = "COMPLETE"
+	PaymentFailed    PaymentStatus = "FAILED"
+	PaymentCancelled PaymentStatus = "CANCELLED"
+	PaymentRefunded  PaymentStatus = "REFUNDED"
+)
+
+const defaultPaymentProvider = "payfast"
+
+var ErrPaymentOperationUnsupported = errors.New("payment operation not supported by this provider")
+
+// PaymentNotification is a verified notification from a payment provider about one order.
+type PaymentNotification struct {
+	OrderID    int
+	Status     PaymentStatus
+	AmountPaid Money
+	GatewayRef string
+}
+
+// PaymentProvider is a payment gateway the checkout can hand the customer over to.
+type PaymentProvider interface {
+	// CreateCheckout starts a payment for the cart and returns the URL the customer pays at.
+	CreateCheckout(cart CheckoutCart) (string, error)
+	// VerifyNotification checks an inbound notify_url request really came from the gateway.
+	VerifyNotification(r *http.Request) (PaymentNotification, error)
+	QueryStatus(orderID int) (PaymentStatus, error)
+	Refund(orderID int, amount Money) error
+}
+
+// PaymentProviderFactory builds a provider from the checkout configuration.
+type PaymentProviderFactory func(checkoutInfo CheckoutInfo) PaymentProvider
+
+var (
+	paymentProvidersMu sync.RWMutex
+	paymentProviders   = map[string]PaymentProviderFactory{
+		defaultPaymentProvider: func(checkoutInfo CheckoutInfo) PaymentProvider {
+			return NewPayFastProvider(checkoutInfo)
+		},
+		memoryPaymentProvider: func(checkoutInfo CheckoutInfo) PaymentProvider {
+			provider := NewMemoryPaymentProvider()
+			if checkoutInfo.HostURL != "" {
+				provider.BaseURL = strings.TrimSuffix(checkoutInfo.HostURL, "/")
+			}
+			return provider
+		},
+	}
+)
+
+// RegisterPaymentProvider makes a provider selectable through CheckoutInfo.Provider,
+// registering an existing name replaces it. A factory that returns the same instance every
+// time injects a shared provider, e.g. one MemoryPaymentProvider for checkouts and notifications.
+func RegisterPaymentProvider(name string, factory PaymentProviderFactory) {
+	paymentProvidersMu.Lock()
+	defer paymentProvidersMu.Unlock()
+	paymentProviders[strings.ToLower(name)] = factory
+}
+
+// NewPaymentProvider returns the provider named in checkoutInfo.Provider, PayFast when it is empty.
+func NewPaymentProvider(checkoutInfo CheckoutInfo) (PaymentProvider, error) {
+	name := strings.ToLower(strings.TrimSpace(checkoutInfo.Provider))
+	if name == "" {
+		name = defaultPaymentProvider
+	}
+
+	paymentProvidersMu.RLock()
+	factory, ok := paymentProviders[name]
+	paymentProvidersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown payment provider: %s, registered providers: %s", name, strings.Join(registeredPaymentProviders(), ", "))
+	}
+	return factory(checkoutInfo), nil
+}
+
+func registeredPaymentProviders() []string {
+	paymentProvidersMu.RLock()
+	defer paymentProvidersMu.RUnlock()
+	var names []string
+	for name := range paymentProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -8,5 +8,8 @@ type CheckoutInfo struct {
 	MerchantKey    string
 	Passphrase     string
 	HostURL        string
+	ValidateURL    string
 	ItemNamePrefix string
+	// Provider selects a registered PaymentProvider, empty means PayFast.
+	Provider string
 }
@@ -0,0 +1,103 @@
+package menubotlib
+
+import (
+	"database/sql"
+	"time"
+)
+
+// UserRepository stores UserInfo records keyed by cell number.
+type UserRepository interface {
+	// GetUserInfo returns ErrNoRows when the cell number is unknown.
+	GetUserInfo(cellNumber string) (UserInfo, error)
+	InsertUserInfo(ui UserInfo) error
+	UpdateUserInfoField(cellNumber, column, value string) error
+}
+
+// OrderRepository stores customer orders and their status history.
+type OrderRepository interface {
+	// GetCurrentOrder returns the newest order that is still a draft or awaiting payment, ErrNoRows when there is none.
+	GetCurrentOrder(cellNumber string) (CustomerOrder, error)
+	// GetOrder returns ErrNoRows when the customer has no order with the ID.
+	GetOrder(cellNumber string, orderID int) (CustomerOrder, error)
+	// ListOrders returns up to limit of the customer's orders, newest first.
+	ListOrders(cellNumber string, limit int) ([]CustomerOrder, error)
+	NextOrderID() (int, error)
+	// InsertOrder stores a new order and returns its ID, a zero OrderID lets the store pick one.
+	InsertOrder(order CustomerOrder) (int, error)
+	// UpdateOrderItems writes the cell number, catalogue and items, the lifecycle is left alone.
+	UpdateOrderItems(order CustomerOrder) error
+	SetOrderTotal(orderID int, total Money) error
+	// TransitionOrderStatus moves the order to a new status and records the change.
+	TransitionOrderStatus(orderID int, to OrderStatus, at time.Time) error
+	// ConfirmPayment checks the paid amount against the stored total and marks the order paid,
+	// as one atomic step.
+	ConfirmPayment(orderID int, amountPaid Money, at time.Time) error
+	GetOrderStatusHistory(orderID int) ([]OrderStatusChange, error)
+	// PushOrderRevision keeps the order's items as they were before a change, only the newest few are kept.
+	PushOrderRevision(orderID int, items OrderItems, at time.Time) error
+	// PopOrderRevision removes and returns the newest revision, ErrNoRows when there is none.
+	PopOrderRevision(orderID int) (OrderItems, error)
+	// CountPromoCodeUses counts the customer's paid orders that used the promo code, applied as a code
+	// or taken off automatically.
+	CountPromoCodeUses(cellNumber, code string) (int, error)
+}
+
+type CatalogueRepository interface {
+	InsertCatalogueItems(selections []CatalogueSelection) error
+	GetCatalogueItems(catalogueID string) ([]CatalogueItem, error)
+}
+
+// SessionRepository stores the flow each customer is in, at most one per cell number.
+type SessionRepository interface {
+	// GetSession returns ErrNoRows when the customer isn't in a flow, expired sessions are returned as is.
+	GetSession(cellNumber string) (Session, error)
+	// SaveSession inserts or replaces the customer's session.
+	SaveSession(session Session) error
+	DeleteSession(cellNumber string) error
+	// DeleteExpiredSessions removes sessions that expired before the given time and returns how many.
+	DeleteExpiredSessions(before time.Time) (int, error)
+}
+
+// Store bundles the repositories the conversation logic reads and writes through.
+type Store struct {
+	Users     UserRepository
+	Orders    OrderRepository
+	Catalogue CatalogueRepository
+	// Sessions is needed for flows, without it flows can't be started.
+	Sessions SessionRepository
+	// Stock is optional, without it nothing ever runs out.
+	Stock StockRepository
+	// Promotions is optional, without it orders are always at full price.
+	Promotions PromotionRepository
+	// Addresses is optional, without it customers type where to deliver to each time.
+	Addresses AddressRepository
+}
+
+func NewPostgresStore(db *sql.DB) Store {
+	return Store{
+		Users:      &PostgresUserRepository{DB: db},
+		Orders:     &PostgresOrderRepository{DB: db},
+		Catalogue:  &PostgresCatalogueRepository{DB: db},
+		Sessions:   &PostgresSessionRepository{DB: db},
+		Stock:      &PostgresStockRepository{DB: db},
+		Promotions: &PostgresPromotionRepository{DB: db},
+		Addresses:  &PostgresAddressRepository{DB: db},
+	}
+}
+
+// NewMemoryStore returns a Store that keeps everything in process memory,
+// it is safe for concurrent use and starts out empty.
+func NewMemoryStore() Store {
+	stock := NewMemoryStockRepository()
+	orders := NewMemoryOrderRepository()
+	orders.Stock = stock
+	return Store{
+		Users:      NewMemoryUserRepository(),
+		Orders:     orders,
+		Catalogue:  NewMemoryCatalogueRepository(),
+		Sessions:   NewMemorySessionRepository(),
+		Stock:      stock,
+		Promotions: NewMemoryPromotionRepository(),
+		Addresses:  NewMemoryAddressRepository(),
+	}
+}
@@ -0,0 +1,99 @@
+package menubotlib
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// PostgresAddressRepository is the AddressRepository backed by the useraddress table.
+type PostgresAddressRepository struct {
+	DB *sql.DB
+}
+
+func (r *PostgresAddressRepository) ListAddresses(cellNumber string) ([]Address, error) {
+	queryString := `SELECT addressid, cellnumber, label, street, suburb, postalcode, latitude, longitude, isdefault
+		FROM useraddress WHERE cellnumber = $1 ORDER BY addressid`
+	rows, err := r.DB.Query(queryString, cellNumber)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var addresses []Address
+	for rows.Next() {
+		var a Address
+		var latitude, longitude sql.NullFloat64
+		err := rows.Scan(&a.AddressID, &a.CellNumber, &a.Label, &a.Street, &a.Suburb, &a.PostalCode, &latitude, &longitude, &a.IsDefault)
+		if err != nil {
+			return nil, err
+		}
+		if latitude.Valid && longitude.Valid {
+			a.Point = &GeoPoint{Latitude: latitude.Float64, Longitude: longitude.Float64}
+		}
+		addresses = append(addresses, a)
+	}
+	return addresses, rows.Err()
+}
+
+func (r *PostgresAddressRepository) SaveAddress(a Address) (int, error) {
+	var latitude, longitude sql.NullFloat64
+	if a.Point != nil {
+		latitude = sql.NullFloat64{Float64: a.Point.Latitude, Valid: true}
+		longitude = sql.NullFloat64{Float64: a.Point.Longitude, Valid: true}
+	}
+
+	var addressID int
+	queryString := `INSERT INTO useraddress (cellnumber, label, street, suburb, postalcode, latitude, longitude, isdefault)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOT EXISTS (SELECT 1 FROM useraddress WHERE cellnumber = $1))
+		ON CONFLICT (cellnumber, label) DO UPDATE SET street = EXCLUDED.street, suburb = EXCLUDED.suburb,
+		postalcode = EXCLUDED.postalcode, latitude = EXCLUDED.latitude, longitude = EXCLUDED.longitude
+		RETURNING addressid`
+	err := r.DB.QueryRow(queryString, a.CellNumber, NormaliseAddressLabel(a.Label), a.Street, a.Suburb, a.PostalCode, latitude, longitude).Scan(&addressID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to save address: %w", err)
+	}
+	return addressID, nil
+}
+
+func (r *PostgresAddressRepository) SetDefaultAddress(cellNumber string, addressID int) error {
+	queryString := `UPDATE useraddress SET isdefault = (addressid = $2)
+		WHERE cellnumber = $1 AND EXISTS (SELECT 1 FROM useraddress WHERE cellnumber = $1 AND addressid = $2)`
+	result, err := r.DB.Exec(queryString, cellNumber, addressID)
+	if err != nil {
+		return fmt.Errorf("failed to set default address: %w", err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return ErrNoRows
+	}
+	return nil
+}
+
+func (r *PostgresAddressRepository) DeleteAddress(cellNumber string, addressID int) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var wasDefault bool
+	err = tx.QueryRow(`DELETE FROM useraddress WHERE cellnumber = $1 AND addressid = $2 RETURNING isdefault`, cellNumber, addressID).Scan(&wasDefault)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNoRows
+		}
+		return fmt.Errorf("failed to delete address: %w", err)
+	}
+	if wasDefault {
+		queryString := `UPDATE useraddress SET isdefault = true
+			WHERE addressid = (SELECT MIN(addressid) FROM useraddress WHERE cellnumber = $1)`
+		_, err = tx.Exec(queryString, cellNumber)
+		if err != nil {
+			return fmt.Errorf("failed to move the default address: %w", err)
+		}
+	}
+	return tx.Commit()
+}
@@ -4,6 +4,9 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
 )
 
 // Define a custom type for PricingType
@@ -15,40 +18,114 @@ const (
 	SingleItem PricingType = "SingleItem"
 )
 
+// CatalogueOption is one priced choice of a catalogue item. For weight items the
+// Threshold is the weight from which the price applies, single items leave it at 0.
+type CatalogueOption struct {
+	Label     string `json:"Label"`
+	Unit      string `json:"Unit"`
+	Threshold int    `json:"Threshold"`
+	Price     Money  `json:"Price"`
+}
+
 type CatalogueItem struct {
 	CatalogueID     string
 	CatalogueItemID int
 	Selection       string
 	Item            string
-	Options         []string
+	Options         []CatalogueOption
 	PricingType     PricingType
+	// TaxClass picks the item's rate from the price list's TaxConfig, empty is the config's default class.
+	TaxClass string
+	// Available is the stock left keyed by StockKey.Option, filled in by WithStockLevels and nil when stock isn't tracked.
+	Available map[int]int `json:"-"`
+}
+
+func (o CatalogueOption) String() string {
+	return o.Label + " @ " + o.Price.String()
+}
+
+// Options used to be free text like "100g @ R50" or "Small @ R12.50"
+var regexLegacyOption = regexp.MustCompile(`^\s*(.*?)\s*@\s*R\s*(\d+(?:\.\d{1,2})?)\s*$`)
+var regexLegacyWeight = regexp.MustCompile(`^(\d+)\s*([a-z]+)$`)
+
+// ParseLegacyOption converts an old "label @ Rprice" option string into a CatalogueOption.
+func ParseLegacyOption(option string, pricingType PricingType) (CatalogueOption, error) {
+	match := regexLegacyOption.FindStringSubmatch(option)
+	if match == nil {
+		return CatalogueOption{}, fmt.Errorf("price not found in option: %s", option)
+	}
+	price, err := ParseMoney(match[2], DefaultCurrency)
+	if err != nil {
+		return CatalogueOption{}, fmt.Errorf("error parsing option price: %s, %v", option, err)
+	}
+	opt := CatalogueOption{Label: match[1], Price: price}
+
+	if pricingType == WeightItem {
+		weight := regexLegacyWeight.FindStringSubmatch(strings.ToLower(match[1]))
+		if weight == nil {
+			return CatalogueOption{}, fmt.Errorf("weight not found in option: %s", option)
+		}
+		opt.Threshold, err = strconv.Atoi(weight[1])
+		if err != nil {
+			return CatalogueOption{}, fmt.Errorf("error parsing option weight: %s, %v", option, err)
+		}
+		opt.Unit = weight[2]
+		// Weight orders are in grams
+		if opt.Unit == "kg" {
+			opt.Threshold *= 1000
+			opt.Unit = "g"
+		}
+	}
+
+	return opt, nil
 }
 
 // Generate a string for a single question and answer
 func (i *CatalogueItem) CatalogueItemAsAString() string {
 	optionsText := ""
-	for i, option := range i.Options {
-		optionsText += fmt.Sprintf("   %d. %s\n", i+1, option)
+	for index, option := range i.Options {
+		optionsText += fmt.Sprintf("   %d. %s", index+1, option.String())
+		if i.optionOutOfStock(index) {
+			optionsText += " (out of stock)"
+		}
+		optionsText += "\n"
 	}
 
-	qA := fmt.Sprintf("%d: %s\n%s\n", i.CatalogueItemID, i.Item, optionsText)
+	name := i.Item
+	if i.OutOfStock() {
+		name += " *OUT OF STOCK*"
+	}
+	qA := fmt.Sprintf("%d: %s\n%s\n", i.CatalogueItemID, name, optionsText)
 
 	return qA
 }
 
-func InsertCatalogueItems(db *sql.DB, selections []CatalogueSelection) error {
+func InsertCatalogueItems(catalogue CatalogueRepository, selections []CatalogueSelection) error {
+	return catalogue.InsertCatalogueItems(selections)
+}
+
+func GetCatalogueItemsFromDB(catalogue CatalogueRepository, catalogueid string) ([]CatalogueItem, error) {
+	return catalogue.GetCatalogueItems(catalogueid)
+}
+
+// PostgresCatalogueRepository is the CatalogueRepository backed by the catalogueitem table.
+type PostgresCatalogueRepository struct {
+	DB *sql.DB
+}
+
+func (r *PostgresCatalogueRepository) InsertCatalogueItems(selections []CatalogueSelection) error {
 	insertStmt := `
-	INSERT INTO catalogueitem (catalogueID, catalogueitemID, "selection", "item", "options", pricingType)
-	VALUES (?, ?, ?, ?, ?, ?);`
+	INSERT INTO catalogueitem (catalogueID, catalogueitemID, "selection", "item", "options", pricingType, taxclass)
+	VALUES ($1, $2, $3, $4, $5, $6, $7);`
 
 	for _, selection := range selections {
 		for _, item := range selection.Items {
-			// Marshal the []string into JSON
+			// Marshal the []CatalogueOption into JSON
 			optionsJSON, err := json.Marshal(item.Options)
 			if err != nil {
 				return err
 			}
-			_, err = db.Exec(insertStmt, item.CatalogueID, item.CatalogueItemID, selection.Preamble, item.Item, optionsJSON, item.PricingType)
+			_, err = r.DB.Exec(insertStmt, item.CatalogueID, item.CatalogueItemID, selection.Preamble, item.Item, optionsJSON, item.PricingType, item.TaxClass)
 			if err != nil {
 				return err
 			}
@@ -58,13 +135,13 @@ func InsertCatalogueItems(db *sql.DB, selections []CatalogueSelection) error {
 	return nil
 }
 
-func GetCatalogueItemsFromDB(db *sql.DB, catalogueid string) ([]CatalogueItem, error) {
+func (r *PostgresCatalogueRepository) GetCatalogueItems(catalogueid string) ([]CatalogueItem, error) {
 	query := `
-	SELECT catalogueID, catalogueitemID, "selection", "item", "options", pricingType
+	SELECT catalogueID, catalogueitemID, "selection", "item", "options", pricingType, COALESCE(taxclass, '')
 	FROM catalogueitem
 	WHERE catalogueID = $1;`
 
-	rows, err := db.Query(query, catalogueid)
+	rows, err := r.DB.Query(query, catalogueid)
 	if err != nil {
 		return nil, err
 	}
@@ -81,19 +158,12 @@ func GetCatalogueItemsFromDB(db *sql.DB, catalogueid string) ([]CatalogueItem, e
 		var item CatalogueItem
 		var optionsStr string
 
-		err := rows.Scan(&item.CatalogueID, &item.CatalogueItemID, &item.Selection, &item.Item, &optionsStr, &item.PricingType)
+		err := rows.Scan(&item.CatalogueID, &item.CatalogueItemID, &item.Selection, &item.Item, &optionsStr, &item.PricingType, &item.TaxClass)
 		if err != nil {
 			item = CatalogueItem{}
 		}
 
-		// Unmarshal the JSON back into a []string
-		var options []string
-		err = json.Unmarshal([]byte(optionsStr), &options)
-		if err != nil {
-			item.Options = nil
-		} else {
-			item.Options = options
-		}
+		item.Options = unmarshalCatalogueOptions(optionsStr, item.PricingType)
 
 		rtnItems = append(rtnItems, item)
 	}
@@ -104,3 +174,25 @@ func GetCatalogueItemsFromDB(db *sql.DB, catalogueid string) ([]CatalogueItem, e
 
 	return rtnItems, nil
 }
+
+// Unmarshal the options JSON, rows that were never migrated still hold a []string.
+func unmarshalCatalogueOptions(optionsStr string, pricingType PricingType) []CatalogueOption {
+	var options []CatalogueOption
+	if err := json.Unmarshal([]byte(optionsStr), &options); err == nil {
+		return options
+	}
+
+	var legacy []string
+	if err := json.Unmarshal([]byte(optionsStr), &legacy); err != nil {
+		return nil
+	}
+	options = nil
+	for _, option := range legacy {
+		opt, err := ParseLegacyOption(option, pricingType)
+		if err != nil {
+			return nil
+		}
+		options = append(options, opt)
+	}
+	return options
+}
@@ -8,39 +8,46 @@ import (
 	"log"
 	"strconv"
 	"strings"
+	"time"
 
 	_ "github.com/lib/pq"
 )
 
 type CustomerOrder struct {
-	OrderID           int
-	CellNumber        string
-	CatalogueID       string
-	OrderItems        OrderItems
-	OrderTotal        int
+	OrderID     int
+	CellNumber  string
+	CatalogueID string
+	OrderItems  OrderItems
+	OrderTotal  Money
+	// PromoCode is the discount code the customer applied, Discounts what the promotions took off OrderTotal.
+	PromoCode string
+	Discounts []OrderDiscount
+	// Fulfilment is pickup or delivery to DeliveryLocation, DeliveryFee is included in OrderTotal.
+	Fulfilment       Fulfilment
+	DeliveryLocation DeliveryLocation
+	DeliveryFee      Money
+	// Taxes is the tax in OrderTax, added on top of the prices unless TaxIncluded.
+	Taxes             []OrderTax
+	TaxIncluded       bool
+	Status            OrderStatus
 	IsPaid            bool
 	DateTimeDelivered sql.NullTime
 	IsClosed          bool
 }
 
-var ErrNoRows = errors.New("no rows found")
-
-func (c *CustomerOrder) SetCurrentOrderFromDB(db *sql.DB, senderNum string, isAutoInc bool) error {
-	var orderItemsJSON []byte
+var (
+	ErrNoRows           = errors.New("no rows found")
+	ErrOrderNotEditable = errors.New("order can no longer be changed")
+)
 
+func (c *CustomerOrder) SetCurrentOrderFromDB(orders OrderRepository, senderNum string, isAutoInc bool) error {
 	c.CellNumber = senderNum
-	queryString := `SELECT orderid, cellnumber, catalogueID, orderitems, ispaid, datetimedelivered 
-                    FROM CustomerOrder 
-                    WHERE cellnumber = $1 AND isclosed = false
-                    ORDER BY orderid DESC
-                    LIMIT 1`
-	row := db.QueryRow(queryString, c.CellNumber)
-	err := row.Scan(&c.OrderID, &c.CellNumber, &c.CatalogueID, &orderItemsJSON, &c.IsPaid, &c.DateTimeDelivered)
+	order, err := orders.GetCurrentOrder(senderNum)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if errors.Is(err, ErrNoRows) {
 			if !isAutoInc {
 				// No rows were found, get the next value in the sequence
-				err = db.QueryRow("SELECT nextval('customerorder_id_seq')").Scan(&c.OrderID)
+				c.OrderID, err = orders.NextOrderID()
 				if err != nil {
 					return err
 				}
@@ -51,21 +58,16 @@ func (c *CustomerOrder) SetCurrentOrderFromDB(db *sql.DB, senderNum string, isAu
 			// Some other error occurred
 			return err
 		}
-	} else {
-		// Unmarshal JSON data into the OrderItems struct
-		err = json.Unmarshal(orderItemsJSON, &c.OrderItems)
-		if err != nil {
-			return fmt.Errorf("failed to unmarshal orderItems: %w", err)
-		}
 	}
 
+	*c = order
 	return nil
 }
 
-func (c *CustomerOrder) checkInitialization(db *sql.DB, senderNum string, isAutoInc bool) string {
+func (c *CustomerOrder) checkInitialization(orders OrderRepository, senderNum string, isAutoInc bool) string {
 	//Get the customer's current order
 	if c.OrderItems.MenuIndications == nil {
-		c.SetCurrentOrderFromDB(db, senderNum, isAutoInc)
+		c.SetCurrentOrderFromDB(orders, senderNum, isAutoInc)
 		if c.OrderItems.MenuIndications == nil {
 			return "No current order. We vill asks ze questions."
 		}
@@ -74,63 +76,79 @@ func (c *CustomerOrder) checkInitialization(db *sql.DB, senderNum string, isAuto
 }
 
 // A function that returns the current order of a user as a string
-func (c *CustomerOrder) GetCurrentOrderAsAString(db *sql.DB, senderNum string, isAutoInc bool) string {
-	isInited := c.checkInitialization(db, senderNum, isAutoInc)
+func (c *CustomerOrder) GetCurrentOrderAsAString(orders OrderRepository, senderNum string, prlst Pricelist, isAutoInc bool) string {
+	isInited := c.checkInitialization(orders, senderNum, isAutoInc)
 	if isInited != custOrderInitState {
 		return isInited
 	}
-	// iterate over the slice and build the string
-	var orderItemsString string
-	for _, item := range c.OrderItems.MenuIndications {
-		orderItemsString += fmt.Sprintf("\n%d: %s,", item.ItemMenuNum, item.ItemAmount)
+	return c.describe(prlst)
+}
+
+// Pricing is a draft at today's prices and promotions, from checkout on it is frozen at what the customer was asked to pay.
+func (c *CustomerOrder) Pricing(prlst Pricelist) OrderPricing {
+	if c.Status == OrderDraft || c.OrderTotal.Currency == "" {
+		return c.priceOrder(prlst)
+	}
+	pricing := c.storedPricing()
+	if prlst.Tax != nil && len(pricing.Taxes) > 0 {
+		pricing.TaxNumber = prlst.Tax.RegistrationNumber
 	}
-	// If DateTimeDelivered is null then set it to "Not yet delivered"
-	var dateTimeDelivered string
-	if c.DateTimeDelivered.Valid {
-		dateTimeDelivered = c.DateTimeDelivered.Time.Format("2006-01-02 15:04:05")
-	} else {
-		dateTimeDelivered = "Not yet delivered"
+	pricing.Fulfilment = prlst.Delivery.FulfilmentOf(*c)
+	if zone, ok := prlst.Delivery.ZoneFor(c.DeliveryLocation); ok && pricing.Fulfilment == FulfilmentDelivery {
+		pricing.DeliveryZone = zone.Name
 	}
-	return fmt.Sprintf("Is Paid: %t\nDelivered on: %v\nOrder Items:%s",
-		c.IsPaid, dateTimeDelivered, orderItemsString)
+	return pricing
 }
 
-// Insert User Answer into database
-func (c *CustomerOrder) insertOrder(db *sql.DB) error {
-	// Convert OrderItems struct to JSON string
-	orderItemsJSON, err := json.Marshal(c.OrderItems)
-	if err != nil {
-		return fmt.Errorf("failed to marshal orderItems: %w", err)
-	}
+// The items with their promotions, the delivery fee and tax, at today's prices.
+func (c *CustomerOrder) priceOrder(prlst Pricelist) OrderPricing {
+	pricing := prlst.Delivery.priceDelivery(*c, PriceOrder(c.OrderItems, c.PromoCode, prlst))
+	return prlst.Tax.priceTax(c.OrderItems.PriceLines(prlst.Catalogue), pricing)
+}
 
-	// Prepare an SQL statement to insert a new order
-	queryString := `INSERT INTO CustomerOrder (orderid, cellnumber, catalogueID, orderitems, ispaid, datetimedelivered, isclosed) 
-                    VALUES ($1, $2, $3, $4, $5, $6, $7)`
-	_, err = db.Exec(queryString, c.OrderID, c.CellNumber, c.CatalogueID, orderItemsJSON, c.IsPaid, c.DateTimeDelivered, c.IsClosed)
-	if err != nil {
-		return fmt.Errorf("failed to insert order: %w", err)
+// The pricing as it was stored with the order.
+func (c *CustomerOrder) storedPricing() OrderPricing {
+	pricing := OrderPricing{Discounts: c.Discounts, Fulfilment: c.Fulfilment, DeliveryFee: c.DeliveryFee, Taxes: c.Taxes, TaxIncluded: c.TaxIncluded, Total: c.OrderTotal}
+	pricing.Subtotal = c.OrderTotal.Sub(c.DeliveryFee).Sub(pricing.addedTax())
+	for _, discount := range c.Discounts {
+		pricing.Subtotal = pricing.Subtotal.Add(discount.Amount)
 	}
+	return pricing
+}
 
-	return nil
+// Recomputes the total of a changed cart, it is stored along with the items.
+func (c *CustomerOrder) refreshOrderTotal(prlst Pricelist) {
+	pricing := c.priceOrder(prlst)
+	c.OrderTotal = pricing.Total
+	c.keepPricing(pricing)
 }
 
-func (c *CustomerOrder) updateCurrentOrder(db *sql.DB) error {
-	// Convert OrderItems struct to JSON string
-	orderItemsJSON, err := json.Marshal(c.OrderItems)
-	if err != nil {
-		return fmt.Errorf("failed to marshal orderItems: %w", err)
+// Keeps how the total was arrived at with the order, so it can be shown once the total is frozen.
+func (c *CustomerOrder) keepPricing(pricing OrderPricing) {
+	c.Discounts = pricing.Discounts
+	c.DeliveryFee = pricing.DeliveryFee
+	c.Taxes = pricing.Taxes
+	c.TaxIncluded = pricing.TaxIncluded
+}
+
+// Insert User Answer into the order repository
+func (c *CustomerOrder) insertOrder(orders OrderRepository) error {
+	if c.Status == "" {
+		c.Status = OrderDraft
 	}
 
-	// Prepare an SQL statement to update the order
-	queryString := `UPDATE CustomerOrder SET cellnumber = $1, catalogueID = $2, orderitems = $3, ispaid = $4, datetimedelivered = $5, isclosed = $6 WHERE orderid = $7`
-	_, err = db.Exec(queryString, c.CellNumber, c.CatalogueID, orderItemsJSON, c.IsPaid, c.DateTimeDelivered, c.IsClosed, c.OrderID)
+	orderID, err := orders.InsertOrder(*c)
 	if err != nil {
 		return err
 	}
-
+	c.OrderID = orderID
 	return nil
 }
 
+func (c *CustomerOrder) updateCurrentOrder(orders OrderRepository) error {
+	return orders.UpdateOrderItems(*c)
+}
+
 // UpdateOrInsertCurrentOrder updates or inserts a customer order in the database.
 func (c *CustomerOrder) cleanOrderItems() error {
 	// Filter out items with ItemAmount equal to "0"
@@ -173,35 +191,73 @@ func (c *CustomerOrder) UpdateCustOrdItems(update OrderItems) error {
 	return nil
 }
 
-func (c *CustomerOrder) UpdateOrInsertCurrentOrder(db *sql.DB, senderNum string, update OrderItems, isAutoInc bool) error {
+func (c *CustomerOrder) UpdateOrInsertCurrentOrder(orders OrderRepository, senderNum string, update OrderItems, prlst Pricelist, isAutoInc bool) error {
+	_, err := c.ApplyOrderUpdate(orders, senderNum, update, prlst, isAutoInc)
+	return err
+}
+
+// ApplyOrderUpdate is UpdateOrInsertCurrentOrder that also returns the items the order had before,
+// which are kept as a revision for undo. The order's total is recomputed against the price list and stored with it.
+func (c *CustomerOrder) ApplyOrderUpdate(orders OrderRepository, senderNum string, update OrderItems, prlst Pricelist, isAutoInc bool) (OrderItems, error) {
+	var before OrderItems
 	// Try to find the order in the database
-	err := c.SetCurrentOrderFromDB(db, senderNum, isAutoInc)
+	err := c.SetCurrentOrderFromDB(orders, senderNum, isAutoInc)
 	if err != nil {
 		if strings.Contains(err.Error(), "no rows") {
-			err := c.insertOrder(db)
+			err = c.UpdateCustOrdItems(update)
+			if err != nil {
+				log.Printf("error writing the new values to the new order: %v", err)
+				return before, err
+			}
+			c.refreshOrderTotal(prlst)
+			err = c.insertOrder(orders)
 			if err != nil {
 				log.Printf("error inserting the order in the DB: %v", err)
-				return err
+				return before, err
 			}
 		} else {
 			// Some other error occurred
-			return err
+			return before, err
 		}
 	} else {
+		if !c.Status.IsEditable() {
+			return before, fmt.Errorf("%w: order %d is %s", ErrOrderNotEditable, c.OrderID, c.Status.Label())
+		}
+		// A changed cart has to go through checkout again
+		if c.Status == OrderAwaitingPayment {
+			err = c.TransitionTo(orders, OrderDraft)
+			if err != nil {
+				log.Printf("error moving the order back to draft: %v", err)
+				return before, err
+			}
+		}
 
+		before.MenuIndications = append([]MenuIndication(nil), c.OrderItems.MenuIndications...)
 		err = c.UpdateCustOrdItems(update)
 		if err != nil {
 			log.Printf("error writing the new values to the current order: %v", err)
-			return err
+			return before, err
 		}
+		c.refreshOrderTotal(prlst)
 		// Keep in mind This will return without errors if the row does not exist
-		err = c.updateCurrentOrder(db)
+		err = c.updateCurrentOrder(orders)
 		if err != nil {
 			log.Printf("error updating the order in the DB: %v", err)
-			return err
+			return before, err
 		}
 	}
 
+	c.recordRevision(orders, before)
+	return before, nil
+}
+
+// Store the tallied total on the order, payment notifications are checked against it.
+func (c *CustomerOrder) SetOrderTotal(orders OrderRepository, total Money) error {
+	err := orders.SetOrderTotal(c.OrderID, total)
+	if err != nil {
+		return err
+	}
+	c.OrderTotal = total
 	return nil
 }
 
@@ -209,13 +265,316 @@ func (c *CustomerOrder) BuildItemName(itemNamePrefix string) string {
 	return itemNamePrefix + strconv.Itoa(c.OrderID)
 }
 
-// Main function to tally the order
-func (c *CustomerOrder) TallyOrder(db *sql.DB, senderNum string, ctlgselections []CatalogueSelection, isAutoInc bool) (int, string, error) {
-	isInited := c.checkInitialization(db, senderNum, isAutoInc)
+// The checkout lines of a tallied order, the items after discounts, the delivery fee unless it is free
+// and the tax when it is added on top.
+func (c *CustomerOrder) checkoutLines(itemNamePrefix string) []CheckoutLine {
+	addedTax := c.storedPricing().addedTax()
+	lines := []CheckoutLine{{Name: c.BuildItemName(itemNamePrefix), Amount: c.OrderTotal.Sub(c.DeliveryFee).Sub(addedTax)}}
+	if !c.DeliveryFee.IsZero() {
+		lines = append(lines, CheckoutLine{Name: "Delivery", Amount: c.DeliveryFee})
+	}
+	for _, tax := range c.Taxes {
+		if !c.TaxIncluded {
+			lines = append(lines, CheckoutLine{Name: tax.Rate().String(), Amount: tax.Amount})
+		}
+	}
+	return lines
+}
+
+// Main function to tally the order, the total has the order's promotions taken off and its delivery fee and tax added
+func (c *CustomerOrder) TallyOrder(orders OrderRepository, senderNum string, prlst Pricelist, isAutoInc bool) (Money, string, error) {
+	isInited := c.checkInitialization(orders, senderNum, isAutoInc)
 	if isInited != custOrderInitState {
-		return -1, "", fmt.Errorf("while tallying the order, no current order")
+		return Money{}, "", fmt.Errorf("while tallying the order, no current order")
+	}
+
+	_, cartSummary := c.OrderItems.CalculatePrice(prlst.Catalogue)
+	pricing := c.priceOrder(prlst)
+	c.keepPricing(pricing)
+	return pricing.Total, cartSummary, nil
+}
+
+// PostgresOrderRepository is the OrderRepository backed by the customerorder tables.
+type PostgresOrderRepository struct {
+	DB *sql.DB
+}
+
+const customerOrderColumns = `orderid, cellnumber, catalogueID, orderitems, ordertotal, ordercurrency, promocode, discounts,
+                    fulfilment, addressid, deliveryaddress, deliveryarea, deliverypostalcode, deliverylatitude, deliverylongitude, deliveryfee,
+                    taxes, taxincluded, status, ispaid, datetimedelivered, isclosed`
+
+func scanCustomerOrder(row interface{ Scan(...interface{}) error }) (CustomerOrder, error) {
+	var c CustomerOrder
+	var orderItemsJSON []byte
+	var orderTotal sql.NullString
+	var currency sql.NullString
+	var promoCode sql.NullString
+	var discountsJSON sql.NullString
+	var taxesJSON sql.NullString
+	var taxIncluded sql.NullBool
+	var fulfilment, deliveryArea sql.NullString
+	var addressID sql.NullInt64
+	var deliveryAddress, deliveryPostalCode sql.NullString
+	var deliveryLatitude, deliveryLongitude sql.NullFloat64
+	var deliveryFee sql.NullString
+
+	err := row.Scan(&c.OrderID, &c.CellNumber, &c.CatalogueID, &orderItemsJSON, &orderTotal, &currency, &promoCode, &discountsJSON,
+		&fulfilment, &addressID, &deliveryAddress, &deliveryArea, &deliveryPostalCode, &deliveryLatitude, &deliveryLongitude, &deliveryFee,
+		&taxesJSON, &taxIncluded, &c.Status, &c.IsPaid, &c.DateTimeDelivered, &c.IsClosed)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c, ErrNoRows
+		}
+		return c, err
+	}
+
+	// Unmarshal JSON data into the OrderItems struct
+	err = json.Unmarshal(orderItemsJSON, &c.OrderItems)
+	if err != nil {
+		return c, fmt.Errorf("failed to unmarshal orderItems: %w", err)
+	}
+	if orderTotal.Valid {
+		c.OrderTotal, err = ParseMoney(orderTotal.String, currency.String)
+		if err != nil {
+			return c, fmt.Errorf("failed to parse order total: %w", err)
+		}
+	}
+	c.PromoCode = promoCode.String
+	if discountsJSON.Valid {
+		err = json.Unmarshal([]byte(discountsJSON.String), &c.Discounts)
+		if err != nil {
+			return c, fmt.Errorf("failed to unmarshal discounts: %w", err)
+		}
+	}
+	if taxesJSON.Valid {
+		err = json.Unmarshal([]byte(taxesJSON.String), &c.Taxes)
+		if err != nil {
+			return c, fmt.Errorf("failed to unmarshal taxes: %w", err)
+		}
+	}
+	c.TaxIncluded = taxIncluded.Bool
+	c.Fulfilment = Fulfilment(fulfilment.String)
+	c.DeliveryLocation = DeliveryLocation{
+		AddressID:  int(addressID.Int64),
+		Address:    deliveryAddress.String,
+		Area:       deliveryArea.String,
+		PostalCode: deliveryPostalCode.String,
+	}
+	if deliveryLatitude.Valid && deliveryLongitude.Valid {
+		c.DeliveryLocation.Point = &GeoPoint{Latitude: deliveryLatitude.Float64, Longitude: deliveryLongitude.Float64}
+	}
+	if deliveryFee.Valid {
+		c.DeliveryFee, err = ParseMoney(deliveryFee.String, c.OrderTotal.Currency)
+		if err != nil {
+			return c, fmt.Errorf("failed to parse delivery fee: %w", err)
+		}
+	}
+
+	return c, nil
+}
+
+// GetCurrentOrder returns the customer's newest order they can still change, orders that are paid
+// for and on their way don't stop them from starting another.
+func (r *PostgresOrderRepository) GetCurrentOrder(cellNumber string) (CustomerOrder, error) {
+	queryString := `SELECT ` + customerOrderColumns + `
+                    FROM CustomerOrder 
+                    WHERE cellnumber = $1 AND isclosed = false AND status IN ($2, $3)
+                    ORDER BY orderid DESC
+                    LIMIT 1`
+	return scanCustomerOrder(r.DB.QueryRow(queryString, cellNumber, OrderDraft, OrderAwaitingPayment))
+}
+
+func (r *PostgresOrderRepository) GetOrder(cellNumber string, orderID int) (CustomerOrder, error) {
+	queryString := `SELECT ` + customerOrderColumns + ` FROM CustomerOrder WHERE cellnumber = $1 AND orderid = $2`
+	return scanCustomerOrder(r.DB.QueryRow(queryString, cellNumber, orderID))
+}
+
+func (r *PostgresOrderRepository) ListOrders(cellNumber string, limit int) ([]CustomerOrder, error) {
+	queryString := `SELECT ` + customerOrderColumns + ` FROM CustomerOrder WHERE cellnumber = $1 ORDER BY orderid DESC LIMIT $2`
+	rows, err := r.DB.Query(queryString, cellNumber, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orders []CustomerOrder
+	for rows.Next() {
+		c, err := scanCustomerOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, c)
+	}
+	return orders, rows.Err()
+}
+
+func (r *PostgresOrderRepository) NextOrderID() (int, error) {
+	var orderID int
+	err := r.DB.QueryRow("SELECT nextval('customerorder_id_seq')").Scan(&orderID)
+	return orderID, err
+}
+
+func (r *PostgresOrderRepository) InsertOrder(c CustomerOrder) (int, error) {
+	// Convert OrderItems struct to JSON string
+	orderItemsJSON, err := json.Marshal(c.OrderItems)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal orderItems: %w", err)
+	}
+
+	discountsJSON, err := json.Marshal(c.Discounts)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal discounts: %w", err)
+	}
+
+	taxesJSON, err := json.Marshal(c.Taxes)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal taxes: %w", err)
+	}
+
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Prepare an SQL statement to insert a new order, without an ID the sequence default is used
+	total := NewMoney(c.OrderTotal.Minor, c.OrderTotal.Currency)
+	promoCode := sql.NullString{String: c.PromoCode, Valid: c.PromoCode != ""}
+	d := newDeliveryColumns(c)
+	if c.OrderID == 0 {
+		queryString := `INSERT INTO CustomerOrder (cellnumber, catalogueID, orderitems, ordertotal, ordercurrency, promocode, discounts,
+                    fulfilment, addressid, deliveryaddress, deliveryarea, deliverypostalcode, deliverylatitude, deliverylongitude, deliveryfee,
+                    taxes, taxincluded, status, ispaid, datetimedelivered, isclosed) 
+                    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21) RETURNING orderid`
+		err = tx.QueryRow(queryString, c.CellNumber, c.CatalogueID, orderItemsJSON, total, total.Currency, promoCode, string(discountsJSON),
+			d.fulfilment, d.addressID, d.address, d.area, d.postalCode, d.latitude, d.longitude, d.fee,
+			string(taxesJSON), c.TaxIncluded, c.Status, c.IsPaid, c.DateTimeDelivered, c.IsClosed).Scan(&c.OrderID)
+	} else {
+		queryString := `INSERT INTO CustomerOrder (orderid, cellnumber, catalogueID, orderitems, ordertotal, ordercurrency, promocode, discounts,
+                    fulfilment, addressid, deliveryaddress, deliveryarea, deliverypostalcode, deliverylatitude, deliverylongitude, deliveryfee,
+                    taxes, taxincluded, status, ispaid, datetimedelivered, isclosed) 
+                    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22)`
+		_, err = tx.Exec(queryString, c.OrderID, c.CellNumber, c.CatalogueID, orderItemsJSON, total, total.Currency, promoCode, string(discountsJSON),
+			d.fulfilment, d.addressID, d.address, d.area, d.postalCode, d.latitude, d.longitude, d.fee,
+			string(taxesJSON), c.TaxIncluded, c.Status, c.IsPaid, c.DateTimeDelivered, c.IsClosed)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert order: %w", err)
+	}
+
+	err = insertOrderStatusChange(tx, OrderStatusChange{OrderID: c.OrderID, ToStatus: c.Status, ChangedAt: time.Now()})
+	if err != nil {
+		return 0, err
+	}
+
+	return c.OrderID, tx.Commit()
+}
+
+func (r *PostgresOrderRepository) UpdateOrderItems(c CustomerOrder) error {
+	// Convert OrderItems struct to JSON string
+	orderItemsJSON, err := json.Marshal(c.OrderItems)
+	if err != nil {
+		return fmt.Errorf("failed to marshal orderItems: %w", err)
+	}
+
+	discountsJSON, err := json.Marshal(c.Discounts)
+	if err != nil {
+		return fmt.Errorf("failed to marshal discounts: %w", err)
+	}
+
+	taxesJSON, err := json.Marshal(c.Taxes)
+	if err != nil {
+		return fmt.Errorf("failed to marshal taxes: %w", err)
+	}
+
+	// Prepare an SQL statement to update the order, the lifecycle columns belong to TransitionOrderStatus
+	total := NewMoney(c.OrderTotal.Minor, c.OrderTotal.Currency)
+	promoCode := sql.NullString{String: c.PromoCode, Valid: c.PromoCode != ""}
+	d := newDeliveryColumns(c)
+	queryString := `UPDATE CustomerOrder SET cellnumber = $1, catalogueID = $2, orderitems = $3, ordertotal = $4, ordercurrency = $5, promocode = $6, discounts = $7,
+                    fulfilment = $8, addressid = $9, deliveryaddress = $10, deliveryarea = $11, deliverypostalcode = $12,
+                    deliverylatitude = $13, deliverylongitude = $14, deliveryfee = $15,
+                    taxes = $16, taxincluded = $17 WHERE orderid = $18`
+	_, err = r.DB.Exec(queryString, c.CellNumber, c.CatalogueID, orderItemsJSON, total, total.Currency, promoCode, string(discountsJSON),
+		d.fulfilment, d.addressID, d.address, d.area, d.postalCode, d.latitude, d.longitude, d.fee,
+		string(taxesJSON), c.TaxIncluded, c.OrderID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// The delivery columns of an order, unset ones are stored as NULL.
+type deliveryColumns struct {
+	fulfilment                sql.NullString
+	addressID                 sql.NullInt64
+	address, area, postalCode sql.NullString
+	latitude, longitude       sql.NullFloat64
+	fee                       Money
+}
+
+func newDeliveryColumns(c CustomerOrder) deliveryColumns {
+	d := deliveryColumns{
+		fulfilment: sql.NullString{String: string(c.Fulfilment), Valid: c.Fulfilment != ""},
+		addressID:  sql.NullInt64{Int64: int64(c.DeliveryLocation.AddressID), Valid: c.DeliveryLocation.AddressID != 0},
+		address:    sql.NullString{String: c.DeliveryLocation.Address, Valid: c.DeliveryLocation.Address != ""},
+		area:       sql.NullString{String: c.DeliveryLocation.Area, Valid: c.DeliveryLocation.Area != ""},
+		postalCode: sql.NullString{String: c.DeliveryLocation.PostalCode, Valid: c.DeliveryLocation.PostalCode != ""},
+		fee:        NewMoney(c.DeliveryFee.Minor, c.DeliveryFee.Currency),
+	}
+	if c.DeliveryLocation.Point != nil {
+		d.latitude = sql.NullFloat64{Float64: c.DeliveryLocation.Point.Latitude, Valid: true}
+		d.longitude = sql.NullFloat64{Float64: c.DeliveryLocation.Point.Longitude, Valid: true}
+	}
+	return d
+}
+
+func (r *PostgresOrderRepository) SetOrderTotal(orderID int, total Money) error {
+	_, err := r.DB.Exec(`UPDATE CustomerOrder SET ordertotal = $1, ordercurrency = $2 WHERE orderid = $3`, total, total.Currency, orderID)
+	if err != nil {
+		return fmt.Errorf("failed to set order total: %w", err)
+	}
+	return nil
+}
+
+// The row is locked for the duration so concurrent notifications can't race each other.
+func (r *PostgresOrderRepository) ConfirmPayment(orderID int, amountPaid Money, at time.Time) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var orderTotal sql.NullString
+	var currency sql.NullString
+	var status OrderStatus
+	err = tx.QueryRow(`SELECT ordertotal, ordercurrency, status FROM customerorder WHERE orderid = $1 FOR UPDATE`, orderID).Scan(&orderTotal, &currency, &status)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNoRows
+		}
+		return err
+	}
+
+	var expected *Money
+	if orderTotal.Valid {
+		total, err := ParseMoney(orderTotal.String, currency.String)
+		if err != nil {
+			return fmt.Errorf("error parsing stored order total: %v", err)
+		}
+		expected = &total
+	}
+
+	alreadyPaid, err := checkPayment(orderID, status, expected, amountPaid)
+	if err != nil || alreadyPaid {
+		return err
+	}
+
+	err = transitionOrderStatusTx(tx, orderID, OrderPaid, at)
+	if err != nil {
+		return fmt.Errorf("failed to mark order as paid: %w", err)
 	}
 
-	cartTotal, cartSummary := c.OrderItems.CalculatePrice(ctlgselections)
-	return cartTotal, cartSummary, nil
+	return tx.Commit()
 }
@@ -0,0 +1,95 @@
+package menubotlib
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// PostgresPromotionRepository is the PromotionRepository backed by the promotion table.
+type PostgresPromotionRepository struct {
+	DB *sql.DB
+}
+
+const promotionColumns = `code, description, kind, automatic, percent, amount, currency, buyquantity, freequantity, "selection", startsat, expiresat, maxusespercustomer`
+
+func scanPromotion(row interface{ Scan(...interface{}) error }) (Promotion, error) {
+	var p Promotion
+	var amount string
+	var currency string
+	var selection sql.NullString
+	var startsAt, expiresAt sql.NullTime
+	err := row.Scan(&p.Code, &p.Description, &p.Kind, &p.Automatic, &p.Percent, &amount, &currency,
+		&p.BuyQuantity, &p.FreeQuantity, &selection, &startsAt, &expiresAt, &p.MaxUsesPerCustomer)
+	if err != nil {
+		return p, err
+	}
+	p.Amount, err = ParseMoney(amount, currency)
+	if err != nil {
+		return p, fmt.Errorf("failed to parse promotion amount: %w", err)
+	}
+	p.Selection = selection.String
+	p.StartsAt = startsAt.Time
+	p.ExpiresAt = expiresAt.Time
+	return p, nil
+}
+
+func (r *PostgresPromotionRepository) GetPromotion(code string) (Promotion, error) {
+	row := r.DB.QueryRow(`SELECT `+promotionColumns+` FROM promotion WHERE code = $1`, NormalisePromoCode(code))
+	p, err := scanPromotion(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return p, ErrNoRows
+		}
+		return p, err
+	}
+	return p, nil
+}
+
+func (r *PostgresPromotionRepository) ActivePromotions(at time.Time) ([]Promotion, error) {
+	queryString := `SELECT ` + promotionColumns + ` FROM promotion
+		WHERE (startsat IS NULL OR startsat <= $1) AND (expiresat IS NULL OR expiresat > $1) ORDER BY code`
+	rows, err := r.DB.Query(queryString, at)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var promotions []Promotion
+	for rows.Next() {
+		p, err := scanPromotion(rows)
+		if err != nil {
+			return nil, err
+		}
+		promotions = append(promotions, p)
+	}
+	return promotions, rows.Err()
+}
+
+func (r *PostgresPromotionRepository) SavePromotion(p Promotion) error {
+	nullTime := func(t time.Time) sql.NullTime {
+		return sql.NullTime{Time: t, Valid: !t.IsZero()}
+	}
+	amount := NewMoney(p.Amount.Minor, p.Amount.Currency)
+
+	queryString := `INSERT INTO promotion (` + promotionColumns + `) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		ON CONFLICT (code) DO UPDATE SET description = EXCLUDED.description, kind = EXCLUDED.kind, automatic = EXCLUDED.automatic,
+		percent = EXCLUDED.percent, amount = EXCLUDED.amount, currency = EXCLUDED.currency, buyquantity = EXCLUDED.buyquantity,
+		freequantity = EXCLUDED.freequantity, "selection" = EXCLUDED."selection", startsat = EXCLUDED.startsat,
+		expiresat = EXCLUDED.expiresat, maxusespercustomer = EXCLUDED.maxusespercustomer`
+	_, err := r.DB.Exec(queryString, NormalisePromoCode(p.Code), p.Description, p.Kind, p.Automatic, p.Percent, amount, amount.Currency,
+		p.BuyQuantity, p.FreeQuantity, sql.NullString{String: p.Selection, Valid: p.Selection != ""}, nullTime(p.StartsAt), nullTime(p.ExpiresAt), p.MaxUsesPerCustomer)
+	if err != nil {
+		return fmt.Errorf("failed to save promotion: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresOrderRepository) CountPromoCodeUses(cellNumber, code string) (int, error) {
+	var uses int
+	// Automatic promotions aren't applied as a code, they only show in the order's discounts
+	queryString := `SELECT COUNT(*) FROM customerorder WHERE cellnumber = $1 AND ispaid = true
+		AND (promocode = $2 OR discounts::jsonb @> jsonb_build_array(jsonb_build_object('Code', $2::text)))`
+	err := r.DB.QueryRow(queryString, cellNumber, NormalisePromoCode(code)).Scan(&uses)
+	return uses, err
+}
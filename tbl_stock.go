@@ -0,0 +1,147 @@
+package menubotlib
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// PostgresStockRepository is the StockRepository backed by the stock and stockreservation tables.
+type PostgresStockRepository struct {
+	DB *sql.DB
+}
+
+func (r *PostgresStockRepository) GetStockLevels(catalogueID string) ([]StockLevel, error) {
+	queryString := `SELECT s.catalogueid, s.catalogueitemid, s."option", s.onhand,
+		COALESCE((SELECT SUM(quantity) FROM stockreservation sr
+			WHERE sr.catalogueid = s.catalogueid AND sr.catalogueitemid = s.catalogueitemid AND sr."option" = s."option" AND sr.expiresat > $2), 0)
+		FROM stock s WHERE s.catalogueid = $1 ORDER BY s.catalogueitemid, s."option"`
+	rows, err := r.DB.Query(queryString, catalogueID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var levels []StockLevel
+	for rows.Next() {
+		var level StockLevel
+		err := rows.Scan(&level.CatalogueID, &level.CatalogueItemID, &level.Option, &level.OnHand, &level.Reserved)
+		if err != nil {
+			return nil, err
+		}
+		levels = append(levels, level)
+	}
+	return levels, rows.Err()
+}
+
+func (r *PostgresStockRepository) SetStockOnHand(key StockKey, onHand int) error {
+	queryString := `INSERT INTO stock (catalogueid, catalogueitemid, "option", onhand) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (catalogueid, catalogueitemid, "option") DO UPDATE SET onhand = EXCLUDED.onhand`
+	_, err := r.DB.Exec(queryString, key.CatalogueID, key.CatalogueItemID, key.Option, onHand)
+	if err != nil {
+		return fmt.Errorf("failed to set stock on hand: %w", err)
+	}
+	return nil
+}
+
+// The stock rows are locked for the duration so two checkouts can't both take the last one.
+func (r *PostgresStockRepository) ReserveStock(orderID int, quantities []StockQuantity, expiresAt time.Time) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = releaseStockTx(tx, orderID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	levels := make(map[StockKey]StockLevel)
+	for _, q := range quantities {
+		level := StockLevel{StockKey: q.StockKey}
+		queryString := `SELECT onhand FROM stock WHERE catalogueid = $1 AND catalogueitemid = $2 AND "option" = $3 FOR UPDATE`
+		err := tx.QueryRow(queryString, q.CatalogueID, q.CatalogueItemID, q.Option).Scan(&level.OnHand)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		queryString = `SELECT COALESCE(SUM(quantity), 0) FROM stockreservation
+			WHERE catalogueid = $1 AND catalogueitemid = $2 AND "option" = $3 AND expiresat > $4`
+		err = tx.QueryRow(queryString, q.CatalogueID, q.CatalogueItemID, q.Option, now).Scan(&level.Reserved)
+		if err != nil {
+			return err
+		}
+		levels[q.StockKey] = level
+	}
+
+	short := stockShortages(quantities, levels)
+	if len(short) > 0 {
+		return &OutOfStockError{Shortages: short}
+	}
+
+	for _, q := range quantities {
+		queryString := `INSERT INTO stockreservation (orderid, catalogueid, catalogueitemid, "option", quantity, expiresat) VALUES ($1, $2, $3, $4, $5, $6)`
+		_, err := tx.Exec(queryString, orderID, q.CatalogueID, q.CatalogueItemID, q.Option, q.Quantity, expiresAt)
+		if err != nil {
+			return fmt.Errorf("failed to reserve stock: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *PostgresStockRepository) ReleaseStock(orderID int) error {
+	_, err := r.DB.Exec(`DELETE FROM stockreservation WHERE orderid = $1`, orderID)
+	if err != nil {
+		return fmt.Errorf("failed to release stock: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresStockRepository) CommitStock(orderID int) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = commitStockTx(tx, orderID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func releaseStockTx(tx *sql.Tx, orderID int) error {
+	_, err := tx.Exec(`DELETE FROM stockreservation WHERE orderid = $1`, orderID)
+	if err != nil {
+		return fmt.Errorf("failed to release stock: %w", err)
+	}
+	return nil
+}
+
+// Expired reservations are taken off too, the customer paid for them after all.
+func commitStockTx(tx *sql.Tx, orderID int) error {
+	queryString := `UPDATE stock s SET onhand = s.onhand - sr.quantity FROM stockreservation sr
+		WHERE sr.orderid = $1 AND sr.catalogueid = s.catalogueid AND sr.catalogueitemid = s.catalogueitemid AND sr."option" = s."option"`
+	_, err := tx.Exec(queryString, orderID)
+	if err != nil {
+		return fmt.Errorf("failed to take stock: %w", err)
+	}
+	return releaseStockTx(tx, orderID)
+}
+
+// The stock side of an order status change, run in the same transaction as the change itself.
+func settleOrderStockTx(tx *sql.Tx, orderID int, to OrderStatus) error {
+	switch to {
+	case OrderDraft, OrderCancelled:
+		return releaseStockTx(tx, orderID)
+	case OrderPaid:
+		return commitStockTx(tx, orderID)
+	}
+	return nil
+}
@@ -42,15 +42,15 @@ type UserInfo struct {
 }
 
 // NewUserInfo creates a new UserInfo object and returns it and whether the user previously existed or not.
-func NewUserInfo(db *sql.DB, senderNumber string, isAutoInc bool) (UserInfo, CustomerOrder, bool) {
+func NewUserInfo(store Store, senderNumber string, isAutoInc bool) (UserInfo, CustomerOrder, bool) {
 	var cO CustomerOrder
 	uI := UserInfo{CellNumber: senderNumber}
-	
-	err := uI.SetUserInfoFromDB(db)
+
+	err := uI.SetUserInfoFromDB(store.Users)
 	if err != nil {
 		if strings.Contains(err.Error(), "no rows") {
 			uI.DateTimeJoined = sql.NullTime{Time: time.Now(), Valid: true}
-			err := uI.InsertNewUserInfoWithOnlyCellNum(db)
+			err := uI.InsertNewUserInfoWithOnlyCellNum(store.Users)
 			if err != nil {
 				log.Println("failed to insert user: " + senderNumber + "\n" + err.Error())
 			}
@@ -60,7 +60,7 @@ func NewUserInfo(db *sql.DB, senderNumber string, isAutoInc bool) (UserInfo, Cus
 			return uI, cO, false
 		}
 	}
-	err = cO.SetCurrentOrderFromDB(db, senderNumber, isAutoInc)
+	err = cO.SetCurrentOrderFromDB(store.Orders, senderNumber, isAutoInc)
 	if err != nil {
 		log.Println("failed to set order for " + senderNumber + "\n" + err.Error())
 		return uI, cO, true
@@ -83,22 +83,64 @@ Consent: %s
 	return info
 }
 
-// We need a general Get UserInfo function the below reflects the code not having a ORM.
-// Get User Info from database
-func (c *UserInfo) SetUserInfoFromDB(db *sql.DB) error {
-	queryString := `SELECT nickname, email, socialmedia, consent, datetimejoined FROM userinfo WHERE cellnumber = $1`
-	err := db.QueryRow(queryString, c.CellNumber).Scan(&c.NickName, &c.Email, &c.SocialMedia, &c.Consent, &c.DateTimeJoined)
+// Get User Info from the user repository
+func (c *UserInfo) SetUserInfoFromDB(users UserRepository) error {
+	ui, err := users.GetUserInfo(c.CellNumber)
+	if err != nil {
+		return err
+	}
+	*c = ui
+	return nil
+}
+
+// Insert new user into the user repository
+func (c *UserInfo) InsertNewUserInfoWithOnlyCellNum(users UserRepository) error {
+	return users.InsertUserInfo(UserInfo{CellNumber: c.CellNumber, DateTimeJoined: c.DateTimeJoined})
+}
+
+// Update User field in the user repository, the field has to be in the whitelist and the value
+// has to pass its validation. c is updated too on success.
+func (c *UserInfo) UpdateSingularUserInfoField(users UserRepository, updateCol, newValue string) error {
+	field, err := LookupUserInfoField(updateCol)
+	if err != nil {
+		return err
+	}
+	value, err := field.Parse(newValue)
+	if err != nil {
+		return err
+	}
+
+	err = users.UpdateUserInfoField(c.CellNumber, field.Column, value)
 	if err != nil {
 		return err
 	}
+	field.set(c, value)
 	return nil
 }
 
-// Insert new user into database
-func (c *UserInfo) InsertNewUserInfoWithOnlyCellNum(db *sql.DB) error {
+// PostgresUserRepository is the UserRepository backed by the userinfo table.
+type PostgresUserRepository struct {
+	DB *sql.DB
+}
+
+// We need a general Get UserInfo function the below reflects the code not having a ORM.
+func (r *PostgresUserRepository) GetUserInfo(cellNumber string) (UserInfo, error) {
+	ui := UserInfo{CellNumber: cellNumber}
+	queryString := `SELECT nickname, email, socialmedia, consent, datetimejoined FROM userinfo WHERE cellnumber = $1`
+	err := r.DB.QueryRow(queryString, cellNumber).Scan(&ui.NickName, &ui.Email, &ui.SocialMedia, &ui.Consent, &ui.DateTimeJoined)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ui, ErrNoRows
+		}
+		return ui, err
+	}
+	return ui, nil
+}
+
+func (r *PostgresUserRepository) InsertUserInfo(ui UserInfo) error {
 	// Prepare an SQL statement to insert a new user
 	queryString := `INSERT INTO userinfo (cellnumber, datetimejoined) VALUES ($1, $2)`
-	_, err := db.Exec(queryString, c.CellNumber, c.DateTimeJoined)
+	_, err := r.DB.Exec(queryString, ui.CellNumber, ui.DateTimeJoined)
 	if err != nil {
 		if strings.Contains(err.Error(), "duplicate key value violates unique constraint") {
 			return fmt.Errorf("error:11, user already exists")
@@ -108,11 +150,14 @@ func (c *UserInfo) InsertNewUserInfoWithOnlyCellNum(db *sql.DB) error {
 	return nil
 }
 
-// Update User field in database
-func (c *UserInfo) UpdateSingularUserInfoField(db *sql.DB, updateCol, newValue string) error {
-	// Prepare an SQL statement to update the field
-	queryString := fmt.Sprintf(`UPDATE userinfo SET %s = $1 WHERE cellnumber = $2`, updateCol)
-	_, err := db.Exec(queryString, newValue, c.CellNumber)
+func (r *PostgresUserRepository) UpdateUserInfoField(cellNumber, updateCol, newValue string) error {
+	// Only a whitelisted column name ever ends up in the query
+	field, err := LookupUserInfoField(updateCol)
+	if err != nil {
+		return err
+	}
+	queryString := fmt.Sprintf(`UPDATE userinfo SET %s = $1 WHERE cellnumber = $2`, field.Column)
+	_, err = r.DB.Exec(queryString, newValue, cellNumber)
 	if err != nil {
 		return err
 	}
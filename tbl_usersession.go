@@ -0,0 +1,62 @@
+package menubotlib
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// PostgresSessionRepository is the SessionRepository backed by the usersession table.
+type PostgresSessionRepository struct {
+	DB *sql.DB
+}
+
+func (r *PostgresSessionRepository) GetSession(cellNumber string) (Session, error) {
+	session := Session{CellNumber: cellNumber}
+	var data string
+	queryString := `SELECT flow, step, data, expiresat, updatedat FROM usersession WHERE cellnumber = $1`
+	err := r.DB.QueryRow(queryString, cellNumber).Scan(&session.Flow, &session.Step, &data, &session.ExpiresAt, &session.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return session, ErrNoRows
+		}
+		return session, err
+	}
+
+	err = json.Unmarshal([]byte(data), &session.Data)
+	if err != nil {
+		return session, fmt.Errorf("failed to unmarshal session data: %w", err)
+	}
+	return session, nil
+}
+
+func (r *PostgresSessionRepository) SaveSession(session Session) error {
+	data, err := json.Marshal(session.Data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal session data: %w", err)
+	}
+
+	queryString := `INSERT INTO usersession (cellnumber, flow, step, data, expiresat, updatedat) VALUES ($1, $2, $3, $4, $5, $6)
+	ON CONFLICT (cellnumber) DO UPDATE SET flow = EXCLUDED.flow, step = EXCLUDED.step, data = EXCLUDED.data,
+	expiresat = EXCLUDED.expiresat, updatedat = EXCLUDED.updatedat`
+	_, err = r.DB.Exec(queryString, session.CellNumber, session.Flow, session.Step, string(data), session.ExpiresAt, session.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresSessionRepository) DeleteSession(cellNumber string) error {
+	_, err := r.DB.Exec(`DELETE FROM usersession WHERE cellnumber = $1`, cellNumber)
+	return err
+}
+
+func (r *PostgresSessionRepository) DeleteExpiredSessions(before time.Time) (int, error) {
+	result, err := r.DB.Exec(`DELETE FROM usersession WHERE expiresat < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := result.RowsAffected()
+	return int(deleted), err
+}
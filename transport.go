@@ -0,0 +1,93 @@
+package menubotlib
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrTransportClosed = errors.New("transport closed")
+
+type MediaKind string
+
+const (
+	MediaImage    MediaKind = "image"
+	MediaAudio    MediaKind = "audio"
+	MediaVideo    MediaKind = "video"
+	MediaDocument MediaKind = "document"
+	MediaSticker  MediaKind = "sticker"
+)
+
+// Media is an attachment, transports fill in whichever of ID or URL their platform uses.
+type Media struct {
+	Kind     MediaKind
+	ID       string
+	URL      string
+	MIMEType string
+	Caption  string
+}
+
+// SharedLocation is a location pin the customer sent, platforms add a name and address for pins of a place.
+type SharedLocation struct {
+	Latitude  float64
+	Longitude float64
+	Name      string
+	Address   string
+}
+
+// DeliveryStatus is a platform receipt for a message we sent (sent, delivered, read, failed).
+type DeliveryStatus struct {
+	MessageID string
+	Recipient string
+	Status    string
+	Timestamp time.Time
+	Error     string
+}
+
+// InboundMessage is a customer message as received from any chat platform.
+// Delivery receipts arrive as messages with only Status set.
+type InboundMessage struct {
+	Sender    string
+	MessageID string
+	Timestamp time.Time
+	Text      string
+	Media     []Media
+	Location  *SharedLocation
+	Status    *DeliveryStatus
+}
+
+type ReplyButton struct {
+	ID    string
+	Title string
+}
+
+// OutboundMessage is a reply to a customer. Transports that can't show buttons
+// are expected to fall back to the plain text.
+type OutboundMessage struct {
+	Recipient string
+	Text      string
+	// ReplyTo is the ID of the message being answered, if the platform can quote it.
+	ReplyTo string
+	Buttons []ReplyButton
+	Media   []Media
+}
+
+// Transport connects the bot to a chat platform.
+type Transport interface {
+	// Receive blocks until the next inbound message arrives, ctx is done or the transport is closed.
+	Receive(ctx context.Context) (InboundMessage, error)
+	Send(ctx context.Context, msg OutboundMessage) error
+}
+
+// The text the commands are parsed from, a captioned image counts as text.
+func (m InboundMessage) Body() string {
+	if m.Text != "" {
+		return m.Text
+	}
+	for _, media := range m.Media {
+		if media.Caption != "" {
+			return media.Caption
+		}
+	}
+	return ""
+}
@@ -0,0 +1,77 @@
+package menubotlib
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryTransport is a Transport that keeps everything in process, inbound messages
+// are queued with Deliver and replies are collected for inspection with Sent.
+type MemoryTransport struct {
+	inbound   chan InboundMessage
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	mu   sync.Mutex
+	sent []OutboundMessage
+}
+
+func NewMemoryTransport(buffer int) *MemoryTransport {
+	return &MemoryTransport{
+		inbound: make(chan InboundMessage, buffer),
+		closed:  make(chan struct{}),
+	}
+}
+
+// Deliver queues a message as if it had arrived from a customer.
+func (t *MemoryTransport) Deliver(ctx context.Context, msg InboundMessage) error {
+	select {
+	case <-t.closed:
+		return ErrTransportClosed
+	default:
+	}
+
+	select {
+	case t.inbound <- msg:
+		return nil
+	case <-t.closed:
+		return ErrTransportClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *MemoryTransport) Receive(ctx context.Context) (InboundMessage, error) {
+	select {
+	case msg := <-t.inbound:
+		return msg, nil
+	case <-t.closed:
+		return InboundMessage{}, ErrTransportClosed
+	case <-ctx.Done():
+		return InboundMessage{}, ctx.Err()
+	}
+}
+
+func (t *MemoryTransport) Send(ctx context.Context, msg OutboundMessage) error {
+	select {
+	case <-t.closed:
+		return ErrTransportClosed
+	default:
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sent = append(t.sent, msg)
+	return nil
+}
+
+// Sent returns a copy of every reply sent so far, oldest first.
+func (t *MemoryTransport) Sent() []OutboundMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]OutboundMessage(nil), t.sent...)
+}
+
+func (t *MemoryTransport) Close() {
+	t.closeOnce.Do(func() { close(t.closed) })
+}
@@ -0,0 +1,207 @@
+// Package whatsapp connects MenuBotLib to the WhatsApp Cloud API: it serves the
+// webhook Meta calls with customer messages and sends replies through the Graph API.
+package whatsapp
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	menubotlib "github.com/JeremyJalpha/MenuBotLib"
+)
+
+const (
+	DefaultGraphURL   = "https://graph.facebook.com"
+	DefaultAPIVersion = "v19.0"
+
+	signatureHeader = "X-Hub-Signature-256"
+	maxWebhookBytes = 1 << 20
+	inboundBuffer   = 64
+	// How many message IDs are remembered to drop webhook redeliveries.
+	seenMessages = 1024
+)
+
+type Config struct {
+	// VerifyToken is the token entered when subscribing the webhook in the Meta dashboard.
+	VerifyToken string
+	// AppSecret signs every webhook POST in the X-Hub-Signature-256 header.
+	AppSecret     string
+	AccessToken   string
+	PhoneNumberID string
+	// GraphURL and APIVersion default to the public Graph API.
+	GraphURL   string
+	APIVersion string
+}
+
+// Adapter is both the webhook http.Handler and a menubotlib.Transport,
+// messages received on the webhook are handed out by Receive.
+type Adapter struct {
+	cfg    Config
+	client *http.Client
+
+	inbound   chan menubotlib.InboundMessage
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	mu       sync.Mutex
+	seen     map[string]bool
+	seenRing []string
+}
+
+var _ menubotlib.Transport = (*Adapter)(nil)
+
+func NewAdapter(cfg Config, client *http.Client) *Adapter {
+	if cfg.GraphURL == "" {
+		cfg.GraphURL = DefaultGraphURL
+	}
+	if cfg.APIVersion == "" {
+		cfg.APIVersion = DefaultAPIVersion
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Adapter{
+		cfg:     cfg,
+		client:  client,
+		inbound: make(chan menubotlib.InboundMessage, inboundBuffer),
+		closed:  make(chan struct{}),
+		seen:    make(map[string]bool),
+	}
+}
+
+func (a *Adapter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		a.verifySubscription(w, r)
+	case http.MethodPost:
+		a.receiveWebhook(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Meta subscribes the webhook with a GET carrying hub.mode, hub.verify_token and
+// hub.challenge, the challenge has to be echoed back when the token matches.
+func (a *Adapter) verifySubscription(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	token := query.Get("hub.verify_token")
+	if query.Get("hub.mode") != "subscribe" || a.cfg.VerifyToken == "" ||
+		!hmac.Equal([]byte(token), []byte(a.cfg.VerifyToken)) {
+		http.Error(w, "verification failed", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, query.Get("hub.challenge"))
+}
+
+func (a *Adapter) receiveWebhook(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBytes))
+	if err != nil {
+		http.Error(w, "unable to read webhook", http.StatusBadRequest)
+		return
+	}
+
+	if !a.validSignature(body, r.Header.Get(signatureHeader)) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	messages, err := DecodeWebhook(body)
+	if err != nil {
+		log.Printf("error decoding whatsapp webhook: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for _, msg := range messages {
+		if msg.Status == nil && a.alreadySeen(msg.MessageID) {
+			continue
+		}
+		select {
+		case a.inbound <- msg:
+		case <-a.closed:
+			a.forget(msg.MessageID)
+			http.Error(w, "adapter closed", http.StatusServiceUnavailable)
+			return
+		case <-r.Context().Done():
+			// Meta retries anything that isn't answered with a 200
+			a.forget(msg.MessageID)
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// The header is "sha256=" followed by the hex HMAC-SHA256 of the raw body keyed with the app secret.
+func (a *Adapter) validSignature(body []byte, header string) bool {
+	if a.cfg.AppSecret == "" {
+		log.Println("whatsapp adapter has no app secret, rejecting webhook")
+		return false
+	}
+	signature, ok := strings.CutPrefix(header, "sha256=")
+	if !ok {
+		return false
+	}
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(a.cfg.AppSecret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// Webhooks are delivered at least once, remember recent message IDs to answer each only once.
+func (a *Adapter) alreadySeen(messageID string) bool {
+	if messageID == "" {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.seen[messageID] {
+		return true
+	}
+	a.seen[messageID] = true
+	a.seenRing = append(a.seenRing, messageID)
+	if len(a.seenRing) > seenMessages {
+		delete(a.seen, a.seenRing[0])
+		a.seenRing = a.seenRing[1:]
+	}
+	return false
+}
+
+// A message that couldn't be queued has to be accepted again on redelivery.
+func (a *Adapter) forget(messageID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.seen, messageID)
+}
+
+func (a *Adapter) Receive(ctx context.Context) (menubotlib.InboundMessage, error) {
+	select {
+	case msg := <-a.inbound:
+		return msg, nil
+	case <-a.closed:
+		return menubotlib.InboundMessage{}, menubotlib.ErrTransportClosed
+	case <-ctx.Done():
+		return menubotlib.InboundMessage{}, ctx.Err()
+	}
+}
+
+// Close stops Receive, webhooks arriving afterwards are answered with 503 so Meta retries them.
+func (a *Adapter) Close() {
+	a.closeOnce.Do(func() { close(a.closed) })
+}
@@ -0,0 +1,205 @@
+package whatsapp
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	menubotlib "github.com/JeremyJalpha/MenuBotLib"
+)
+
+const (
+	testVerifyToken = "menubot-verify"
+	testAppSecret   = "app-secret"
+)
+
+const textWebhook = `{"object":"whatsapp_business_account","entry":[{"id":"1","changes":[{"field":"messages","value":{
+	"messaging_product":"whatsapp",
+	"messages":[{"from":"27820000000","id":"wamid.text","timestamp":"1700000000","type":"text","text":{"body":"menu?"}}]}}]}]}`
+
+const locationWebhook = `{"object":"whatsapp_business_account","entry":[{"id":"1","changes":[{"field":"messages","value":{
+	"messaging_product":"whatsapp",
+	"messages":[{"from":"27820000000","id":"wamid.pin","timestamp":"1700000000","type":"location",
+		"location":{"latitude":-33.92,"longitude":18.42,"name":"Home","address":"12 Main Rd"}}]}}]}]}`
+
+func sign(body string) string {
+	mac := hmac.New(sha256.New, []byte(testAppSecret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func postWebhook(a *Adapter, body, signature string) int {
+	req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body))
+	if signature != "" {
+		req.Header.Set(signatureHeader, signature)
+	}
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func receive(t *testing.T, a *Adapter) (menubotlib.InboundMessage, bool) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	msg, err := a.Receive(ctx)
+	return msg, err == nil
+}
+
+func TestVerifySubscription(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		wantCode int
+		wantBody string
+	}{
+		{"matching token", "hub.mode=subscribe&hub.verify_token=" + testVerifyToken + "&hub.challenge=1158201444", http.StatusOK, "1158201444"},
+		{"wrong token", "hub.mode=subscribe&hub.verify_token=guess&hub.challenge=1158201444", http.StatusForbidden, ""},
+		{"wrong mode", "hub.mode=unsubscribe&hub.verify_token=" + testVerifyToken + "&hub.challenge=1158201444", http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAdapter(Config{VerifyToken: testVerifyToken, AppSecret: testAppSecret}, nil)
+			rec := httptest.NewRecorder()
+			a.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/webhook?"+tt.query, nil))
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("status code = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	tests := []struct {
+		name        string
+		signature   string
+		wantCode    int
+		wantMessage bool
+	}{
+		{"valid signature", sign(textWebhook), http.StatusOK, true},
+		{"signed with another secret", "sha256=" + strings.Repeat("ab", sha256.Size), http.StatusUnauthorized, false},
+		{"not hex", "sha256=zz", http.StatusUnauthorized, false},
+		{"missing header", "", http.StatusUnauthorized, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAdapter(Config{VerifyToken: testVerifyToken, AppSecret: testAppSecret}, nil)
+			if code := postWebhook(a, textWebhook, tt.signature); code != tt.wantCode {
+				t.Errorf("status code = %d, want %d", code, tt.wantCode)
+			}
+			if _, ok := receive(t, a); ok != tt.wantMessage {
+				t.Errorf("message received = %t, want %t", ok, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestWebhookRedeliveryIsDropped(t *testing.T) {
+	a := NewAdapter(Config{AppSecret: testAppSecret}, nil)
+	for i := 0; i < 2; i++ {
+		if code := postWebhook(a, textWebhook, sign(textWebhook)); code != http.StatusOK {
+			t.Fatalf("delivery %d: status code = %d, want %d", i+1, code, http.StatusOK)
+		}
+	}
+	if _, ok := receive(t, a); !ok {
+		t.Fatal("the first delivery was not received")
+	}
+	if msg, ok := receive(t, a); ok {
+		t.Errorf("the redelivery was received again: %+v", msg)
+	}
+}
+
+func TestDecodeWebhook(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want menubotlib.InboundMessage
+	}{
+		{"text", textWebhook, menubotlib.InboundMessage{Sender: "27820000000", MessageID: "wamid.text", Text: "menu?"}},
+		{"location", locationWebhook, menubotlib.InboundMessage{Sender: "27820000000", MessageID: "wamid.pin",
+			Location: &menubotlib.SharedLocation{Latitude: -33.92, Longitude: 18.42, Name: "Home", Address: "12 Main Rd"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, err := DecodeWebhook([]byte(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(messages) != 1 {
+				t.Fatalf("decoded %d messages, want 1", len(messages))
+			}
+			got := messages[0]
+			if got.Sender != tt.want.Sender || got.MessageID != tt.want.MessageID || got.Text != tt.want.Text {
+				t.Errorf("decoded %+v, want %+v", got, tt.want)
+			}
+			if !got.Timestamp.Equal(time.Unix(1700000000, 0)) {
+				t.Errorf("timestamp = %v, want %v", got.Timestamp, time.Unix(1700000000, 0))
+			}
+			if (got.Location == nil) != (tt.want.Location == nil) || (got.Location != nil && *got.Location != *tt.want.Location) {
+				t.Errorf("location = %+v, want %+v", got.Location, tt.want.Location)
+			}
+		})
+	}
+
+	if _, err := DecodeWebhook([]byte(`{"object":"page"}`)); err == nil {
+		t.Error("a payload for another object was decoded")
+	}
+}
+
+func TestSend(t *testing.T) {
+	var got sendRequest
+	var path, auth string
+	graph := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		auth = r.Header.Get("Authorization")
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &got)
+		w.Write([]byte(`{"messages":[{"id":"wamid.reply"}]}`))
+	}))
+	defer graph.Close()
+
+	a := NewAdapter(Config{AccessToken: "token", PhoneNumberID: "1234", GraphURL: graph.URL}, graph.Client())
+	err := a.Send(context.Background(), menubotlib.OutboundMessage{Recipient: "27820000000", Text: "Main Menu", ReplyTo: "wamid.text"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if path != "/"+DefaultAPIVersion+"/1234/messages" {
+		t.Errorf("posted to %s", path)
+	}
+	if auth != "Bearer token" {
+		t.Errorf("Authorization = %q, want %q", auth, "Bearer token")
+	}
+	if got.To != "27820000000" || got.Type != "text" || got.Text == nil || got.Text.Body != "Main Menu" {
+		t.Errorf("sent %+v", got)
+	}
+	if got.Context == nil || got.Context.MessageID != "wamid.text" {
+		t.Errorf("reply context = %+v, want wamid.text", got.Context)
+	}
+}
+
+func TestSendGraphError(t *testing.T) {
+	graph := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":{"message":"Invalid OAuth access token.","type":"OAuthException","code":190}}`))
+	}))
+	defer graph.Close()
+
+	a := NewAdapter(Config{AccessToken: "expired", PhoneNumberID: "1234", GraphURL: graph.URL}, graph.Client())
+	err := a.Send(context.Background(), menubotlib.OutboundMessage{Recipient: "27820000000", Text: "Main Menu"})
+	if err == nil || !strings.Contains(err.Error(), "Invalid OAuth access token.") {
+		t.Errorf("Send = %v, want the Graph API error", err)
+	}
+}
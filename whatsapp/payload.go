@@ -0,0 +1,233 @@
+package whatsapp
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	menubotlib "github.com/JeremyJalpha/MenuBotLib"
+)
+
+// Webhook payload as sent by the Cloud API, only the fields the bot uses are decoded.
+// https://developers.facebook.com/docs/whatsapp/cloud-api/webhooks/components
+type webhookPayload struct {
+	Object string `json:"object"`
+	Entry  []struct {
+		ID      string `json:"id"`
+		Changes []struct {
+			Field string      `json:"field"`
+			Value changeValue `json:"value"`
+		} `json:"changes"`
+	} `json:"entry"`
+}
+
+type changeValue struct {
+	MessagingProduct string          `json:"messaging_product"`
+	Messages         []webhookMsg    `json:"messages"`
+	Statuses         []webhookStatus `json:"statuses"`
+}
+
+type webhookMsg struct {
+	From      string        `json:"from"`
+	ID        string        `json:"id"`
+	Timestamp string        `json:"timestamp"`
+	Type      string        `json:"type"`
+	Text      *textBody     `json:"text"`
+	Image     *mediaObject  `json:"image"`
+	Audio     *mediaObject  `json:"audio"`
+	Video     *mediaObject  `json:"video"`
+	Document  *mediaObject  `json:"document"`
+	Sticker   *mediaObject  `json:"sticker"`
+	Location  *locationBody `json:"location"`
+	Button    *struct {
+		Text    string `json:"text"`
+		Payload string `json:"payload"`
+	} `json:"button"`
+	Interactive *struct {
+		Type        string     `json:"type"`
+		ButtonReply *replyBody `json:"button_reply"`
+		ListReply   *replyBody `json:"list_reply"`
+	} `json:"interactive"`
+}
+
+type textBody struct {
+	Body string `json:"body"`
+}
+
+type locationBody struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Name      string  `json:"name"`
+	Address   string  `json:"address"`
+}
+
+type replyBody struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+}
+
+type mediaObject struct {
+	ID       string `json:"id"`
+	MimeType string `json:"mime_type"`
+	Caption  string `json:"caption"`
+	Filename string `json:"filename"`
+}
+
+type webhookStatus struct {
+	ID          string `json:"id"`
+	Status      string `json:"status"`
+	Timestamp   string `json:"timestamp"`
+	RecipientID string `json:"recipient_id"`
+	Errors      []struct {
+		Code    int    `json:"code"`
+		Title   string `json:"title"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// DecodeWebhook turns a webhook POST body into inbound messages, delivery receipts
+// included. Message types the bot can't use (reactions, contacts...) are skipped.
+func DecodeWebhook(body []byte) ([]menubotlib.InboundMessage, error) {
+	var payload webhookPayload
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing webhook payload: %v", err)
+	}
+	if payload.Object != "whatsapp_business_account" {
+		return nil, fmt.Errorf("unexpected webhook object: %q", payload.Object)
+	}
+
+	var messages []menubotlib.InboundMessage
+	for _, entry := range payload.Entry {
+		for _, change := range entry.Changes {
+			if change.Field != "messages" {
+				continue
+			}
+			for _, msg := range change.Value.Messages {
+				inbound, ok := msg.toInbound()
+				if ok {
+					messages = append(messages, inbound)
+				}
+			}
+			for _, status := range change.Value.Statuses {
+				messages = append(messages, status.toInbound())
+			}
+		}
+	}
+	return messages, nil
+}
+
+func (m webhookMsg) toInbound() (menubotlib.InboundMessage, bool) {
+	inbound := menubotlib.InboundMessage{
+		Sender:    m.From,
+		MessageID: m.ID,
+		Timestamp: parseTimestamp(m.Timestamp),
+	}
+
+	switch m.Type {
+	case "text":
+		if m.Text == nil {
+			return inbound, false
+		}
+		inbound.Text = m.Text.Body
+	case "button":
+		if m.Button == nil {
+			return inbound, false
+		}
+		inbound.Text = firstNonEmpty(m.Button.Payload, m.Button.Text)
+	case "interactive":
+		if m.Interactive == nil {
+			return inbound, false
+		}
+		// Our buttons carry the command to run as their ID
+		reply := m.Interactive.ButtonReply
+		if reply == nil {
+			reply = m.Interactive.ListReply
+		}
+		if reply == nil {
+			return inbound, false
+		}
+		inbound.Text = firstNonEmpty(reply.ID, reply.Title)
+	case "location":
+		if m.Location == nil {
+			return inbound, false
+		}
+		inbound.Location = &menubotlib.SharedLocation{
+			Latitude:  m.Location.Latitude,
+			Longitude: m.Location.Longitude,
+			Name:      m.Location.Name,
+			Address:   m.Location.Address,
+		}
+	case "image", "audio", "video", "document", "sticker":
+		media := m.media()
+		if media == nil {
+			return inbound, false
+		}
+		inbound.Media = []menubotlib.Media{{
+			Kind:     menubotlib.MediaKind(m.Type),
+			ID:       media.ID,
+			MIMEType: media.MimeType,
+			Caption:  media.Caption,
+		}}
+	default:
+		return inbound, false
+	}
+
+	return inbound, true
+}
+
+func (m webhookMsg) media() *mediaObject {
+	switch m.Type {
+	case "image":
+		return m.Image
+	case "audio":
+		return m.Audio
+	case "video":
+		return m.Video
+	case "document":
+		return m.Document
+	case "sticker":
+		return m.Sticker
+	}
+	return nil
+}
+
+func (s webhookStatus) toInbound() menubotlib.InboundMessage {
+	status := &menubotlib.DeliveryStatus{
+		MessageID: s.ID,
+		Recipient: s.RecipientID,
+		Status:    s.Status,
+		Timestamp: parseTimestamp(s.Timestamp),
+	}
+	var errs []string
+	for _, e := range s.Errors {
+		errs = append(errs, fmt.Sprintf("%d: %s", e.Code, firstNonEmpty(e.Message, e.Title)))
+	}
+	status.Error = strings.Join(errs, "; ")
+
+	return menubotlib.InboundMessage{
+		Sender:    s.RecipientID,
+		Timestamp: status.Timestamp,
+		Status:    status,
+	}
+}
+
+// Timestamps are unix seconds sent as strings.
+func parseTimestamp(ts string) time.Time {
+	seconds, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0).UTC()
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
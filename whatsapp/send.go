@@ -0,0 +1,210 @@
+package whatsapp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	menubotlib "github.com/JeremyJalpha/MenuBotLib"
+)
+
+// Cloud API limits, longer texts are split and oversized button sets fall back to text.
+const (
+	maxTextLength        = 4096
+	maxInteractiveLength = 1024
+	maxButtons           = 3
+	maxButtonTitle       = 20
+)
+
+type sendRequest struct {
+	MessagingProduct string           `json:"messaging_product"`
+	RecipientType    string           `json:"recipient_type"`
+	To               string           `json:"to"`
+	Type             string           `json:"type"`
+	Context          *sendContext     `json:"context,omitempty"`
+	Text             *sendText        `json:"text,omitempty"`
+	Interactive      *sendInteractive `json:"interactive,omitempty"`
+	Image            *sendMedia       `json:"image,omitempty"`
+	Audio            *sendMedia       `json:"audio,omitempty"`
+	Video            *sendMedia       `json:"video,omitempty"`
+	Document         *sendMedia       `json:"document,omitempty"`
+	Sticker          *sendMedia       `json:"sticker,omitempty"`
+}
+
+type sendContext struct {
+	MessageID string `json:"message_id"`
+}
+
+type sendText struct {
+	Body       string `json:"body"`
+	PreviewURL bool   `json:"preview_url"`
+}
+
+type sendInteractive struct {
+	Type string `json:"type"`
+	Body struct {
+		Text string `json:"text"`
+	} `json:"body"`
+	Action struct {
+		Buttons []sendButton `json:"buttons"`
+	} `json:"action"`
+}
+
+type sendButton struct {
+	Type  string    `json:"type"`
+	Reply replyBody `json:"reply"`
+}
+
+type sendMedia struct {
+	ID      string `json:"id,omitempty"`
+	Link    string `json:"link,omitempty"`
+	Caption string `json:"caption,omitempty"`
+}
+
+type graphError struct {
+	Error struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+		Code    int    `json:"code"`
+	} `json:"error"`
+}
+
+// Send delivers a reply through the Graph messages endpoint. Text goes first,
+// followed by one message per media attachment.
+func (a *Adapter) Send(ctx context.Context, msg menubotlib.OutboundMessage) error {
+	requests := buildSendRequests(msg)
+	for _, req := range requests {
+		err := a.post(ctx, req)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func buildSendRequests(msg menubotlib.OutboundMessage) []sendRequest {
+	var requests []sendRequest
+	newRequest := func(kind string) sendRequest {
+		req := sendRequest{MessagingProduct: "whatsapp", RecipientType: "individual", To: msg.Recipient, Type: kind}
+		// Only the first message quotes the customer's message
+		if msg.ReplyTo != "" && len(requests) == 0 {
+			req.Context = &sendContext{MessageID: msg.ReplyTo}
+		}
+		return req
+	}
+
+	if canUseButtons(msg) {
+		req := newRequest("interactive")
+		req.Interactive = &sendInteractive{Type: "button"}
+		req.Interactive.Body.Text = msg.Text
+		for _, button := range msg.Buttons {
+			req.Interactive.Action.Buttons = append(req.Interactive.Action.Buttons, sendButton{
+				Type:  "reply",
+				Reply: replyBody{ID: button.ID, Title: button.Title},
+			})
+		}
+		requests = append(requests, req)
+	} else {
+		text := msg.Text
+		for _, button := range msg.Buttons {
+			text += "\n" + firstNonEmpty(button.ID, button.Title)
+		}
+		for _, chunk := range splitText(text, maxTextLength) {
+			req := newRequest("text")
+			req.Text = &sendText{Body: chunk}
+			requests = append(requests, req)
+		}
+	}
+
+	for _, media := range msg.Media {
+		req := newRequest(string(media.Kind))
+		body := &sendMedia{ID: media.ID, Link: media.URL, Caption: media.Caption}
+		switch media.Kind {
+		case menubotlib.MediaImage:
+			req.Image = body
+		case menubotlib.MediaAudio:
+			req.Audio = body
+		case menubotlib.MediaVideo:
+			req.Video = body
+		case menubotlib.MediaDocument:
+			req.Document = body
+		case menubotlib.MediaSticker:
+			req.Sticker = body
+		default:
+			continue
+		}
+		requests = append(requests, req)
+	}
+
+	return requests
+}
+
+func canUseButtons(msg menubotlib.OutboundMessage) bool {
+	if len(msg.Buttons) == 0 || len(msg.Buttons) > maxButtons {
+		return false
+	}
+	if msg.Text == "" || len(msg.Text) > maxInteractiveLength {
+		return false
+	}
+	for _, button := range msg.Buttons {
+		if button.ID == "" || button.Title == "" || len([]rune(button.Title)) > maxButtonTitle {
+			return false
+		}
+	}
+	return true
+}
+
+// Split on line breaks where possible so menus aren't cut mid line.
+func splitText(text string, limit int) []string {
+	var chunks []string
+	for len(text) > limit {
+		cut := strings.LastIndex(text[:limit], "\n")
+		if cut <= 0 {
+			cut = limit
+			for cut > 0 && !utf8.RuneStart(text[cut]) {
+				cut--
+			}
+		}
+		chunks = append(chunks, text[:cut])
+		text = strings.TrimPrefix(text[cut:], "\n")
+	}
+	return append(chunks, text)
+}
+
+func (a *Adapter) post(ctx context.Context, req sendRequest) error {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("error encoding whatsapp message: %v", err)
+	}
+
+	endpoint := fmt.Sprintf("%s/%s/%s/messages", strings.TrimSuffix(a.cfg.GraphURL, "/"), a.cfg.APIVersion, a.cfg.PhoneNumberID)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+a.cfg.AccessToken)
+
+	resp, err := a.client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("error sending whatsapp message: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	var gErr graphError
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(body, &gErr) == nil && gErr.Error.Message != "" {
+		return fmt.Errorf("whatsapp send failed with %s: %s (code %d)", resp.Status, gErr.Error.Message, gErr.Error.Code)
+	}
+	return fmt.Errorf("whatsapp send failed with %s", resp.Status)
+}
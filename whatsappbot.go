@@ -3,19 +3,16 @@ package menubotlib
 import (
 	"errors"
 	"fmt"
+	"log"
 	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
-
-	"database/sql"
-
-	_ "github.com/lib/pq"
+	"time"
 )
 
 const (
 	custOrderInitState = "Initialized"
-	whatsAppServer     = "s.whatsapp.net"
 
 	sayMenu = "For a command list please type & send-: menu?\nPlease include the question mark."
 
@@ -42,30 +39,31 @@ Should look like-: update order 1:1, 2:2, 3:1`
 
 	deleteOrder = "To remove an item from your order, use-: update order X:0"
 
+	undoOrder = "undo? - Takes back your last change to your order."
+
+	applyCodeCommand = "apply code"
+
+	deliverToCommand = "deliver to: suburb or postal code"
+
+	pickupCommand = "pickup?"
+
+	addAddressCommand = "add address: home, 12 Main Rd, Gardens, 8001"
+
+	useAddressCommand = "use address: home"
+
 	shopComands = "to save your order please type & send-:" + updateOrderCommand + "\n" + UpdateOrderCommExpl +
 		"\n\n" + fullOrderExample +
 		"\n\n" + deleteOrder +
+		"\n" + undoOrder +
 		"\n\n" + "currentorder? - Prints your current pending order." +
 		"\n" + "To checkout type & send-: checkoutnow?"
 
-	queryCommands = `menu? - Prints this menu.
-shop? - Prints the shop price list.
-userinfo? - Prints your user info.`
-
-	updateCommands = `update email: newEmail
-update nickname: newNickname
-update consent: newConsent`
-
-	mainMenu = "Main Menu, command list:" +
-		"\n\n" + queryCommands +
-		"\n\n" + updateCommands
-
 	prclstPreamble = "Welcome to the Shop," +
 		"\n\n" + shopComands
 )
 
 type Command interface {
-	Execute(db *sql.DB, convo *ConversationContext, isAutoInc bool) error
+	Execute(store Store, convo *ConversationContext, isAutoInc bool) CommandResult
 }
 
 type CommandCollection []Command
@@ -79,38 +77,383 @@ type UpdateOrderCommand struct {
 	Text string
 }
 
+type UndoOrderCommand struct{}
+
+type ApplyPromoCodeCommand struct {
+	Code string
+}
+
+type SetFulfilmentCommand struct {
+	Fulfilment Fulfilment
+	Location   string
+}
+
+type AddAddressCommand struct {
+	Text string
+}
+
+type UseAddressCommand struct {
+	Label string
+}
+
+type RemoveAddressCommand struct {
+	Label string
+}
+
+type SharedLocationCommand struct {
+	Location SharedLocation
+}
+
+type OrderHistoryCommand struct{}
+
+type OrderDetailCommand struct {
+	OrderID int
+}
+
+type ReorderCommand struct {
+	OrderID int
+}
+
 type QuestionCommand struct {
 	Name string
 	Text string
 }
 
-func (cmd UpdateUserInfoCommand) Execute(db *sql.DB, convo *ConversationContext, isAutoInc bool) error {
-	var colName = strings.TrimSpace(strings.TrimPrefix(cmd.Name, "update"))
-	err := convo.UserInfo.UpdateSingularUserInfoField(db, colName, cmd.Text)
+type CheckoutCommand struct {
+	CheckoutInfo CheckoutInfo
+}
+
+func (cmd UpdateUserInfoCommand) Execute(store Store, convo *ConversationContext, isAutoInc bool) CommandResult {
+	field, err := LookupUserInfoField(cmd.Name)
 	if err != nil {
-		return fmt.Errorf("unhandled error updating user info: %v", err)
+		return failedResult(CommandInvalidInput, fmt.Sprintf("%s can't be updated.\n\n%s", cmd.Name, sayMenu), err)
+	}
+	err = convo.UserInfo.UpdateSingularUserInfoField(store.Users, field.Column, cmd.Text)
+	if err != nil {
+		var fieldErr *UserInfoFieldError
+		if errors.As(err, &fieldErr) {
+			return failedResult(CommandInvalidInput, fieldErr.Error(), err)
+		}
+		return failedResult(CommandInternalError, fmt.Sprintf("could not update your %s, please try again later", field.Label), err)
 	}
-	return errors.New("successfully updated user info." + colName + " to " + cmd.Text)
+	return okResult("successfully updated your "+field.Label+" to "+field.Value(convo.UserInfo), SideEffectUserInfoUpdated)
 }
 
-func (cmd UpdateOrderCommand) Execute(db *sql.DB, convo *ConversationContext, isAutoInc bool) error {
-	updates, err := ParseUpdateOrderCommand(cmd.Text)
+func (cmd UpdateOrderCommand) Execute(store Store, convo *ConversationContext, isAutoInc bool) CommandResult {
+	updates, err := ParseUpdateOrderCommandByName(cmd.Text, convo.Pricelist.Catalogue)
 	if err != nil {
-		return fmt.Errorf("error parsing update answers command: %v", err)
+		var ambiguous *AmbiguousItemError
+		var unknown *UnknownItemError
+		var optionNeeded *OptionNeededError
+		switch {
+		case errors.As(err, &ambiguous):
+			return failedResult(CommandInvalidInput, ambiguous.Prompt(), err)
+		case errors.As(err, &unknown):
+			return failedResult(CommandInvalidInput, unknown.Error(), err)
+		case errors.As(err, &optionNeeded):
+			return failedResult(CommandInvalidInput, optionNeeded.Prompt(), err)
+		}
+		return failedResult(CommandInvalidInput, fmt.Sprintf("error parsing update answers command: %v", err), err)
 	}
 
-	err = convo.CurrentOrder.UpdateOrInsertCurrentOrder(db, convo.UserInfo.CellNumber, OrderItems{MenuIndications: updates}, isAutoInc)
+	err = ValidateOrderItems(OrderItems{MenuIndications: updates}, convo.Pricelist.Catalogue)
 	if err != nil {
-		return fmt.Errorf("unhandled error updating order: %v", err)
+		var invalid *OrderValidationError
+		if errors.As(err, &invalid) {
+			return failedResult(CommandInvalidInput, invalid.Feedback()+"\n\nYour order was not changed.", err)
+		}
+		return failedResult(CommandInternalError, fmt.Sprintf("unhandled error updating order: %v", err), err)
 	}
-	return errors.New("successfully updated current order")
+
+	before, err := convo.CurrentOrder.ApplyOrderUpdate(store.Orders, convo.UserInfo.CellNumber, OrderItems{MenuIndications: updates}, convo.Pricelist, isAutoInc)
+	if err != nil {
+		code := CommandInternalError
+		if errors.Is(err, ErrOrderNotEditable) {
+			code = CommandRejected
+		}
+		return failedResult(code, fmt.Sprintf("unhandled error updating order: %v", err), err)
+	}
+	diff := convo.CurrentOrder.diffFrom(before, convo.Pricelist.Catalogue)
+	return okResult("successfully updated current order\n"+diff.String()+"\n\n"+undoOrder, SideEffectOrderUpdated)
 }
 
-func (cmd QuestionCommand) Execute(db *sql.DB, convo *ConversationContext, isAutoInc bool) error {
-	return fmt.Errorf("%s", cmd.Text)
+func (cmd UndoOrderCommand) Execute(store Store, convo *ConversationContext, isAutoInc bool) CommandResult {
+	undone, err := convo.CurrentOrder.UndoLastUpdate(store.Orders, convo.UserInfo.CellNumber, convo.Pricelist, isAutoInc)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrNothingToUndo):
+			return failedResult(CommandInvalidInput, "Nothing to undo.", err)
+		case errors.Is(err, ErrOrderNotEditable):
+			return failedResult(CommandRejected, fmt.Sprintf("Your order is already %s, it can't be changed.", convo.CurrentOrder.Status.Label()), err)
+		}
+		return failedResult(CommandInternalError, fmt.Sprintf("unhandled error undoing the last change: %v", err), err)
+	}
+	diff := convo.CurrentOrder.diffFrom(undone, convo.Pricelist.Catalogue)
+	return okResult("undid your last change\n"+diff.String(), SideEffectOrderUpdated)
 }
 
-func BeginCheckout(db *sql.DB, ui UserInfo, ctlgselections []CatalogueSelection, c CustomerOrder, checkoutUrls CheckoutInfo, isAutoInc bool) string {
+func (cmd ApplyPromoCodeCommand) Execute(store Store, convo *ConversationContext, isAutoInc bool) CommandResult {
+	order := &convo.CurrentOrder
+	err := order.SetCurrentOrderFromDB(store.Orders, convo.UserInfo.CellNumber, isAutoInc)
+	if err != nil {
+		if errors.Is(err, ErrNoRows) {
+			return failedResult(CommandInvalidInput, "Please add something to your order before applying a code.", err)
+		}
+		return failedResult(CommandInternalError, fmt.Sprintf("unhandled error applying the code: %v", err), err)
+	}
+	if !order.Status.IsEditable() {
+		return failedResult(CommandRejected, fmt.Sprintf("Your order is already %s, it can't be changed.", order.Status.Label()), ErrOrderNotEditable)
+	}
+
+	code := NormalisePromoCode(cmd.Code)
+	if strings.EqualFold(code, "none") {
+		code = ""
+	} else {
+		_, err = CheckPromoCode(store, convo.UserInfo.CellNumber, code, time.Now())
+		if err != nil {
+			return promoCodeResult(code, err, "")
+		}
+	}
+
+	err = order.ApplyPromoCode(store.Orders, code, convo.Pricelist)
+	if err != nil {
+		return failedResult(CommandInternalError, fmt.Sprintf("unhandled error applying the code: %v", err), err)
+	}
+
+	reply := "Removed the discount code from your order."
+	if code != "" {
+		reply = "Applied code " + code + "."
+		if !order.hasDiscount(code) {
+			reply += " It doesn't take anything off your order yet."
+		}
+	}
+	return okResult(reply+"\n\n"+FormatCartSummary(order.OrderItems, convo.Pricelist.Catalogue, order.Pricing(convo.Pricelist)), SideEffectOrderUpdated)
+}
+
+func (cmd SetFulfilmentCommand) Execute(store Store, convo *ConversationContext, isAutoInc bool) CommandResult {
+	return setOrderFulfilment(store, convo, isAutoInc, cmd.Fulfilment, ParseDeliveryLocation(cmd.Location))
+}
+
+// Has the customer's current order delivered to the location or collected, the reply shows the new total.
+// A customer without a current order gets ErrNoRows.
+func setOrderFulfilment(store Store, convo *ConversationContext, isAutoInc bool, fulfilment Fulfilment, location DeliveryLocation) CommandResult {
+	delivery := convo.Pricelist.Delivery
+	reason := ""
+	switch {
+	case fulfilment == FulfilmentPickup && (delivery == nil || !delivery.Pickup):
+		reason = "Sorry, we don't do pickups."
+	case fulfilment == FulfilmentDelivery && (delivery == nil || len(delivery.Zones) == 0):
+		reason = "Sorry, we don't deliver."
+	case fulfilment == FulfilmentDelivery && location.IsZero():
+		return failedResult(CommandInvalidInput, "Please say where to deliver to, e.g. "+deliverToCommand, nil)
+	case fulfilment == FulfilmentDelivery:
+		if _, ok := delivery.ZoneFor(location); !ok {
+			reason = fmt.Sprintf("Sorry, we don't deliver to %s.", location)
+			if delivery.Pickup {
+				reason += "\nTo collect your order instead type & send-: " + pickupCommand
+			}
+		}
+	}
+	if reason != "" {
+		return failedResult(CommandRejected, reason, &FulfilmentError{Reason: reason})
+	}
+
+	order := &convo.CurrentOrder
+	err := order.SetCurrentOrderFromDB(store.Orders, convo.UserInfo.CellNumber, isAutoInc)
+	if err != nil {
+		if errors.Is(err, ErrNoRows) {
+			return failedResult(CommandInvalidInput, "Please add something to your order first.", err)
+		}
+		return failedResult(CommandInternalError, fmt.Sprintf("unhandled error choosing %s: %v", fulfilment, err), err)
+	}
+	if !order.Status.IsEditable() {
+		return failedResult(CommandRejected, fmt.Sprintf("Your order is already %s, it can't be changed.", order.Status.Label()), ErrOrderNotEditable)
+	}
+
+	err = order.SetFulfilment(store.Orders, fulfilment, location, convo.Pricelist)
+	if err != nil {
+		return failedResult(CommandInternalError, fmt.Sprintf("unhandled error choosing %s: %v", fulfilment, err), err)
+	}
+
+	reply := "Your order will be delivered to " + location.String() + "."
+	if fulfilment == FulfilmentPickup {
+		reply = "You'll collect your order."
+		if delivery.PickupAddress != "" {
+			reply = "You'll collect your order from " + delivery.PickupAddress + "."
+		}
+	}
+	return okResult(reply+"\n\n"+FormatCartSummary(order.OrderItems, convo.Pricelist.Catalogue, order.Pricing(convo.Pricelist)), SideEffectOrderUpdated)
+}
+
+func (cmd AddAddressCommand) Execute(store Store, convo *ConversationContext, isAutoInc bool) CommandResult {
+	if store.Addresses == nil {
+		return failedResult(CommandRejected, "Sorry, addresses can't be saved right now.", ErrNoAddressRepository)
+	}
+	address, err := ParseAddress(convo.UserInfo.CellNumber, cmd.Text)
+	if err != nil {
+		return failedResult(CommandInvalidInput, err.Error(), err)
+	}
+	_, err = store.Addresses.SaveAddress(address)
+	if err != nil {
+		return failedResult(CommandInternalError, "could not save your address, please try again later", err)
+	}
+	return okResult(fmt.Sprintf("Saved *%s*: %s\nTo deliver to it type & send-: use address: %s", address.Label, address, address.Label))
+}
+
+func (cmd UseAddressCommand) Execute(store Store, convo *ConversationContext, isAutoInc bool) CommandResult {
+	if store.Addresses == nil {
+		return failedResult(CommandRejected, "Sorry, addresses can't be saved right now.", ErrNoAddressRepository)
+	}
+	addresses, err := store.Addresses.ListAddresses(convo.UserInfo.CellNumber)
+	if err != nil {
+		return failedResult(CommandInternalError, "could not read your addresses, please try again later", err)
+	}
+	address, ok := FindAddress(addresses, cmd.Label)
+	if !ok {
+		return failedResult(CommandInvalidInput, fmt.Sprintf("You have no address %s.\n\n%s", cmd.Label, FormatAddressBook(addresses)), ErrNoRows)
+	}
+	return useAddress(store, convo, isAutoInc, address)
+}
+
+// Makes the address the customer's default and delivers their current order to it, if they have one.
+func useAddress(store Store, convo *ConversationContext, isAutoInc bool, address Address) CommandResult {
+	result := okResult(fmt.Sprintf("Orders will be delivered to *%s* from now on.", address.Label))
+	if convo.Pricelist.Delivery != nil {
+		result = setOrderFulfilment(store, convo, isAutoInc, FulfilmentDelivery, address.DeliveryLocation())
+		if errors.Is(result.Err, ErrNoRows) {
+			result = okResult(fmt.Sprintf("Orders will be delivered to *%s* from now on.", address.Label))
+		}
+		if !result.Success {
+			return result
+		}
+	}
+	err := store.Addresses.SetDefaultAddress(address.CellNumber, address.AddressID)
+	if err != nil {
+		return failedResult(CommandInternalError, "could not save your default address, please try again later", err)
+	}
+	return result
+}
+
+func (cmd RemoveAddressCommand) Execute(store Store, convo *ConversationContext, isAutoInc bool) CommandResult {
+	if store.Addresses == nil {
+		return failedResult(CommandRejected, "Sorry, addresses can't be saved right now.", ErrNoAddressRepository)
+	}
+	addresses, err := store.Addresses.ListAddresses(convo.UserInfo.CellNumber)
+	if err != nil {
+		return failedResult(CommandInternalError, "could not read your addresses, please try again later", err)
+	}
+	address, ok := FindAddress(addresses, cmd.Label)
+	if !ok {
+		return failedResult(CommandInvalidInput, fmt.Sprintf("You have no address %s.\n\n%s", cmd.Label, FormatAddressBook(addresses)), ErrNoRows)
+	}
+	err = store.Addresses.DeleteAddress(address.CellNumber, address.AddressID)
+	if err != nil {
+		return failedResult(CommandInternalError, "could not remove your address, please try again later", err)
+	}
+	return okResult(fmt.Sprintf("Removed *%s* from your addresses.", address.Label))
+}
+
+// Saves a shared location pin to the address book and delivers to it, the customer just told us where they are.
+func (cmd SharedLocationCommand) Execute(store Store, convo *ConversationContext, isAutoInc bool) CommandResult {
+	if store.Addresses == nil {
+		return failedResult(CommandRejected, "Sorry, locations can't be saved right now.", ErrNoAddressRepository)
+	}
+	address := AddressFromLocation(convo.UserInfo.CellNumber, cmd.Location)
+	addressID, err := store.Addresses.SaveAddress(address)
+	if err != nil {
+		return failedResult(CommandInternalError, "could not save your location, please try again later", err)
+	}
+	address.AddressID = addressID
+
+	result := useAddress(store, convo, isAutoInc, address)
+	result.Reply = fmt.Sprintf("Saved your location as *%s*.\n", address.Label) + result.Reply
+	return result
+}
+
+func (cmd OrderHistoryCommand) Execute(store Store, convo *ConversationContext, isAutoInc bool) CommandResult {
+	orders, err := store.Orders.ListOrders(convo.UserInfo.CellNumber, recentOrdersLimit)
+	if err != nil {
+		return failedResult(CommandInternalError, "could not read your orders, please try again later", err)
+	}
+	return okResult(FormatOrderHistory(orders))
+}
+
+func (cmd OrderDetailCommand) Execute(store Store, convo *ConversationContext, isAutoInc bool) CommandResult {
+	order, err := store.Orders.GetOrder(convo.UserInfo.CellNumber, cmd.OrderID)
+	if err != nil {
+		if errors.Is(err, ErrNoRows) {
+			return failedResult(CommandInvalidInput, fmt.Sprintf("You have no order %d, to list your orders type & send-: orders?", cmd.OrderID), err)
+		}
+		return failedResult(CommandInternalError, fmt.Sprintf("unhandled error reading order %d: %v", cmd.OrderID, err), err)
+	}
+	return okResult(fmt.Sprintf("Order #%d\n%s", order.OrderID, order.describe(convo.Pricelist)))
+}
+
+// Starts a new draft with the items of a past order, the customer's other open orders are left as they are.
+func (cmd ReorderCommand) Execute(store Store, convo *ConversationContext, isAutoInc bool) CommandResult {
+	cellNumber := convo.UserInfo.CellNumber
+	past, err := store.Orders.GetOrder(cellNumber, cmd.OrderID)
+	if err != nil {
+		if errors.Is(err, ErrNoRows) {
+			return failedResult(CommandInvalidInput, fmt.Sprintf("You have no order %d, to list your orders type & send-: orders?", cmd.OrderID), err)
+		}
+		return failedResult(CommandInternalError, fmt.Sprintf("unhandled error reading order %d: %v", cmd.OrderID, err), err)
+	}
+	open, err := store.Orders.GetCurrentOrder(cellNumber)
+	if err != nil && !errors.Is(err, ErrNoRows) {
+		return failedResult(CommandInternalError, fmt.Sprintf("unhandled error reordering order %d: %v", cmd.OrderID, err), err)
+	}
+
+	order := &convo.CurrentOrder
+	dropped, err := order.Reorder(store.Orders, past, convo.Pricelist, isAutoInc)
+	if err != nil {
+		var invalid *OrderValidationError
+		if errors.As(err, &invalid) {
+			return failedResult(CommandRejected, fmt.Sprintf("Nothing of order %d can be ordered right now.\n%s", cmd.OrderID, invalid.Feedback()), err)
+		}
+		return failedResult(CommandInternalError, fmt.Sprintf("unhandled error reordering order %d: %v", cmd.OrderID, err), err)
+	}
+
+	reply := fmt.Sprintf("The items of order %d are in your new order %d.", cmd.OrderID, order.OrderID)
+	for _, line := range dropped {
+		reply += fmt.Sprintf("\nLeft out %d: %s", line.ItemMenuNum, line.Reason)
+	}
+	if len(open.OrderItems.MenuIndications) > 0 {
+		reply += fmt.Sprintf("\nYour order %d is still open, to list your orders type & send-: orders?", open.OrderID)
+	}
+	return okResult(reply+"\n\n"+FormatCartSummary(order.OrderItems, convo.Pricelist.Catalogue, order.Pricing(convo.Pricelist)), SideEffectOrderUpdated)
+}
+
+// The customer's reply for a code CheckPromoCode turned down.
+func promoCodeResult(code string, err error, suffix string) CommandResult {
+	switch {
+	case errors.Is(err, ErrNoRows):
+		return failedResult(CommandInvalidInput, fmt.Sprintf("%s is not a valid code.", code)+suffix, err)
+	case errors.Is(err, ErrPromotionExpired):
+		return failedResult(CommandRejected, fmt.Sprintf("Code %s is not running right now.", code)+suffix, err)
+	case errors.Is(err, ErrPromotionUsedUp):
+		return failedResult(CommandRejected, fmt.Sprintf("You've already used code %s.", code)+suffix, err)
+	case errors.Is(err, ErrNoPromoRepository):
+		return failedResult(CommandRejected, "There are no discount codes right now."+suffix, err)
+	}
+	return failedResult(CommandInternalError, fmt.Sprintf("unhandled error checking code %s: %v", code, err), err)
+}
+
+func (cmd QuestionCommand) Execute(store Store, convo *ConversationContext, isAutoInc bool) CommandResult {
+	return okResult(cmd.Text)
+}
+
+func (cmd CheckoutCommand) Execute(store Store, convo *ConversationContext, isAutoInc bool) CommandResult {
+	return beginCheckout(store, convo.UserInfo, convo.Pricelist, convo.CurrentOrder, cmd.CheckoutInfo, isAutoInc)
+}
+
+func BeginCheckout(store Store, ui UserInfo, ctlgselections []CatalogueSelection, c CustomerOrder, checkoutUrls CheckoutInfo, isAutoInc bool) string {
+	prlst := Pricelist{Catalogue: ctlgselections, Promotions: customerPromotions(store.Orders, ui.CellNumber, ActivePromotions(store.Promotions))}.oneCurrency()
+	return beginCheckout(store, ui, prlst, c, checkoutUrls, isAutoInc).Reply
+}
+
+func beginCheckout(store Store, ui UserInfo, prlst Pricelist, c CustomerOrder, checkoutUrls CheckoutInfo, isAutoInc bool) CommandResult {
+	ctlgselections := prlst.Catalogue
 
 	// Create a new URL object for each URL
 	returnURL, _ := url.Parse(checkoutUrls.ReturnURL)
@@ -123,162 +466,206 @@ func BeginCheckout(db *sql.DB, ui UserInfo, ctlgselections []CatalogueSelection,
 	checkoutUrls.NotifyURL = notifyURL.String()
 
 	//Tally the order and then create a CheckoutCart struct
-	cartTotal, cartSummary, err := c.TallyOrder(db, ui.CellNumber, ctlgselections, isAutoInc)
+	cartTotal, _, err := c.TallyOrder(store.Orders, ui.CellNumber, prlst, isAutoInc)
+	if err != nil {
+		return failedResult(CommandInternalError, err.Error(), err)
+	}
+	if !c.Status.IsEditable() {
+		return failedResult(CommandRejected, fmt.Sprintf("Your order is already %s.", c.Status.Label()), ErrOrderNotEditable)
+	}
+	// Never charge for a cart that has lines the total leaves out
+	err = ValidateOrderItems(c.OrderItems, ctlgselections)
+	if err != nil {
+		var invalid *OrderValidationError
+		if errors.As(err, &invalid) {
+			return failedResult(CommandInvalidInput, invalid.Feedback()+"\n\nPlease fix these before checking out.\n"+deleteOrder, err)
+		}
+		return failedResult(CommandInternalError, "Checkout initiation failed", err)
+	}
+	if c.Status == OrderAwaitingPayment && c.OrderTotal.Currency != "" {
+		// Checkout already froze the total, asking again must not change what the customer pays
+		cartTotal = c.OrderTotal
+	} else {
+		if c.PromoCode != "" {
+			_, err = CheckPromoCode(store, ui.CellNumber, c.PromoCode, time.Now())
+			if err != nil {
+				return promoCodeResult(c.PromoCode, err, "\nTo checkout without it type & send-: "+applyCodeCommand+" none")
+			}
+		}
+		// Customers with a default address don't have to say where to deliver to
+		err = c.useDefaultAddress(store.Addresses, prlst.Delivery)
+		if err != nil {
+			return failedResult(CommandInternalError, "Checkout initiation failed", fmt.Errorf("reading the default address: %w", err))
+		}
+		pricing := c.priceOrder(prlst)
+		cartTotal = pricing.Total
+		c.keepPricing(pricing)
+		err = prlst.Delivery.CheckFulfilment(c, pricing)
+		if err != nil {
+			var fulfilment *FulfilmentError
+			if errors.As(err, &fulfilment) {
+				return failedResult(CommandRejected, fulfilment.Reason, err)
+			}
+			return failedResult(CommandInternalError, "Checkout initiation failed", err)
+		}
+		// The total and discounts are stored with the order, payment notifications are checked against them
+		c.OrderTotal = cartTotal
+		err = c.updateCurrentOrder(store.Orders)
+		if err != nil {
+			return failedResult(CommandInternalError, "Checkout initiation failed", fmt.Errorf("storing the order total before checkout: %w", err))
+		}
+	}
+	cartSummary := FormatCartSummary(c.OrderItems, ctlgselections, c.Pricing(prlst))
+	provider, err := NewPaymentProvider(checkoutUrls)
 	if err != nil {
-		return err.Error()
+		return failedResult(CommandInternalError, "Checkout initiation failed", fmt.Errorf("selecting the payment provider: %w", err))
+	}
+	// Hold the stock while the customer pays, checking out again renews the hold
+	err = reserveOrderStock(store.Stock, c, ctlgselections)
+	if err != nil {
+		var outOfStock *OutOfStockError
+		if errors.As(err, &outOfStock) {
+			return failedResult(CommandRejected, outOfStock.Feedback(ctlgselections)+"\n\nPlease change your order and checkout again.\n"+deleteOrder, err)
+		}
+		return failedResult(CommandInternalError, "Checkout initiation failed", fmt.Errorf("reserving stock for order %d: %w", c.OrderID, err))
+	}
+	wasDraft := c.Status == OrderDraft
+	err = c.TransitionTo(store.Orders, OrderAwaitingPayment)
+	if err != nil {
+		// An order already awaiting payment keeps the reservation its checkout link is paying for
+		if wasDraft && store.Stock != nil {
+			store.Stock.ReleaseStock(c.OrderID)
+		}
+		return failedResult(CommandInternalError, "Checkout initiation failed", fmt.Errorf("moving order %d to awaiting payment: %w", c.OrderID, err))
 	}
 	cart := CheckoutCart{
 		ItemName:      c.BuildItemName(checkoutUrls.ItemNamePrefix),
 		CartTotal:     cartTotal,
+		Lines:         c.checkoutLines(checkoutUrls.ItemNamePrefix),
 		OrderID:       c.OrderID,
 		CustFirstName: ui.NickName.String,
 		CustLastName:  ui.CellNumber,
 		CustEmail:     ui.Email.String}
-	return cartSummary + "/n/n" + ProcessPayment(cart, checkoutUrls)
-}
 
-func parseQuestionCommand(match string, db *sql.DB, convo *ConversationContext, checkoutUrls CheckoutInfo, isAutoInc bool) Command {
-	switch match {
-	case "currentorder?":
-		return QuestionCommand{Text: convo.CurrentOrder.GetCurrentOrderAsAString(db, convo.UserInfo.CellNumber, isAutoInc)}
-	case "shop?":
-		return QuestionCommand{Text: prclstPreamble + "\n\n" + AssembleCatalogueSelections(convo.Pricelist.PrlstPreamble, convo.Pricelist.Catalogue)}
-	case "userinfo?":
-		return QuestionCommand{Text: convo.UserInfo.GetUserInfoAsAString()}
-	case "checkoutnow?":
-		return QuestionCommand{Text: BeginCheckout(db, convo.UserInfo, convo.Pricelist.Catalogue, convo.CurrentOrder, checkoutUrls, isAutoInc)}
-	default:
-		return QuestionCommand{Text: mainMenu}
+	redirectURL, err := provider.CreateCheckout(cart)
+	if err != nil {
+		// Without a checkout link nobody can pay, so the order goes back to draft and its stock is released.
+		// An order that was already awaiting payment keeps its earlier link and reservation.
+		if wasDraft {
+			rollbackErr := c.TransitionTo(store.Orders, OrderDraft)
+			if rollbackErr != nil {
+				log.Printf("moving order %d back to draft after a failed checkout: %v", c.OrderID, rollbackErr)
+			}
+		}
+		return failedResult(CommandInternalError, cartSummary+"\n\n"+"Checkout initiation failed", fmt.Errorf("creating checkout for order %d: %w", c.OrderID, err))
 	}
+	return okResult(cartSummary+"\n\n"+redirectURL, SideEffectCheckoutStarted)
 }
 
-func (cc CommandCollection) ProcessCommands(convo *ConversationContext, db *sql.DB, isAutoInc bool) string {
-	var errors []string
+// Execute runs the commands in order and returns each one's result, failures are logged.
+func (cc CommandCollection) Execute(convo *ConversationContext, store Store, isAutoInc bool) []CommandResult {
+	results := make([]CommandResult, 0, len(cc))
 	for _, command := range cc {
-		err := command.Execute(db, convo, isAutoInc)
-		if err != nil {
-			errors = append(errors, err.Error())
-		}
+		result := command.Execute(store, convo, isAutoInc)
+		result.logFailure(fmt.Sprintf("command %T", command), convo.UserInfo.CellNumber)
+		results = append(results, result)
 	}
-	return strings.Join(errors, "\n")
+	return results
 }
 
-func GetResponseToMsg(convo *ConversationContext, db *sql.DB, checkoutUrls CheckoutInfo, isAutoInc bool) string {
-	commandRes := unhandledCommandException
-	commands := GetCommandsFromLastMessage(convo.MessageBody, convo, db, checkoutUrls, isAutoInc)
-	if len(commands) != 0 {
-		// Process commands
-		commandRes_Temp := CommandCollection(commands).ProcessCommands(convo, db, isAutoInc)
-		if commandRes_Temp != "" && commandRes_Temp != " " && commandRes_Temp != "\n" {
-			commandRes = commandRes_Temp
-		}
-	} else {
-		commandRes = noCommandText
+// ProcessCommands runs the commands and joins their replies, one per line.
+func (cc CommandCollection) ProcessCommands(convo *ConversationContext, store Store, isAutoInc bool) string {
+	var replies []string
+	for _, result := range cc.Execute(convo, store, isAutoInc) {
+		replies = append(replies, result.Reply)
 	}
-
-	if !convo.UserExisted {
-		if commandRes != noCommandText {
-			commandRes = smartyPantsGreeting + "\n\n" + commandRes + "\n\n" + reminderGreeting + "\n\n" + sayMenu
-		} else {
-			commandRes = coldGreeting + "\n\n" + reminderGreeting + "\n\n" + sayMenu
-		}
-	} else if commandRes == noCommandText {
-		commandRes += "\n\n" + sayMenu
-	}
-
-	convo.UserExisted = true
-
-	return commandRes
+	return strings.Join(replies, "\n")
 }
 
-// Precompile regular expressions
-var (
-	regexQuestionMark  = regexp.MustCompile(`(menu\?|fr\.prlist\?|userinfo\?|currentorder\?|checkoutnow\?)`)
-	regexUpdateField   = regexp.MustCompile(`(update email|update nickname|update social|update consent):\s*(\S*)`)
-	regexUpdateAnswers = regexp.MustCompile(`(update order):?\s*(.*)`)
-)
-
-func GetCommandsFromLastMessage(messageBody string, convo *ConversationContext, db *sql.DB, checkoutUrls CheckoutInfo, isAutoInc bool) []Command {
-	var commands []Command
-	messageBody = strings.ToLower(messageBody)
+// GetResponseToMsg answers the conversation's message with the DefaultCommands registry.
+func GetResponseToMsg(convo *ConversationContext, store Store, checkoutUrls CheckoutInfo, isAutoInc bool) string {
+	return DefaultCommands.Respond(convo, store, checkoutUrls, isAutoInc)
+}
 
-	// Use precompiled regular expressions
-	if matches := regexQuestionMark.FindAllStringSubmatch(messageBody, -1); matches != nil {
-		for _, match := range matches {
-			commands = append(commands, parseQuestionCommand(match[1], db, convo, checkoutUrls, isAutoInc))
-		}
-	}
+// GetCommandsFromLastMessage finds the DefaultCommands commands in the message, in the order they appear.
+func GetCommandsFromLastMessage(messageBody string, convo *ConversationContext, store Store, checkoutUrls CheckoutInfo, isAutoInc bool) []Command {
+	return DefaultCommands.Parse(messageBody, checkoutUrls)
+}
 
-	if matches := regexUpdateField.FindAllStringSubmatch(messageBody, -1); matches != nil {
-		for _, match := range matches {
-			commands = append(commands, UpdateUserInfoCommand{Name: match[1], Text: match[2]})
-		}
-	}
+// Amounts like "3x2" that continue the options of the item before them, as in "10:1x3, 3x2".
+var regexOptionAmount = regexp.MustCompile(`^\d+\s*x\s*\d+$`)
 
-	if matches := regexUpdateAnswers.FindAllStringSubmatch(messageBody, -1); matches != nil {
-		for _, match := range matches {
-			commands = append(commands, UpdateOrderCommand{Text: match[2]})
-		}
-	}
+// ParseUpdateOrderCommand parses "1:2, 10:1x3, 3x2" style updates keyed by price list number.
+func ParseUpdateOrderCommand(commandText string) ([]MenuIndication, error) {
+	return parseUpdateOrder(commandText, nil)
+}
 
-	return commands
+// ParseUpdateOrderCommandByName is ParseUpdateOrderCommand that also takes item names as keys,
+// "tshirt:2, mug:1", matched against the catalogue with MatchCatalogueItem.
+// A name matching several items returns an *AmbiguousItemError, a bare count for an item with
+// several options an *OptionNeededError.
+func ParseUpdateOrderCommandByName(commandText string, ctlgselections []CatalogueSelection) ([]MenuIndication, error) {
+	return parseUpdateOrder(commandText, func(key string) (CatalogueItem, error) {
+		return MatchCatalogueItem(key, ctlgselections)
+	})
 }
 
-func ParseUpdateOrderCommand(commandText string) ([]MenuIndication, error) {
+func parseUpdateOrder(commandText string, resolveName func(key string) (CatalogueItem, error)) ([]MenuIndication, error) {
 	// Remove "update order" prefix
 	commandText = strings.TrimPrefix(commandText, "update order")
-	commandText = strings.TrimPrefix(commandText, ":")
-	commandText = strings.TrimSpace(commandText)
-	commandText = strings.Replace(commandText, " ", "", 1)
-
-	// Regular expression to match "ItemMenuNum: ItemAmount" pairs
-	re := regexp.MustCompile(`\b\d+:\s*(?:\d+x\d+(?:,\s*)?)+`)
-
-	// Find all matches in the commandText
-	matches := re.FindAllString(commandText, -1)
+	commandText = strings.TrimPrefix(strings.TrimSpace(commandText), ":")
 
-	// Remove matched parts from the commandText
-	for k, match := range matches {
-		trimmedMatch := strings.TrimSpace(match)
-		trimmedMatch = strings.TrimSuffix(trimmedMatch, ",")
-		matches[k] = trimmedMatch
-		commandText = strings.Replace(commandText, match, "", 1)
-	}
-
-	// Trim any remaining whitespace or commas
-	commandText = strings.Trim(commandText, ",")
-
-	// Initialize slice to store OrderItems
 	var orderItems []MenuIndication
-
-	// Process each match
-	for _, match := range matches {
-		orderItem, err := parseOrderItem(match)
-		if err != nil {
-			return nil, err
+	for _, part := range strings.Split(commandText, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
 		}
-		orderItems = append(orderItems, orderItem)
-	}
 
-	// Process remaining commandText for simple "ItemMenuNum: ItemAmount" pairs
-	if commandText != "" {
-		remainingItems := strings.Split(commandText, ",")
-		for _, item := range remainingItems {
-			item = strings.TrimSpace(item)
-			if item == "" {
+		if !strings.Contains(part, ":") {
+			last := len(orderItems) - 1
+			if last >= 0 && regexOptionAmount.MatchString(part) && strings.Contains(orderItems[last].ItemAmount, "x") {
+				orderItems[last].ItemAmount += ", " + part
 				continue
 			}
-			orderItem, err := parseOrderItem(item)
+			return nil, fmt.Errorf("failed to parse item: %s", part)
+		}
+
+		orderItem, err := parseOrderItem(part)
+		if err != nil {
+			if resolveName == nil {
+				return nil, err
+			}
+			name, amount, _ := strings.Cut(part, ":")
+			item, err := resolveName(strings.TrimSpace(name))
+			if err != nil {
+				return nil, err
+			}
+			orderItem.ItemMenuNum = item.CatalogueItemID
+			orderItem.ItemAmount, err = namedItemAmount(item, strings.TrimSpace(amount))
 			if err != nil {
 				return nil, err
 			}
-			orderItems = append(orderItems, orderItem)
 		}
+		orderItems = append(orderItems, orderItem)
 	}
 
 	return orderItems, nil
 }
 
+// A bare count for a single item, "tshirt:2", means that many of its only option.
+// When the item has several options the customer has to say which one.
+func namedItemAmount(item CatalogueItem, amount string) (string, error) {
+	quantity, err := strconv.Atoi(amount)
+	if item.PricingType != SingleItem || err != nil {
+		return amount, nil
+	}
+	if len(item.Options) != 1 {
+		return "", &OptionNeededError{Item: item, Quantity: quantity}
+	}
+	return fmt.Sprintf("1x%d", quantity), nil
+}
+
 func parseOrderItem(item string) (MenuIndication, error) {
 	parts := strings.SplitN(item, ":", 2)
 	if len(parts) != 2 {
@@ -0,0 +1,48 @@
+package menubotlib
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseUpdateOrderCommandByName(t *testing.T) {
+	prlst := testPricelist()
+	prlst.Catalogue = append(prlst.Catalogue, CmpsCtlgSlctnsFromCtlgItms([]CatalogueItem{
+		{CatalogueID: "c1", CatalogueItemID: 4, Selection: "Merch", Item: "Mug", PricingType: SingleItem, Options: []CatalogueOption{
+			{Label: "mug", Threshold: 1, Price: NewMoney(8000, DefaultCurrency)},
+		}},
+	})...)
+
+	tests := []struct {
+		name       string
+		text       string
+		want       []MenuIndication
+		wantOption bool
+	}{
+		{"weight by name", "update order lemon haze:5", []MenuIndication{{ItemMenuNum: 1, ItemAmount: "5"}}, false},
+		{"count of the only option", "update order mug:2", []MenuIndication{{ItemMenuNum: 4, ItemAmount: "1x2"}}, false},
+		{"option given", "update order brownie:2x1", []MenuIndication{{ItemMenuNum: 3, ItemAmount: "2x1"}}, false},
+		{"count of several options", "update order brownie:2", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseUpdateOrderCommandByName(tt.text, prlst.Catalogue)
+			var optionNeeded *OptionNeededError
+			if errors.As(err, &optionNeeded) != tt.wantOption {
+				t.Fatalf("error = %v, want an option prompt %t", err, tt.wantOption)
+			}
+			if tt.wantOption {
+				if optionNeeded.Item.CatalogueItemID != 3 || optionNeeded.Quantity != 2 {
+					t.Errorf("prompt for %d x%d, want item 3 x2", optionNeeded.Item.CatalogueItemID, optionNeeded.Quantity)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !sameItems(OrderItems{MenuIndications: got}, OrderItems{MenuIndications: tt.want}) {
+				t.Errorf("parsed %v, want %v", got, tt.want)
+			}
+		})
+	}
+}